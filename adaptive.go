@@ -0,0 +1,74 @@
+package statpool
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// adaptiveFlush bounds the flush interval as it follows the traffic
+type adaptiveFlush struct {
+	min, max  time.Duration
+	threshold int
+}
+
+// SetAdaptiveFlush lets the pool change its flush interval with the
+// traffic, between min and max.  Once threshold stats are waiting the
+// interval drops to min, and it halves after each flush of at least
+// threshold stats, so bursts don't pile up in memory.  It doubles
+// after each flush with nothing to send, so a quiet pool rarely makes
+// requests.  A zero threshold turns it off, leaving the interval
+// where it is.
+func (p *Pool) SetAdaptiveFlush(min, max time.Duration, threshold int) {
+	if threshold <= 0 || min <= 0 {
+		p.adaptive.Store((*adaptiveFlush)(nil))
+		return
+	}
+	if max < min {
+		max = min
+	}
+	p.adaptive.Store(&adaptiveFlush{min: min, max: max, threshold: threshold})
+}
+
+// FlushInterval is the time between flushes, as last set or adapted
+func (p *Pool) FlushInterval() time.Duration {
+	return time.Duration(atomic.LoadInt64(&p.interval))
+}
+
+// adaptBacklog shortens the interval to the minimum once pending stats
+// reach the threshold
+func (p *Pool) adaptBacklog(pending int) {
+	a, _ := p.adaptive.Load().(*adaptiveFlush)
+	if a == nil || pending < a.threshold || p.FlushInterval() <= a.min {
+		return
+	}
+	p.SetFlushInterval(a.min)
+}
+
+// adaptFlushed halves the interval after a busy flush and doubles it
+// after an empty one
+func (p *Pool) adaptFlushed(flushed int) {
+
+	a, _ := p.adaptive.Load().(*adaptiveFlush)
+	if a == nil {
+		return
+	}
+
+	interval := p.FlushInterval()
+	next := interval
+	switch {
+	case flushed >= a.threshold:
+		next = interval / 2
+	case flushed == 0:
+		next = interval * 2
+	}
+	if next < a.min {
+		next = a.min
+	}
+	if next > a.max {
+		next = a.max
+	}
+	if next != interval {
+		p.SetFlushInterval(next)
+	}
+
+}
@@ -0,0 +1,71 @@
+package statpool
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// Aggregation is how the values of a key recorded during a flush
+// interval are combined before they're sent
+type Aggregation int32
+
+const (
+	AggregateDefault Aggregation = iota // the pool's aggregation, All unless set
+	AggregateAll                        // send every value
+	AggregateSum                        // send the sum of the values
+	AggregateAvg                        // send the mean of the values
+	AggregateMin                        // send the smallest value
+	AggregateMax                        // send the largest value
+	AggregateLast                       // send the most recent value
+)
+
+// SetAggregation sets how values are combined for keys that don't
+// set an Aggregate in their KeyOptions.  Gauges, histograms and
+// sampled keys are unaffected.
+func (p *Pool) SetAggregation(a Aggregation) {
+	atomic.StoreInt32((*int32)(&p.aggregation), int32(a))
+}
+
+// aggregationFor is the aggregation applied to the key's values
+func (p *Pool) aggregationFor(opts KeyOptions) Aggregation {
+	if opts.Aggregate != AggregateDefault {
+		return opts.Aggregate
+	}
+	if a := Aggregation(atomic.LoadInt32((*int32)(&p.aggregation))); a != AggregateDefault {
+		return a
+	}
+	return AggregateAll
+}
+
+// aggregate combines the values of a key in place in the stat
+// reported for it
+type aggregate struct {
+	how  Aggregation
+	stat *ValueStat
+	sum  float64
+	n    int
+}
+
+func newAggregate(how Aggregation, v *ValueStat) *aggregate {
+	return &aggregate{how: how, stat: v, sum: v.Value, n: 1}
+}
+
+func (a *aggregate) add(v *ValueStat) {
+	a.sum += v.Value
+	a.n++
+	switch a.how {
+	case AggregateSum:
+		a.stat.Value = a.sum
+	case AggregateAvg:
+		a.stat.Value = a.sum / float64(a.n)
+	case AggregateMin:
+		a.stat.Value = math.Min(a.stat.Value, v.Value)
+	case AggregateMax:
+		a.stat.Value = math.Max(a.stat.Value, v.Value)
+	case AggregateLast:
+		a.stat.Value = v.Value
+	}
+	if v.Timestamp > a.stat.Timestamp {
+		a.stat.Timestamp = v.Timestamp
+	}
+}
@@ -0,0 +1,190 @@
+package statpool
+
+// aggregator merges the stats of a flush interval.  The pool's
+// goroutine has one, as does each shard.
+type aggregator struct {
+	p *Pool
+
+	values  []interface{}
+	counts  map[string]*CountStat
+	gauges  map[string]*ValueStat
+	samples map[string]*reservoir
+	aggs    map[string]*aggregate
+	uniques map[string]*hyperLogLog
+	updated map[string]bool
+
+	// kept across intervals, and while the goroutine is parked
+	totals map[string]float64
+	latest map[string]float64
+}
+
+func newAggregator(p *Pool) *aggregator {
+	a := &aggregator{
+		p:      p,
+		totals: map[string]float64{},
+		latest: map[string]float64{},
+	}
+	a.reset()
+	return a
+}
+
+func (a *aggregator) reset() {
+	a.values = []interface{}{}
+	a.counts = map[string]*CountStat{}
+	a.gauges = map[string]*ValueStat{}
+	a.samples = map[string]*reservoir{}
+	a.aggs = map[string]*aggregate{}
+	a.uniques = map[string]*hyperLogLog{}
+	a.updated = map[string]bool{}
+}
+
+// pending is how many stats are waiting to be sent
+func (a *aggregator) pending() int {
+	return len(a.values)
+}
+
+func (a *aggregator) addCount(v *CountStat) {
+	k := aggKey(v.ezKey, v.Key)
+	if stat, exists := a.counts[k]; exists {
+		stat.Count += v.Count
+		freeCount(v)
+		return
+	}
+	a.counts[k] = v
+	a.values = append(a.values, v)
+}
+
+func (a *aggregator) addValue(v *ValueStat) {
+
+	k := aggKey(v.ezKey, v.Key)
+	if v.unique {
+		h, exists := a.uniques[k]
+		if !exists {
+			h = &hyperLogLog{}
+			a.uniques[k] = h
+		}
+		h.add(v.member)
+		freeValue(v)
+		return
+	}
+
+	opts := a.p.keyOptions(v.Key)
+	if opts.RepeatLast {
+		a.latest[k] = v.Value
+		a.updated[k] = true
+	}
+
+	if v.gauge {
+		if stat, exists := a.gauges[k]; exists {
+			stat.Value, stat.Timestamp = v.Value, v.Timestamp
+			freeValue(v)
+		} else {
+			a.gauges[k] = v
+			a.values = append(a.values, v)
+		}
+	} else if v.histogram || opts.samples() > 0 {
+		r, exists := a.samples[k]
+		if !exists {
+			r = newKeyReservoir(opts, v.histogram)
+			a.samples[k] = r
+		}
+		r.add(v)
+	} else if how := a.p.aggregationFor(opts); how == AggregateAll {
+		a.values = append(a.values, v)
+	} else if agg, exists := a.aggs[k]; exists {
+		agg.add(v)
+		freeValue(v)
+	} else {
+		a.aggs[k] = newAggregate(how, v)
+		a.values = append(a.values, v)
+	}
+
+}
+
+func (a *aggregator) addBatch(stats []interface{}) {
+	for _, v := range stats {
+		switch v := v.(type) {
+		case *CountStat:
+			a.addCount(v)
+		case *ValueStat:
+			a.addValue(v)
+		}
+	}
+}
+
+// drain aggregates the stats already queued when it's called, so a
+// rotation includes them however busy the channels are
+func (a *aggregator) drain(count chan *CountStat, value chan *ValueStat, batch chan []interface{}) {
+counts:
+	for n := len(count); n > 0; n-- {
+		select {
+		case v := <-count:
+			a.addCount(v)
+		default:
+			break counts
+		}
+	}
+values:
+	for n := len(value); n > 0; n-- {
+		select {
+		case v := <-value:
+			a.addValue(v)
+		default:
+			break values
+		}
+	}
+	for n := len(batch); n > 0; n-- {
+		select {
+		case b := <-batch:
+			a.addBatch(b)
+		default:
+			return
+		}
+	}
+}
+
+// rotate returns the stats of the interval and starts the next
+func (a *aggregator) rotate() []interface{} {
+
+	now := a.p.now().Unix()
+	// convert cumulative counters to running totals
+	for k, stat := range a.counts {
+		if a.p.keyOptions(stat.Key).Cumulative {
+			a.totals[k] += stat.Count
+			stat.Count = a.totals[k]
+		}
+	}
+	// include capped samples or their summaries
+	for k, r := range a.samples {
+		if r.summarize {
+			ezKey, key := splitAggKey(k)
+			for _, v := range r.summary(key, now) {
+				v.(*ValueStat).ezKey = ezKey
+				a.values = append(a.values, v)
+			}
+			continue
+		}
+		for _, v := range r.samples {
+			a.values = append(a.values, v)
+		}
+	}
+	// estimate unique counts
+	for k, h := range a.uniques {
+		ezKey, key := splitAggKey(k)
+		a.values = append(a.values, &ValueStat{Key: key, Value: h.estimate(), Timestamp: now, ezKey: ezKey})
+	}
+	// repeat the last value of gauges that weren't updated
+	for k, val := range a.latest {
+		ezKey, key := splitAggKey(k)
+		if !a.p.keyOptions(key).RepeatLast {
+			delete(a.latest, k)
+		} else if !a.updated[k] {
+			a.values = append(a.values, &ValueStat{Key: key, Value: val, Timestamp: now, ezKey: ezKey})
+		}
+	}
+
+	stats := a.values
+	a.reset()
+	return stats
+
+}
@@ -0,0 +1,141 @@
+package statpool
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// how many released batches can be queued per aggregator
+const batchBuffer = 64
+
+// Batch collects stats in a local buffer and hands them to the pool
+// in one operation on Release, saving a channel send per stat in
+// tight loops.  Counts of the same key are summed as they're added.
+// A Batch is for use by one goroutine, and can be reused after
+// Release.
+type Batch struct {
+	p      *Pool
+	counts map[string]*CountStat
+	stats  []interface{}
+}
+
+// Batch returns an empty batch for the pool
+func (p *Pool) Batch() *Batch {
+	return &Batch{p: p, counts: map[string]*CountStat{}}
+}
+
+func (b *Batch) Count(key string, val float64) {
+	if c, exists := b.counts[key]; exists {
+		c.Count += val
+		return
+	}
+	stat := newCountStat(b.p.prefix+key, val)
+	b.counts[key] = stat
+	b.stats = append(b.stats, stat)
+}
+
+func (b *Batch) Value(key string, val float64, timestamp time.Time) {
+	b.stats = append(b.stats, newValueStat(ValueStat{Key: b.p.prefix + key, Value: val, Timestamp: timestamp.Unix()}))
+}
+
+func (b *Batch) Duration(key string, val time.Duration) {
+	b.stats = append(b.stats, newValueStat(ValueStat{Key: b.p.prefix + key, Value: float64(val) / float64(time.Millisecond)}))
+}
+
+func (b *Batch) Gauge(key string, val float64) {
+	b.stats = append(b.stats, newValueStat(ValueStat{Key: b.p.prefix + key, Value: val, Timestamp: b.p.now().Unix(), gauge: true}))
+}
+
+// Release hands the batch's stats to the pool and empties it.  If the
+// pool is backed up the stats are dropped together unless the drop
+// policy is Block.
+func (b *Batch) Release() {
+
+	p := b.p
+	if !p.Enabled() {
+		b.counts = map[string]*CountStat{}
+		b.stats = nil
+		return
+	}
+	groups := make([][]interface{}, len(p.shards)+1)
+	for _, v := range b.stats {
+		var key string
+		switch v := v.(type) {
+		case *CountStat:
+			if !p.admitCount(v) {
+				continue
+			}
+			key = v.Key
+		case *ValueStat:
+			if !p.admitValue(v) {
+				continue
+			}
+			key = v.Key
+		}
+		i := p.shardIndex(key)
+		groups[i] = append(groups[i], v)
+	}
+
+	for i, stats := range groups {
+		if len(stats) == 0 {
+			continue
+		}
+		published := make([]Stat, len(stats))
+		for j, v := range stats {
+			published[j] = batchStat(v)
+		}
+		if !p.queueBatch(p.batchFor(i), stats) {
+			for _, s := range published {
+				p.drop(s)
+				p.record(s.Type, s.Key, s.Value, "dropped: channel full")
+			}
+			continue
+		}
+		p.ensureRunning()
+		for _, s := range published {
+			p.publish(s)
+			p.record(s.Type, s.Key, s.Value, "accepted")
+		}
+	}
+
+	b.counts = map[string]*CountStat{}
+	b.stats = nil
+
+}
+
+// queueBatch queues a batch, waiting for room only under the Block
+// policy, and reports whether it was queued
+func (p *Pool) queueBatch(ch chan []interface{}, stats []interface{}) bool {
+
+	select {
+	case ch <- stats:
+		return true
+	default:
+	}
+
+	if DropPolicy(atomic.LoadInt32((*int32)(&p.dropPolicy))) != Block {
+		return false
+	}
+	p.ensureRunning()
+	timeout := time.Duration(atomic.LoadInt64(&p.blockTimeout))
+	if timeout <= 0 {
+		ch <- stats
+		return true
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case ch <- stats:
+		return true
+	case <-timer.C:
+		return false
+	}
+
+}
+
+func batchStat(v interface{}) Stat {
+	if c, ok := v.(*CountStat); ok {
+		return countStat(c)
+	}
+	return valueStat(v.(*ValueStat))
+}
@@ -0,0 +1,125 @@
+package statpool
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of a pool's circuit breaker
+type BreakerState int32
+
+const (
+	BreakerClosed   BreakerState = iota // flushes are sent
+	BreakerOpen                         // flushes are held or spooled without sending
+	BreakerHalfOpen                     // the next flush is sent to probe the endpoint
+)
+
+// ErrCircuitOpen is returned by flushes that weren't sent because
+// the circuit breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker open, flush not sent")
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// breaker opens after consecutive failed flushes and lets a flush
+// through every probe interval to see if the endpoint has recovered
+type breaker struct {
+	failures int
+	probe    time.Duration
+	onChange func(from, to BreakerState)
+
+	state    BreakerState
+	failed   int
+	openedAt time.Time
+	mu       sync.Mutex
+}
+
+// SetCircuitBreaker stops sending after failures consecutive flushes
+// fail entirely.  While open, flushes are spooled if there is a spool
+// and otherwise held, up to the hold limit, and a flush is let through
+// every probeInterval to check whether the endpoint has recovered.
+// Zero failures disables the breaker.
+func (p *Pool) SetCircuitBreaker(failures int, probeInterval time.Duration) {
+	p.breaker.mu.Lock()
+	p.breaker.failures = failures
+	p.breaker.probe = probeInterval
+	p.breaker.mu.Unlock()
+}
+
+// OnStateChange calls fn when the circuit breaker changes state, for
+// alerting on outages.  It must be set before the pool is used.
+func (p *Pool) OnStateChange(fn func(from, to BreakerState)) {
+	p.breaker.onChange = fn
+}
+
+// allow reports whether a flush may be sent, half opening the breaker
+// once the probe interval has passed
+func (b *breaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	from := b.state
+	switch {
+	case b.failures <= 0 || b.state == BreakerClosed:
+		b.mu.Unlock()
+		return true
+	case b.state == BreakerOpen && now.Sub(b.openedAt) >= b.probe:
+		b.state = BreakerHalfOpen
+	default:
+		// open, or half open with a probe already in flight
+		b.mu.Unlock()
+		return false
+	}
+	b.mu.Unlock()
+	b.changed(from, BreakerHalfOpen)
+	return true
+}
+
+// closed reports whether allow would let a flush through, without
+// changing the state
+func (b *breaker) closed(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures <= 0 || b.state == BreakerClosed || b.state == BreakerOpen && now.Sub(b.openedAt) >= b.probe
+}
+
+// result records whether a flush that was sent failed
+func (b *breaker) result(failed bool, now time.Time) {
+	b.mu.Lock()
+	from, to := b.state, b.state
+	switch {
+	case b.failures <= 0:
+	case !failed:
+		b.failed = 0
+		to = BreakerClosed
+	case b.state == BreakerHalfOpen:
+		to, b.openedAt = BreakerOpen, now
+	default:
+		if b.failed++; b.failed >= b.failures {
+			to, b.openedAt = BreakerOpen, now
+		}
+	}
+	b.state = to
+	b.mu.Unlock()
+	b.changed(from, to)
+}
+
+func (b *breaker) current() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *breaker) changed(from, to BreakerState) {
+	if from != to && b.onChange != nil {
+		b.onChange(from, to)
+	}
+}
@@ -0,0 +1,46 @@
+package statpool
+
+import (
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stat keys derived from caller pcs
+var callerKeys sync.Map
+
+// callerKey names the function skip frames above its caller, e.g.
+// github.com/user/pkg.(*Server).Handle becomes pkg.Server.Handle
+func callerKey(skip int) string {
+
+	pc, _, _, ok := runtime.Caller(skip + 1)
+	if !ok {
+		return "unknown"
+	}
+	if key, ok := callerKeys.Load(pc); ok {
+		return key.(string)
+	}
+
+	name := "unknown"
+	if fn := runtime.FuncForPC(pc); fn != nil {
+		name = fn.Name()
+		if i := strings.LastIndex(name, "/"); i >= 0 {
+			name = name[i+1:]
+		}
+		name = strings.NewReplacer("(*", "", ")", "").Replace(name)
+	}
+
+	callerKeys.Store(pc, name)
+	return name
+
+}
+
+// TimeCaller records the time since start under the
+// name of the calling function.
+func (p *Pool) TimeCaller(start time.Time) {
+	if !p.Enabled() {
+		return
+	}
+	p.Duration(callerKey(1), p.now().Sub(start))
+}
@@ -0,0 +1,114 @@
+package statpool
+
+import (
+	"strings"
+	"sync"
+)
+
+type CardinalityPolicy int
+
+const (
+	DropNewKeys CardinalityPolicy = iota // drop stats with keys over the limit
+	FoldNewKeys                          // report them under OtherKey
+)
+
+const (
+	// stats with keys over the limit are folded into this key
+	OtherKey = "other"
+
+	// stats with keys over the limit are counted under this key
+	suppressedKeysKey = "statpool.keys_suppressed"
+)
+
+// cardinalityGuard tracks the keys accepted against the limits
+type cardinalityGuard struct {
+	perInterval int
+	total       int
+	policy      CardinalityPolicy
+
+	interval   map[string]struct{}
+	seen       map[string]struct{}
+	suppressed int
+	mu         sync.Mutex
+}
+
+// SetCardinalityLimit caps the distinct keys accepted in each flush
+// interval at perInterval, and over the life of the pool at total,
+// so a mistake like user ids in keys can't flood the account.  Stats
+// with keys past either limit are dropped or folded into OtherKey by
+// policy, and counted under statpool.keys_suppressed in the next
+// flush.  Keys under another ez key count apart from the pool's own.
+// Zero means no limit.
+func (p *Pool) SetCardinalityLimit(perInterval, total int, policy CardinalityPolicy) {
+	if perInterval <= 0 && total <= 0 {
+		p.cardinality.Store((*cardinalityGuard)(nil))
+		return
+	}
+	p.cardinality.Store(&cardinalityGuard{
+		perInterval: perInterval,
+		total:       total,
+		policy:      policy,
+		interval:    map[string]struct{}{},
+		seen:        map[string]struct{}{},
+	})
+}
+
+// withinCardinality checks key against the cardinality limits,
+// folding it if needed, and reports whether the stat should be kept
+func (p *Pool) withinCardinality(ezKey string, key *string) bool {
+
+	g, _ := p.cardinality.Load().(*cardinalityGuard)
+	if g == nil {
+		return true
+	}
+
+	name := strings.TrimPrefix(*key, p.prefix)
+	if strings.HasPrefix(name, "statpool.") || name == OtherKey || name == UndeclaredKey {
+		return true
+	}
+
+	k := aggKey(ezKey, *key)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, exists := g.interval[k]; exists {
+		return true
+	}
+	_, known := g.seen[k]
+	if (g.perInterval > 0 && len(g.interval) >= g.perInterval) || (g.total > 0 && !known && len(g.seen) >= g.total) {
+		g.suppressed++
+		if g.policy == FoldNewKeys {
+			*key = p.prefix + OtherKey
+			return true
+		}
+		return false
+	}
+
+	g.interval[k] = struct{}{}
+	if g.total > 0 {
+		g.seen[k] = struct{}{}
+	}
+	return true
+
+}
+
+// resetCardinality starts a new interval of keys, counting the stats
+// suppressed in the last
+func (p *Pool) resetCardinality() {
+
+	g, _ := p.cardinality.Load().(*cardinalityGuard)
+	if g == nil {
+		return
+	}
+
+	g.mu.Lock()
+	g.interval = map[string]struct{}{}
+	suppressed := g.suppressed
+	g.suppressed = 0
+	g.mu.Unlock()
+
+	if suppressed > 0 {
+		p.SendCount(&CountStat{Key: p.prefix + suppressedKeysKey, Count: float64(suppressed)})
+	}
+
+}
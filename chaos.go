@@ -0,0 +1,144 @@
+package statpool
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// FaultInjector is an http.Handler for integration tests that puts
+// failures in front of a stat receiver (a Server or a fake StatHat
+// endpoint) so retry and backoff behavior can be exercised.  Sender
+// puts the same failures in front of a Sender.
+// Each rate is the probability of that fault for a single request.
+type FaultInjector struct {
+	Next http.Handler
+
+	// respond 500 without reaching Next
+	ErrorRate float64
+
+	// respond 429 with a Retry-After of RetryAfter
+	ThrottleRate float64
+	RetryAfter   time.Duration
+
+	// pass the request to Next but report failure anyway, the
+	// ambiguous case where data arrived but the sender can't tell
+	PartialRate float64
+
+	// delay every request by Latency plus up to Jitter
+	Latency time.Duration
+	Jitter  time.Duration
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func NewFaultInjector(next http.Handler, seed int64) *FaultInjector {
+	return &FaultInjector{Next: next, rand: rand.New(rand.NewSource(seed))}
+}
+
+type fault int
+
+const (
+	noFault fault = iota
+	errorFault
+	throttleFault
+	partialFault
+)
+
+// roll picks the fault for a request and how long to delay it
+func (f *FaultInjector) roll() (fault, time.Duration) {
+
+	f.mu.Lock()
+	if f.rand == nil {
+		f.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	var (
+		roll  = f.rand.Float64()
+		delay = f.Latency
+	)
+	if f.Jitter > 0 {
+		delay += time.Duration(f.rand.Int63n(int64(f.Jitter)))
+	}
+	f.mu.Unlock()
+
+	switch {
+	case roll < f.ErrorRate:
+		return errorFault, delay
+	case roll < f.ErrorRate+f.ThrottleRate:
+		return throttleFault, delay
+	case roll < f.ErrorRate+f.ThrottleRate+f.PartialRate:
+		return partialFault, delay
+	}
+	return noFault, delay
+
+}
+
+func (f *FaultInjector) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+
+	fault, delay := f.roll()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+
+	switch fault {
+	case errorFault:
+		http.Error(w, "injected error", http.StatusInternalServerError)
+
+	case throttleFault:
+		w.Header().Set("Retry-After", strconv.Itoa(int(f.RetryAfter/time.Second)))
+		http.Error(w, "injected throttle", http.StatusTooManyRequests)
+
+	case partialFault:
+		// a 5xx rather than a rejected payload, so it is retried
+		f.Next.ServeHTTP(discardResponse{}, req)
+		http.Error(w, "injected partial failure", http.StatusInternalServerError)
+
+	default:
+		f.Next.ServeHTTP(w, req)
+	}
+
+}
+
+// Sender returns a Sender that injects faults in front of next.  A
+// Sender can't pause the pool, so throttles are retried with backoff
+// like other failures.
+func (f *FaultInjector) Sender(next Sender) Sender {
+	return SenderFunc(func(ctx context.Context, stats []interface{}) error {
+
+		fault, delay := f.roll()
+		if delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return &TransportError{ctx.Err()}
+			}
+		}
+
+		switch fault {
+		case errorFault:
+			return fmt.Errorf("injected error: %w", &HTTPStatusError{http.StatusInternalServerError})
+		case throttleFault:
+			return fmt.Errorf("injected throttle: %w", &HTTPStatusError{http.StatusTooManyRequests})
+		case partialFault:
+			if err := next.Send(ctx, stats); err != nil {
+				return err
+			}
+			return fmt.Errorf("injected partial failure: %w", &HTTPStatusError{http.StatusInternalServerError})
+		}
+		return next.Send(ctx, stats)
+
+	})
+}
+
+// discardResponse lets Next process a request whose
+// response is replaced by an injected failure
+type discardResponse struct{}
+
+func (d discardResponse) Header() http.Header         { return http.Header{} }
+func (d discardResponse) Write(b []byte) (int, error) { return len(b), nil }
+func (d discardResponse) WriteHeader(int)             {}
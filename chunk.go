@@ -0,0 +1,45 @@
+package statpool
+
+import (
+	"encoding/json"
+	"sync/atomic"
+)
+
+// SetMaxPayloadBytes splits flushes into chunks whose json encoding
+// is at most n bytes, as well as at most the chunk size in stats, so
+// long keys don't make requests the endpoint rejects.  A stat larger
+// than n on its own is sent alone.  Zero only limits by chunk size.
+func (p *Pool) SetMaxPayloadBytes(n int) {
+	atomic.StoreInt64(&p.maxPayload, int64(n))
+}
+
+// chunk splits values into the stats sent in each request
+func (p *Pool) chunk(values []interface{}, ezKey string) [][]interface{} {
+
+	var (
+		chunks   [][]interface{}
+		limit    = atomic.LoadInt64(&p.maxPayload)
+		envelope = int64(len(`{"ezkey":"","data":[]}`) + len(ezKey) + 1)
+	)
+
+	for len(values) > 0 {
+		n := len(values)
+		if n > p.chunkSize {
+			n = p.chunkSize
+		}
+		if limit > 0 {
+			size := envelope
+			for i := 0; i < n; i++ {
+				data, _ := json.Marshal(values[i])
+				if size += int64(len(data)) + 1; size > limit && i > 0 {
+					n = i
+					break
+				}
+			}
+		}
+		chunks = append(chunks, values[:n])
+		values = values[n:]
+	}
+	return chunks
+
+}
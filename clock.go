@@ -0,0 +1,38 @@
+package statpool
+
+import "time"
+
+// Clock is the time source of a pool, so tests can control the
+// timestamps of stats and when flushes happen
+type Clock interface {
+	Now() time.Time
+
+	// Ticker ticks every d until stop is called, dropping ticks
+	// that aren't taken like a time.Ticker
+	Ticker(d time.Duration) (c <-chan time.Time, stop func())
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Ticker(d time.Duration) (<-chan time.Time, func()) {
+	t := time.NewTicker(d)
+	return t.C, t.Stop
+}
+
+// SetClock sets the pool's time source, the wall clock by default.
+// Flush jitter and alignment only apply to the wall clock.  It must
+// be called before the pool is used.
+func (p *Pool) SetClock(c Clock) {
+	if c == nil {
+		c = realClock{}
+	}
+	p.clock = c
+}
+
+func (p *Pool) now() time.Time {
+	return p.clock.Now()
+}
@@ -0,0 +1,154 @@
+// statpool-jsonschema generates typed stat key constants and their
+// Describe registrations from a json schema file.  It reads json
+// only, and generates Go source rather than any description of the
+// payloads sent, json or binary.
+//
+//	//go:generate statpool-jsonschema -schema metrics.json
+//
+// The schema lists each stat with its type (count, value or duration)
+// and optionally a unit, description and Go constant name:
+//
+//	{
+//	  "package": "metrics",
+//	  "metrics": [
+//	    {"name": "api.requests", "type": "count", "unit": "requests", "description": "requests served"},
+//	    {"name": "db.query", "type": "duration", "const": "DBQuery"}
+//	  ]
+//	}
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"strings"
+	"unicode"
+
+	"github.com/jasonmoo/statpool"
+)
+
+type (
+	schema struct {
+		Package string   `json:"package"`
+		Metrics []metric `json:"metrics"`
+	}
+
+	metric struct {
+		statpool.Description
+		Const string `json:"const,omitempty"`
+	}
+)
+
+var (
+	schemaFile = flag.String("schema", "metrics.json", "json schema file")
+	output     = flag.String("output", "metrics_gen.go", "output file")
+	pkgName    = flag.String("package", "", "package name (overrides the schema)")
+)
+
+func main() {
+
+	log.SetFlags(0)
+	log.SetPrefix("statpool-jsonschema: ")
+	flag.Parse()
+
+	data, err := ioutil.ReadFile(*schemaFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var s schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		log.Fatalf("%s: %s", *schemaFile, err)
+	}
+	if *pkgName != "" {
+		s.Package = *pkgName
+	}
+
+	src, err := generate(&s)
+	if err != nil {
+		log.Fatalf("%s: %s", *schemaFile, err)
+	}
+
+	if err := ioutil.WriteFile(*output, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+
+}
+
+var keyTypes = map[statpool.MetricType]string{
+	statpool.CountMetric:    "statpool.CountKey",
+	statpool.ValueMetric:    "statpool.ValueKey",
+	statpool.DurationMetric: "statpool.DurationKey",
+}
+
+var metricTypes = map[statpool.MetricType]string{
+	statpool.CountMetric:    "statpool.CountMetric",
+	statpool.ValueMetric:    "statpool.ValueMetric",
+	statpool.DurationMetric: "statpool.DurationMetric",
+}
+
+func generate(s *schema) ([]byte, error) {
+
+	if s.Package == "" {
+		return nil, fmt.Errorf("no package name given")
+	}
+
+	var (
+		consts   = &bytes.Buffer{}
+		register = &bytes.Buffer{}
+		seen     = map[string]string{}
+	)
+
+	for _, m := range s.Metrics {
+		keyType, ok := keyTypes[m.Type]
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown type %q", m.Key, m.Type)
+		}
+		name := m.Const
+		if name == "" {
+			name = identifier(m.Key)
+		}
+		if other, exists := seen[name]; exists {
+			return nil, fmt.Errorf("%s and %s both generate %s, set a const name", other, m.Key, name)
+		}
+		seen[name] = m.Key
+
+		if m.Help != "" {
+			fmt.Fprintf(consts, "// %s %s\n", name, m.Help)
+		}
+		fmt.Fprintf(consts, "%s %s = %q\n", name, keyType, m.Key)
+		fmt.Fprintf(register, "statpool.Describe(statpool.Description{Key: %q, Type: %s, Unit: %q, Help: %q})\n", m.Key, metricTypes[m.Type], m.Unit, m.Help)
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "// Code generated by statpool-jsonschema; DO NOT EDIT.\n\n")
+	fmt.Fprintf(buf, "package %s\n\n", s.Package)
+	fmt.Fprintf(buf, "import \"github.com/jasonmoo/statpool\"\n\n")
+	fmt.Fprintf(buf, "const (\n%s)\n\n", consts)
+	fmt.Fprintf(buf, "func init() {\n%s}\n", register)
+
+	return format.Source(buf.Bytes())
+
+}
+
+// identifier converts a stat key like api.requests_total
+// to an exported Go name like ApiRequestsTotal
+func identifier(key string) string {
+	var b strings.Builder
+	for _, part := range strings.FieldsFunc(key, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		r := []rune(part)
+		r[0] = unicode.ToUpper(r[0])
+		b.WriteString(string(r))
+	}
+	name := b.String()
+	if name == "" || unicode.IsDigit([]rune(name)[0]) {
+		name = "Stat" + name
+	}
+	return name
+}
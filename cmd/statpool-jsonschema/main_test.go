@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+
+	var s schema
+	if err := json.Unmarshal([]byte(`{
+		"package": "metrics",
+		"metrics": [
+			{"name": "api.requests", "type": "count", "unit": "requests", "description": "requests served"},
+			{"name": "db.query", "type": "duration", "const": "DBQuery"},
+			{"name": "5xx", "type": "value"}
+		]
+	}`), &s); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := generate(&s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := parser.ParseFile(token.NewFileSet(), "metrics_gen.go", src, 0); err != nil {
+		t.Fatalf("generated invalid source: %s\n%s", err, src)
+	}
+
+	// ignore gofmt alignment
+	flat := strings.Join(strings.Fields(string(src)), " ")
+
+	for _, expected := range []string{
+		`ApiRequests statpool.CountKey = "api.requests"`,
+		`DBQuery statpool.DurationKey = "db.query"`,
+		`Stat5xx statpool.ValueKey = "5xx"`,
+		`statpool.Describe(statpool.Description{Key: "api.requests", Type: statpool.CountMetric, Unit: "requests", Help: "requests served"})`,
+	} {
+		if !strings.Contains(flat, expected) {
+			t.Errorf("Expected generated source to contain %q:\n%s", expected, src)
+		}
+	}
+
+	s.Metrics[0].Type = "gauge"
+	if _, err := generate(&s); err == nil {
+		t.Error("Expected an error for an unknown type")
+	}
+
+}
@@ -0,0 +1,54 @@
+// statpool-load emits synthetic stats through a Pool so flush
+// settings and relays can be capacity tested before rollout.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/jasonmoo/statpool"
+)
+
+var (
+	endpoint = flag.String("endpoint", "http://localhost:8126", "endpoint to flush to, usually a relay")
+	ezKey    = flag.String("ezkey", os.Getenv("STATHAT_EZKEY"), "StatHat ez key")
+	interval = flag.Duration("interval", 10*time.Second, "pool flush interval")
+
+	prefix   = flag.String("prefix", "load.", "key prefix")
+	keys     = flag.Int("keys", 100, "number of distinct keys")
+	rate     = flag.Int("rate", 1000, "stats per second")
+	duration = flag.Duration("duration", time.Minute, "how long to generate load")
+	workers  = flag.Int("workers", 4, "concurrent emitting goroutines")
+
+	counts    = flag.Float64("counts", 1, "relative weight of counts")
+	values    = flag.Float64("values", 1, "relative weight of values")
+	durations = flag.Float64("durations", 1, "relative weight of durations")
+)
+
+func main() {
+
+	flag.Parse()
+
+	pool := statpool.NewPool(*endpoint, *ezKey, *interval)
+
+	log.Printf("emitting %d stats/s over %d keys for %s", *rate, *keys, *duration)
+	result := statpool.GenerateLoad(pool, statpool.LoadConfig{
+		Prefix:         *prefix,
+		Keys:           *keys,
+		Rate:           *rate,
+		Duration:       *duration,
+		Workers:        *workers,
+		CountWeight:    *counts,
+		ValueWeight:    *values,
+		DurationWeight: *durations,
+	})
+
+	start := time.Now()
+	pool.Stop()
+
+	log.Printf("emitted %d stats (%d counts, %d values, %d durations) in %s, final flush took %s",
+		result.Total(), result.Counts, result.Values, result.Durations, result.Elapsed, time.Since(start))
+
+}
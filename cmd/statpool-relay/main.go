@@ -0,0 +1,111 @@
+// statpool-relay aggregates stats from many hosts and forwards
+// them to StatHat in a single stream.  Hosts report to it with a
+// Pool pointed at the relay's http address, or with any statsd
+// or Graphite client.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/jasonmoo/statpool"
+)
+
+var (
+	httpAddr     = flag.String("http", ":8126", "statpool http listen address (empty to disable)")
+	statsdAddr   = flag.String("statsd", ":8125", "statsd udp listen address (empty to disable)")
+	graphiteAddr = flag.String("graphite", ":2003", "graphite tcp listen address (empty to disable)")
+
+	endpoint = flag.String("endpoint", statpool.DefaultStathatEndpoint, "endpoint to forward aggregated stats to")
+	ezKey    = flag.String("ezkey", os.Getenv("STATHAT_EZKEY"), "StatHat ez key")
+	interval = flag.Duration("interval", 10*time.Second, "flush interval")
+	prefix   = flag.String("prefix", "", "prefix for all forwarded keys")
+	binary   = flag.Bool("binary", false, "forward using the binary encoding (for relay chains)")
+	compress = flag.Bool("gzip", false, "gzip forwarded payloads")
+	spoolDir = flag.String("spool", "", "directory to spool stats that can't be forwarded (empty to disable)")
+	spoolMax = flag.Int64("spool-max", 64<<20, "most bytes kept in the spool")
+	retries  = flag.Int("retries", 3, "retries of a failed forward before it's spooled")
+	backoff  = flag.Duration("backoff", time.Second, "delay before the first retry, doubled for each after")
+
+	shutdownTimeout = flag.Duration("shutdown-timeout", 30*time.Second, "longest to wait for the final flush")
+)
+
+func main() {
+
+	flag.Parse()
+
+	if *ezKey == "" {
+		log.Fatal("an ez key is required (-ezkey or STATHAT_EZKEY)")
+	}
+
+	pool, err := newPool()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server := statpool.NewServer(pool)
+
+	if *httpAddr != "" {
+		l, err := net.Listen("tcp", *httpAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Println("accepting statpool payloads on", l.Addr())
+		go func() { log.Fatal(http.Serve(l, server)) }()
+	}
+
+	if *statsdAddr != "" {
+		conn, err := net.ListenPacket("udp", *statsdAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Println("accepting statsd packets on", conn.LocalAddr())
+		go func() { log.Fatal(server.ServeStatsd(conn)) }()
+	}
+
+	if *graphiteAddr != "" {
+		l, err := net.Listen("tcp", *graphiteAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Println("accepting graphite lines on", l.Addr())
+		go func() { log.Fatal(server.ServeGraphite(l)) }()
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	<-sig
+
+	log.Println("flushing and shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := pool.StopContext(ctx); err != nil {
+		log.Println("final flush abandoned:", err)
+	}
+
+}
+
+// newPool creates the pool stats are forwarded with from the flags
+func newPool() (*statpool.Pool, error) {
+	pool := statpool.NewPool(*endpoint, *ezKey, *interval)
+	pool.SetPrefix(*prefix)
+	pool.SetBinaryEncoding(*binary)
+	pool.SetGzip(*compress)
+	pool.SetRetryPolicy(statpool.RetryPolicy{
+		MaxAttempts: *retries + 1,
+		BaseDelay:   *backoff,
+		MaxDelay:    *interval,
+		Jitter:      0.2,
+	})
+	if err := pool.SetSpool(*spoolDir, *spoolMax); err != nil {
+		return nil, err
+	}
+	return pool, nil
+}
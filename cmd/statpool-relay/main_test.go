@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jasonmoo/statpool"
+)
+
+func TestRelayRetries(t *testing.T) {
+
+	var requests int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"status":200,"msg":"ok"}`))
+	}))
+	defer upstream.Close()
+
+	*endpoint, *ezKey, *interval = upstream.URL, "relay@example.com", time.Hour
+	*backoff = 10 * time.Millisecond
+	pool, err := newPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Stop()
+
+	pool.Count("darts", 1)
+	time.Sleep(10 * time.Millisecond)
+	if err := pool.Flush(); err != nil {
+		t.Errorf("Expected the retry to succeed, got: %v", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("Expected: 2 requests, got: %d", n)
+	}
+
+}
+
+func TestRelayPrefix(t *testing.T) {
+
+	keys := make(chan string, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, stats, err := statpool.DecodePayload(req.Header.Get("Content-Type"), req.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		for _, stat := range stats {
+			keys <- stat.(*statpool.CountStat).Key
+		}
+		w.Write([]byte(`{"status":200,"msg":"ok"}`))
+	}))
+	defer upstream.Close()
+
+	*endpoint, *ezKey, *interval, *prefix = upstream.URL, "relay@example.com", time.Hour, "relay."
+	defer func() { *prefix = "" }()
+	pool, err := newPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	relay := httptest.NewServer(statpool.NewServer(pool))
+	defer relay.Close()
+
+	host := statpool.NewPool(relay.URL, "relay@example.com", time.Hour)
+	host.Count("darts", 1)
+	if err := host.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	go pool.Stop()
+
+	select {
+	case key := <-keys:
+		if key != "relay.darts" {
+			t.Errorf("Expected: relay.darts, got: %s", key)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the stat forwarded")
+	}
+
+}
@@ -0,0 +1,216 @@
+// statpool-wrap generates a decorator for an interface that reports
+// per-method call counts, durations and errors to a statpool.Stater.
+//
+//	//go:generate statpool-wrap -type Store
+//
+// emits a StoreStats type in store_stats.go.  NewStoreStats(next, stats)
+// wraps any Store and reports store.<Method>.calls, store.<Method> and
+// store.<Method>.errors for methods whose last result is an error.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	typeName = flag.String("type", "", "interface to wrap (required)")
+	dir      = flag.String("dir", ".", "package directory containing the interface")
+	output   = flag.String("output", "", "output file (default <type>_stats.go)")
+	prefix   = flag.String("prefix", "", "stat key prefix (default lowercased type name)")
+)
+
+func main() {
+
+	log.SetFlags(0)
+	log.SetPrefix("statpool-wrap: ")
+	flag.Parse()
+
+	if *typeName == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *prefix == "" {
+		*prefix = strings.ToLower(*typeName)
+	}
+	if *output == "" {
+		*output = filepath.Join(*dir, strings.ToLower(*typeName)+"_stats.go")
+	}
+
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, *dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Files {
+			src, err := generate(fset, file, *typeName, *prefix)
+			if err == errNotFound {
+				continue
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err := ioutil.WriteFile(*output, src, 0644); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
+	log.Fatalf("interface %s not found in %s", *typeName, *dir)
+
+}
+
+var errNotFound = fmt.Errorf("interface not found")
+
+// generate emits the decorator source for the named
+// interface if it is declared in file
+func generate(fset *token.FileSet, file *ast.File, name, prefix string) ([]byte, error) {
+
+	var iface *ast.InterfaceType
+	ast.Inspect(file, func(n ast.Node) bool {
+		if spec, ok := n.(*ast.TypeSpec); ok && spec.Name.Name == name {
+			iface, _ = spec.Type.(*ast.InterfaceType)
+		}
+		return iface == nil
+	})
+	if iface == nil {
+		return nil, errNotFound
+	}
+
+	var (
+		buf     = &bytes.Buffer{}
+		wrapper = name + "Stats"
+		used    = map[string]bool{}
+		expr    = func(e ast.Expr) string {
+			ast.Inspect(e, func(n ast.Node) bool {
+				if sel, ok := n.(*ast.SelectorExpr); ok {
+					if id, ok := sel.X.(*ast.Ident); ok {
+						used[id.Name] = true
+					}
+				}
+				return true
+			})
+			var b bytes.Buffer
+			printer.Fprint(&b, fset, e)
+			return b.String()
+		}
+		methods = &bytes.Buffer{}
+	)
+
+	for _, m := range iface.Methods.List {
+		fn, ok := m.Type.(*ast.FuncType)
+		if !ok || len(m.Names) == 0 {
+			return nil, fmt.Errorf("%s: embedded interfaces are not supported", fset.Position(m.Pos()))
+		}
+		method := m.Names[0].Name
+
+		var params, args, results, returns []string
+		for _, field := range fn.Params.List {
+			n := len(field.Names)
+			if n == 0 {
+				n = 1
+			}
+			for i := 0; i < n; i++ {
+				arg := "a" + strconv.Itoa(len(args))
+				if ellipsis, ok := field.Type.(*ast.Ellipsis); ok {
+					params = append(params, arg+" ..."+expr(ellipsis.Elt))
+					arg += "..."
+				} else {
+					params = append(params, arg+" "+expr(field.Type))
+				}
+				args = append(args, arg)
+			}
+		}
+
+		errResult := ""
+		if fn.Results != nil {
+			for _, field := range fn.Results.List {
+				n := len(field.Names)
+				if n == 0 {
+					n = 1
+				}
+				for i := 0; i < n; i++ {
+					r := "r" + strconv.Itoa(len(returns))
+					results = append(results, r+" "+expr(field.Type))
+					returns = append(returns, r)
+				}
+			}
+			if last := fn.Results.List[len(fn.Results.List)-1]; expr(last.Type) == "error" {
+				errResult = returns[len(returns)-1]
+			}
+		}
+
+		key := prefix + "." + method
+		fmt.Fprintf(methods, "\nfunc (w *%s) %s(%s) (%s) {\n", wrapper, method, strings.Join(params, ", "), strings.Join(results, ", "))
+		fmt.Fprintf(methods, "start := time.Now()\n")
+		call := fmt.Sprintf("w.next.%s(%s)", method, strings.Join(args, ", "))
+		if len(returns) > 0 {
+			call = strings.Join(returns, ", ") + " = " + call
+		}
+		fmt.Fprintln(methods, call)
+		fmt.Fprintf(methods, "w.stats.Count(%q, 1)\n", key+".calls")
+		fmt.Fprintf(methods, "w.stats.Duration(%q, time.Since(start))\n", key)
+		if errResult != "" {
+			fmt.Fprintf(methods, "if %s != nil {\nw.stats.Count(%q, 1)\n}\n", errResult, key+".errors")
+		}
+		if len(returns) > 0 {
+			fmt.Fprintf(methods, "return %s\n", strings.Join(returns, ", "))
+		}
+		fmt.Fprintln(methods, "}")
+	}
+
+	// keep the imports referenced by the method signatures
+	var (
+		std      = []string{strconv.Quote("time")}
+		external = []string{strconv.Quote("github.com/jasonmoo/statpool")}
+	)
+	for _, spec := range file.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		local := filepath.Base(path)
+		if spec.Name != nil {
+			local = spec.Name.Name
+		}
+		if used[local] && path != "time" {
+			imp := spec.Path.Value
+			if spec.Name != nil {
+				imp = spec.Name.Name + " " + imp
+			}
+			if strings.Contains(strings.Split(path, "/")[0], ".") {
+				external = append(external, imp)
+			} else {
+				std = append(std, imp)
+			}
+		}
+	}
+	sort.Strings(std)
+	sort.Strings(external)
+
+	fmt.Fprintf(buf, "// Code generated by statpool-wrap; DO NOT EDIT.\n\n")
+	fmt.Fprintf(buf, "package %s\n\n", file.Name.Name)
+	fmt.Fprintf(buf, "import (\n%s\n\n%s\n)\n\n", strings.Join(std, "\n"), strings.Join(external, "\n"))
+	fmt.Fprintf(buf, "// %s reports call counts, durations and errors for each %s method.\n", wrapper, name)
+	fmt.Fprintf(buf, "type %s struct {\nnext %s\nstats statpool.Stater\n}\n\n", wrapper, name)
+	fmt.Fprintf(buf, "func New%s(next %s, stats statpool.Stater) *%s {\nreturn &%s{next: next, stats: stats}\n}\n", wrapper, name, wrapper, wrapper)
+	buf.Write(methods.Bytes())
+
+	return format.Source(buf.Bytes())
+
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+)
+
+const source = `package store
+
+import (
+	"context"
+	"io"
+)
+
+type Store interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Put(ctx context.Context, key string, r io.Reader) error
+	Keys(prefixes ...string) []string
+	Close()
+}
+`
+
+func TestGenerate(t *testing.T) {
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "store.go", source, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := generate(fset, file, "Store", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := parser.ParseFile(token.NewFileSet(), "store_stats.go", src, 0); err != nil {
+		t.Fatalf("generated invalid source: %s\n%s", err, src)
+	}
+
+	for _, expected := range []string{
+		`"context"`,
+		`"io"`,
+		`func (w *StoreStats) Get(a0 context.Context, a1 string) (r0 []byte, r1 error) {`,
+		`w.stats.Count("store.Get.errors", 1)`,
+		`w.next.Keys(a0...)`,
+		`w.stats.Duration("store.Close", time.Since(start))`,
+	} {
+		if !strings.Contains(string(src), expected) {
+			t.Errorf("Expected generated source to contain %q:\n%s", expected, src)
+		}
+	}
+
+	if _, err := generate(fset, file, "Missing", "missing"); err != errNotFound {
+		t.Errorf("Expected: %v, got: %v", errNotFound, err)
+	}
+
+}
@@ -0,0 +1,178 @@
+package statpool
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Config is the configuration of a pool that can be loaded from a
+// file and changed while it runs
+type Config struct {
+	// prefix of all keys
+	Prefix string `json:"prefix" yaml:"prefix"`
+
+	// flush interval, e.g. "30s"
+	FlushInterval string `json:"flush_interval" yaml:"flush_interval"`
+
+	// rates the Sampled methods use for keys, overriding the rates
+	// passed to them.  Keys are as reported, including the prefix.
+	SampleRates map[string]float64 `json:"sample_rates" yaml:"sample_rates"`
+
+	// patterns of the keys accepted, see SetFilter
+	Allow []string `json:"allow" yaml:"allow"`
+	Deny  []string `json:"deny" yaml:"deny"`
+}
+
+var (
+	configDecoders   = map[string]func(data []byte, c *Config) error{".json": decodeJSONConfig}
+	configDecodersMu sync.RWMutex
+)
+
+func decodeJSONConfig(data []byte, c *Config) error {
+	return json.Unmarshal(data, c)
+}
+
+// RegisterConfigDecoder decodes config files with the extension ext,
+// e.g. ".yaml", with fn.  JSON is built in, import statpoolyaml for
+// YAML.
+func RegisterConfigDecoder(ext string, fn func(data []byte, c *Config) error) {
+	configDecodersMu.Lock()
+	configDecoders[strings.ToLower(ext)] = fn
+	configDecodersMu.Unlock()
+}
+
+// ReadConfig reads the config file at path, decoded by its extension
+func ReadConfig(path string) (*Config, error) {
+
+	ext := strings.ToLower(filepath.Ext(path))
+	configDecodersMu.RLock()
+	decode, exists := configDecoders[ext]
+	configDecodersMu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("no config decoder for %q files", ext)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{}
+	if err := decode(data, c); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return c, nil
+
+}
+
+// ApplyConfig configures the pool with c.  Like SetPrefix it must be
+// called before the pool is used.
+func (p *Pool) ApplyConfig(c *Config) error {
+	if err := p.applyRuntimeConfig(c); err != nil {
+		return err
+	}
+	p.SetPrefix(c.Prefix)
+	return nil
+}
+
+// applyRuntimeConfig applies the parts of c that can change while
+// the pool is in use
+func (p *Pool) applyRuntimeConfig(c *Config) error {
+	interval, err := c.flushInterval()
+	if err != nil {
+		return err
+	}
+	if err := p.SetFilter(c.Allow, c.Deny); err != nil {
+		return err
+	}
+	p.SetSampleRates(c.SampleRates)
+	if interval > 0 {
+		p.SetFlushInterval(interval)
+	}
+	return nil
+}
+
+func (c *Config) flushInterval() (time.Duration, error) {
+	if c.FlushInterval == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(c.FlushInterval)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid flush interval: %q", c.FlushInterval)
+	}
+	return d, nil
+}
+
+// WatchConfig applies the config file at path, then reloads it
+// whenever it changes, checking every interval, and on SIGHUP, until
+// stop is called.  Like ApplyConfig it must be called before the pool
+// is used.  Reloads change the flush interval, sampling rates and
+// filters, changes to the prefix are reported and need a restart.
+// Errors reloading are reported and leave the last good config in
+// place.
+func (p *Pool) WatchConfig(path string, interval time.Duration) (stop func(), err error) {
+
+	initial, err := ReadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.ApplyConfig(initial); err != nil {
+		return nil, err
+	}
+
+	var (
+		done    = make(chan struct{})
+		hup     = make(chan os.Signal, 1)
+		modTime time.Time
+		size    int64
+	)
+	if info, err := os.Stat(path); err == nil {
+		modTime, size = info.ModTime(), info.Size()
+	}
+	signal.Notify(hup, syscall.SIGHUP)
+
+	reload := func() {
+		c, err := ReadConfig(path)
+		if err == nil {
+			err = p.applyRuntimeConfig(c)
+		}
+		if err != nil {
+			p.report(fmt.Errorf("config not reloaded: %w", err), 0)
+			return
+		}
+		if c.Prefix != initial.Prefix {
+			p.report(fmt.Errorf("config prefix changes need a restart"), 0)
+		}
+	}
+
+	go func() {
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-tick.C:
+				info, err := os.Stat(path)
+				if err != nil || info.ModTime().Equal(modTime) && info.Size() == size {
+					continue
+				}
+				modTime, size = info.ModTime(), info.Size()
+				reload()
+			case <-hup:
+				reload()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }, nil
+
+}
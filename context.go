@@ -0,0 +1,19 @@
+package statpool
+
+import "context"
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying s
+func NewContext(ctx context.Context, s Stater) context.Context {
+	return context.WithValue(ctx, contextKey{}, s)
+}
+
+// FromContext returns the Stater carried by ctx, or the Default
+// (a NilPool unless SetDefault was called) when it has none
+func FromContext(ctx context.Context) Stater {
+	if s, ok := ctx.Value(contextKey{}).(Stater); ok {
+		return s
+	}
+	return Default()
+}
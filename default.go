@@ -0,0 +1,44 @@
+package statpool
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// defaultStater holds the Stater used by the package level functions
+var defaultStater atomic.Value
+
+type staterHolder struct{ s Stater }
+
+// SetDefault sets the Stater the package level Count, Value and
+// Duration report to, so libraries can record stats without one
+// being passed in.  A nil s restores the default NilPool.
+func SetDefault(s Stater) {
+	if s == nil {
+		s = NilPool{}
+	}
+	defaultStater.Store(staterHolder{s})
+}
+
+// Default returns the Stater set with SetDefault, a NilPool if unset
+func Default() Stater {
+	if h, ok := defaultStater.Load().(staterHolder); ok {
+		return h.s
+	}
+	return NilPool{}
+}
+
+// Count counts val for key with the default Stater
+func Count(key string, val float64) {
+	Default().Count(key, val)
+}
+
+// Value records val for key with the default Stater
+func Value(key string, val float64, timestamp time.Time) {
+	Default().Value(key, val, timestamp)
+}
+
+// Duration records val for key with the default Stater
+func Duration(key string, val time.Duration) {
+	Default().Duration(key, val)
+}
@@ -0,0 +1,146 @@
+package statpool
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+type DropPolicy int32
+
+const (
+	DropNewest DropPolicy = iota // drop the stat being sent
+	DropOldest                   // drop the oldest queued stat to make room
+	Block                        // wait for room, up to the block timeout
+)
+
+// SetDropPolicy controls what happens to stats sent while the
+// channels are backed up.  The timeout limits how long Block waits
+// before dropping the stat, zero waits as long as it takes.
+func (p *Pool) SetDropPolicy(policy DropPolicy, timeout time.Duration) {
+	atomic.StoreInt64(&p.blockTimeout, int64(timeout))
+	atomic.StoreInt32((*int32)(&p.dropPolicy), int32(policy))
+}
+
+// OnDrop calls fn with each stat dropped because the channels were
+// backed up.  It must be set before the pool is used.
+func (p *Pool) OnDrop(fn func(Stat)) {
+	p.onDrop = fn
+}
+
+// queueCount queues the stat following the drop policy, or waits
+// for room when block is set, and reports whether it was queued
+func (p *Pool) queueCount(stat *CountStat, block bool) bool {
+
+	ch, _ := p.shardFor(stat.Key)
+	select {
+	case ch <- stat:
+		return true
+	default:
+	}
+
+	policy := DropPolicy(atomic.LoadInt32((*int32)(&p.dropPolicy)))
+	switch {
+	case block || policy == Block:
+		p.ensureRunning()
+		timeout := time.Duration(atomic.LoadInt64(&p.blockTimeout))
+		if block || timeout <= 0 {
+			ch <- stat
+			return true
+		}
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case ch <- stat:
+			return true
+		case <-timer.C:
+		}
+
+	case policy == DropOldest:
+		for {
+			select {
+			case ch <- stat:
+				return true
+			default:
+			}
+			select {
+			case old := <-ch:
+				p.drop(countStat(old))
+				freeCount(old)
+			default:
+			}
+		}
+	}
+
+	p.drop(countStat(stat))
+	return false
+
+}
+
+// queueValue is queueCount for values
+func (p *Pool) queueValue(stat *ValueStat, block bool) bool {
+
+	_, ch := p.shardFor(stat.Key)
+	select {
+	case ch <- stat:
+		return true
+	default:
+	}
+
+	policy := DropPolicy(atomic.LoadInt32((*int32)(&p.dropPolicy)))
+	switch {
+	case block || policy == Block:
+		p.ensureRunning()
+		timeout := time.Duration(atomic.LoadInt64(&p.blockTimeout))
+		if block || timeout <= 0 {
+			ch <- stat
+			return true
+		}
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case ch <- stat:
+			return true
+		case <-timer.C:
+		}
+
+	case policy == DropOldest:
+		for {
+			select {
+			case ch <- stat:
+				return true
+			default:
+			}
+			select {
+			case old := <-ch:
+				p.drop(valueStat(old))
+				freeValue(old)
+			default:
+			}
+		}
+	}
+
+	p.drop(valueStat(stat))
+	return false
+
+}
+
+func (p *Pool) drop(stat Stat) {
+	atomic.AddInt64(&p.counters.dropped, 1)
+	p.report(fmt.Errorf("channels backed up, dropping stat: %+v", stat), 1)
+	if p.onDrop != nil {
+		p.onDrop(stat)
+	}
+}
+
+func countStat(stat *CountStat) Stat {
+	return Stat{Key: stat.Key, Type: CountType, Value: stat.Count, Time: time.Now(), Tags: stat.Tags}
+}
+
+func valueStat(stat *ValueStat) Stat {
+	s := Stat{Key: stat.Key, Type: ValueType, Value: stat.Value, Time: time.Now(), Tags: stat.Tags}
+	if stat.Timestamp != 0 {
+		s.Time = time.Unix(stat.Timestamp, 0)
+	}
+	return s
+}
@@ -0,0 +1,42 @@
+package statpool
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+)
+
+// dryRunSender writes the json payloads that would be posted
+type dryRunSender struct {
+	p  *Pool
+	w  io.Writer
+	mu sync.Mutex
+}
+
+// SetDryRun aggregates, chunks and encodes stats as usual but writes
+// each json payload to w, one per line, rather than posting it, to
+// check exactly what would be reported.  Setting nil posts again.
+func (p *Pool) SetDryRun(w io.Writer) {
+	if w == nil {
+		p.SetSender(nil)
+		return
+	}
+	p.SetSender(&dryRunSender{p: p, w: w})
+}
+
+func (s *dryRunSender) Send(ctx context.Context, stats []interface{}) error {
+
+	buf := &bytes.Buffer{}
+	if _, err := encodePayload(buf, s.p.ezKeyFor(ctx), stats, false); err != nil {
+		return permanentError{&EncodeError{err}}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.w.Write(buf.Bytes()); err != nil {
+		return permanentError{err}
+	}
+	return nil
+
+}
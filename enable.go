@@ -0,0 +1,19 @@
+package statpool
+
+import "sync/atomic"
+
+// Disable turns the pool's stat methods into no-ops until Enable is
+// called, so reporting can be switched by a feature flag without
+// swapping the Stater.  Stats already queued are still flushed.
+func (p *Pool) Disable() {
+	atomic.StoreInt32(&p.disabled, 1)
+}
+
+func (p *Pool) Enable() {
+	atomic.StoreInt32(&p.disabled, 0)
+}
+
+// Enabled reports whether the pool is accepting stats
+func (p *Pool) Enabled() bool {
+	return atomic.LoadInt32(&p.disabled) == 0
+}
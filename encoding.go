@@ -0,0 +1,211 @@
+package statpool
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"sync/atomic"
+)
+
+const (
+	ContentTypeJSON   = "application/json"
+	ContentTypeBinary = "application/x-statpool-gob"
+)
+
+var ErrUnsupportedContentType = errors.New("unsupported content type")
+
+type (
+	// binaryPayload is the compact relay encoding.  Keys are sent
+	// once in a dictionary and stats refer to them by index.
+	binaryPayload struct {
+		EZKey  string
+		Keys   []string
+		Counts []binaryStat
+		Values []binaryStat
+	}
+
+	binaryStat struct {
+		Key       uint32
+		Value     float64
+		Timestamp int64
+	}
+
+	// jsonStat decodes either stat type from a json payload
+	jsonStat struct {
+		Key       string   `json:"stat"`
+		Count     *float64 `json:"count"`
+		Value     *float64 `json:"value"`
+		Timestamp int64    `json:"t"`
+	}
+)
+
+// encodePayload writes the chunk to buf in the requested encoding
+// and returns its content type
+func encodePayload(buf *bytes.Buffer, ezKey string, chunk []interface{}, binary bool) (string, error) {
+
+	if !binary {
+		return ContentTypeJSON, json.NewEncoder(buf).Encode(&statPayload{
+			EZKey: ezKey,
+			Data:  chunk,
+		})
+	}
+
+	var (
+		payload = &binaryPayload{EZKey: ezKey}
+		index   = map[string]uint32{}
+		lookup  = func(key string) uint32 {
+			i, exists := index[key]
+			if !exists {
+				i = uint32(len(payload.Keys))
+				index[key] = i
+				payload.Keys = append(payload.Keys, key)
+			}
+			return i
+		}
+	)
+
+	for _, val := range chunk {
+		switch stat := val.(type) {
+		case *CountStat:
+			payload.Counts = append(payload.Counts, binaryStat{lookup(stat.Key), stat.Count, stat.Timestamp})
+		case *ValueStat:
+			payload.Values = append(payload.Values, binaryStat{lookup(stat.Key), stat.Value, stat.Timestamp})
+		default:
+			return "", fmt.Errorf("unknown stat type: %T", val)
+		}
+	}
+
+	return ContentTypeBinary, gob.NewEncoder(buf).Encode(payload)
+
+}
+
+// streamPayload returns a reader of the chunk json encoded as it is
+// read, so the payload is never held in memory whole.  The bytes
+// written, after any compression, are counted in n.
+func streamPayload(ezKey string, chunk []interface{}, compressed bool, n *int64) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		var (
+			w  io.Writer = &countingWriter{w: pw, n: n}
+			zw *gzip.Writer
+		)
+		if compressed {
+			zw = gzip.NewWriter(w)
+			w = zw
+		}
+		bw := bufio.NewWriter(w)
+		err := encodeStream(bw, ezKey, chunk)
+		if err == nil {
+			err = bw.Flush()
+		}
+		if err == nil && zw != nil {
+			err = zw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// encodeStream writes the same json as encodePayload a stat at a time
+func encodeStream(w io.Writer, ezKey string, chunk []interface{}) error {
+	key, err := json.Marshal(ezKey)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, `{"ezkey":%s,"data":[`, key); err != nil {
+		return err
+	}
+	for i, stat := range chunk {
+		data, err := json.Marshal(stat)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			data = append([]byte{','}, data...)
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	_, err = io.WriteString(w, "]}\n")
+	return err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
+
+// DecodePayload reads a payload sent by a Pool in either the json
+// or binary encoding.  Stats are returned as *CountStat and *ValueStat.
+func DecodePayload(contentType string, r io.Reader) (ezKey string, stats []interface{}, err error) {
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = ContentTypeJSON
+	}
+
+	switch mediaType {
+	case ContentTypeBinary:
+		var payload binaryPayload
+		if err := gob.NewDecoder(r).Decode(&payload); err != nil {
+			return "", nil, err
+		}
+		key := func(i uint32) (string, error) {
+			if int(i) >= len(payload.Keys) {
+				return "", fmt.Errorf("invalid key index: %d", i)
+			}
+			return payload.Keys[i], nil
+		}
+		for _, s := range payload.Counts {
+			k, err := key(s.Key)
+			if err != nil {
+				return "", nil, err
+			}
+			stats = append(stats, &CountStat{Key: k, Count: s.Value, Timestamp: s.Timestamp})
+		}
+		for _, s := range payload.Values {
+			k, err := key(s.Key)
+			if err != nil {
+				return "", nil, err
+			}
+			stats = append(stats, &ValueStat{Key: k, Value: s.Value, Timestamp: s.Timestamp})
+		}
+		return payload.EZKey, stats, nil
+
+	case ContentTypeJSON:
+		var payload struct {
+			EZKey string     `json:"ezkey"`
+			Data  []jsonStat `json:"data"`
+		}
+		if err := json.NewDecoder(r).Decode(&payload); err != nil {
+			return "", nil, err
+		}
+		for _, s := range payload.Data {
+			switch {
+			case s.Count != nil:
+				stats = append(stats, &CountStat{Key: s.Key, Count: *s.Count, Timestamp: s.Timestamp})
+			case s.Value != nil:
+				stats = append(stats, &ValueStat{Key: s.Key, Value: *s.Value, Timestamp: s.Timestamp})
+			default:
+				return "", nil, fmt.Errorf("stat has no count or value: %q", s.Key)
+			}
+		}
+		return payload.EZKey, stats, nil
+	}
+
+	return "", nil, fmt.Errorf("%w: %q", ErrUnsupportedContentType, contentType)
+
+}
@@ -0,0 +1,147 @@
+package statpool
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+// how long a failed endpoint is skipped before
+// it is tried again
+const endpointDownFor = 30 * time.Second
+
+type endpoint struct {
+	url       string
+	downUntil int64
+
+	// health checks of a down endpoint
+	lastProbe int64
+	probing   int32
+}
+
+func newEndpoint(url string) *endpoint {
+	return &endpoint{url: url}
+}
+
+func (e *endpoint) markDown(now time.Time, probed bool) {
+	until := now.Add(endpointDownFor).UnixNano()
+	if probed {
+		// only a passing health check brings it back
+		until = 1<<63 - 1
+	}
+	atomic.StoreInt64(&e.downUntil, until)
+}
+
+func (e *endpoint) markUp() {
+	atomic.StoreInt64(&e.downUntil, 0)
+}
+
+func (e *endpoint) healthy(now time.Time) bool {
+	return now.UnixNano() >= atomic.LoadInt64(&e.downUntil)
+}
+
+// SetEndpoints replaces the endpoints stats are sent to.  The first
+// is preferred; when it is unreachable or returns a 5xx each payload
+// fails over to the next, and the first is retried once it has been
+// skipped for a while.  An empty list is ignored, keeping the current
+// endpoints.
+func (p *Pool) SetEndpoints(urls ...string) {
+	if len(urls) == 0 {
+		return
+	}
+	endpoints := make([]*endpoint, len(urls))
+	for i, url := range urls {
+		endpoints[i] = newEndpoint(url)
+	}
+	p.endpointsMu.Lock()
+	p.endpoints = endpoints
+	p.endpointsMu.Unlock()
+}
+
+// SetHealthCheck probes endpoints that have failed with a GET of path,
+// resolved against their url, every interval.  An endpoint is only
+// used again once a probe returns 2xx, rather than after being
+// skipped for a while, so sends return to the primary as soon as it
+// has recovered.  An empty path disables probing.
+func (p *Pool) SetHealthCheck(path string, interval time.Duration) {
+	p.endpointsMu.Lock()
+	p.healthPath = path
+	p.healthInterval = interval
+	p.endpointsMu.Unlock()
+}
+
+// markDown skips the endpoint until it recovers
+func (p *Pool) markDown(ep *endpoint) {
+	p.endpointsMu.RLock()
+	probed := p.healthPath != ""
+	p.endpointsMu.RUnlock()
+	ep.markDown(p.now(), probed)
+}
+
+// probe checks whether a down endpoint has recovered, in the
+// background and at most once an interval
+func (p *Pool) probe(ep *endpoint, path string, interval time.Duration, now time.Time) {
+
+	last := atomic.LoadInt64(&ep.lastProbe)
+	if now.UnixNano()-last < int64(interval) || !atomic.CompareAndSwapInt32(&ep.probing, 0, 1) {
+		return
+	}
+	atomic.StoreInt64(&ep.lastProbe, now.UnixNano())
+
+	go func() {
+		defer atomic.StoreInt32(&ep.probing, 0)
+		base, err := url.Parse(ep.url)
+		if err != nil {
+			return
+		}
+		ref, err := url.Parse(path)
+		if err != nil {
+			return
+		}
+		ctx := context.Background()
+		if timeout := time.Duration(atomic.LoadInt64(&p.requestTimeout)); timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		req, err := http.NewRequestWithContext(ctx, "GET", base.ResolveReference(ref).String(), nil)
+		if err != nil {
+			return
+		}
+		resp, err := p.client.Do(req)
+		if err != nil {
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 == 2 {
+			ep.markUp()
+		}
+	}()
+
+}
+
+// endpointOrder lists healthy endpoints in order of preference
+// followed by the unhealthy ones as a last resort
+func (p *Pool) endpointOrder() []*endpoint {
+	p.endpointsMu.RLock()
+	defer p.endpointsMu.RUnlock()
+
+	var (
+		now     = p.now()
+		healthy = make([]*endpoint, 0, len(p.endpoints))
+		down    []*endpoint
+	)
+	for _, ep := range p.endpoints {
+		if ep.healthy(now) {
+			healthy = append(healthy, ep)
+			continue
+		}
+		down = append(down, ep)
+		if p.healthPath != "" {
+			p.probe(ep, p.healthPath, p.healthInterval, now)
+		}
+	}
+	return append(healthy, down...)
+}
@@ -0,0 +1,76 @@
+package statpool
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NewPoolFromEnv creates a pool configured by environment variables,
+// followed by opts:
+//
+//	STATPOOL_ENDPOINT        endpoint urls, comma separated
+//	STATPOOL_EZKEY           ez key
+//	STATPOOL_PREFIX          prefix of all keys
+//	STATPOOL_FLUSH_INTERVAL  flush interval, e.g. 30s
+//	STATPOOL_CHUNK_SIZE      most stats sent in a request
+//	STATPOOL_BUFFER_SIZE     stats of each type queued for aggregation
+//	STATPOOL_ENABLED         false to create the pool disabled
+//
+// Unset variables leave the defaults.  It returns an error naming the
+// variable if one can't be parsed.
+func NewPoolFromEnv(opts ...Option) (*Pool, error) {
+
+	var env []Option
+
+	if v := os.Getenv("STATPOOL_ENDPOINT"); v != "" {
+		var urls []string
+		for _, url := range strings.Split(v, ",") {
+			if url = strings.TrimSpace(url); url != "" {
+				urls = append(urls, url)
+			}
+		}
+		env = append(env, WithEndpoint(urls...))
+	}
+	if v, ok := os.LookupEnv("STATPOOL_EZKEY"); ok {
+		env = append(env, WithEZKey(v))
+	}
+	if v, ok := os.LookupEnv("STATPOOL_PREFIX"); ok {
+		env = append(env, WithPrefix(v))
+	}
+	if v := os.Getenv("STATPOOL_FLUSH_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid STATPOOL_FLUSH_INTERVAL: %q", v)
+		}
+		env = append(env, WithFlushInterval(d))
+	}
+	if v := os.Getenv("STATPOOL_CHUNK_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid STATPOOL_CHUNK_SIZE: %q", v)
+		}
+		env = append(env, WithChunkSize(n))
+	}
+	if v := os.Getenv("STATPOOL_BUFFER_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid STATPOOL_BUFFER_SIZE: %q", v)
+		}
+		env = append(env, WithBufferSize(n))
+	}
+	if v := os.Getenv("STATPOOL_ENABLED"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid STATPOOL_ENABLED: %q", v)
+		}
+		if !enabled {
+			env = append(env, WithDisabled())
+		}
+	}
+
+	return NewPoolWithOptions(append(env, opts...)...), nil
+
+}
@@ -0,0 +1,111 @@
+package statpool
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var (
+	advapi32                = syscall.NewLazyDLL("advapi32.dll")
+	procRegisterEventSource = advapi32.NewProc("RegisterEventSourceW")
+	procDeregisterEventSrc  = advapi32.NewProc("DeregisterEventSource")
+	procReportEvent         = advapi32.NewProc("ReportEventW")
+)
+
+const (
+	eventlogInformationType = 0x0004
+
+	// event id used for every stat
+	eventlogStatID = 1
+)
+
+// EventLogPool writes each stat to the Windows Event Log as an
+// information event from the given source.
+type EventLogPool struct {
+	handle syscall.Handle
+}
+
+func NewEventLogPool(source string) (*EventLogPool, error) {
+	name, err := syscall.UTF16PtrFromString(source)
+	if err != nil {
+		return nil, err
+	}
+	h, _, err := procRegisterEventSource.Call(0, uintptr(unsafe.Pointer(name)))
+	if h == 0 {
+		return nil, fmt.Errorf("registering event source %q: %s", source, err)
+	}
+	return &EventLogPool{handle: syscall.Handle(h)}, nil
+}
+
+func (e *EventLogPool) Count(key string, val float64) {
+	e.report(fmt.Sprintf("%s:%g", key, val))
+}
+
+func (e *EventLogPool) Value(key string, val float64, _ time.Time) {
+	e.report(fmt.Sprintf("%s:%g", key, val))
+}
+
+func (e *EventLogPool) Duration(key string, val time.Duration) {
+	e.report(fmt.Sprintf("%s:%s", key, val))
+}
+
+func (e *EventLogPool) Gauge(key string, val float64) {
+	e.report(fmt.Sprintf("%s:%g", key, val))
+}
+
+func (e *EventLogPool) DurationSince(key string, start time.Time) {
+	e.Duration(key, time.Since(start))
+}
+
+func (e *EventLogPool) TimeCaller(start time.Time) {
+	e.Duration(callerKey(1), time.Since(start))
+}
+
+func (e *EventLogPool) SampledCount(key string, val float64, rate float64) {
+	if r, ok := sample(key, rate); ok {
+		e.Count(key, scale(val, r))
+	}
+}
+
+func (e *EventLogPool) SampledDuration(key string, val time.Duration, rate float64) {
+	if _, ok := sample(key, rate); ok {
+		e.Duration(key, val)
+	}
+}
+
+func (e *EventLogPool) Timer(key string) *Timer {
+	return NewTimer(e, key)
+}
+
+func (e *EventLogPool) Time(key string) func() {
+	t := e.Timer(key)
+	return func() { t.Stop() }
+}
+
+func (e *EventLogPool) Close() error {
+	if r, _, err := procDeregisterEventSrc.Call(uintptr(e.handle)); r == 0 {
+		return err
+	}
+	return nil
+}
+
+func (e *EventLogPool) report(msg string) {
+	s, err := syscall.UTF16PtrFromString(msg)
+	if err != nil {
+		return
+	}
+	strs := []*uint16{s}
+	procReportEvent.Call(
+		uintptr(e.handle),
+		eventlogInformationType,
+		0,
+		eventlogStatID,
+		0,
+		uintptr(len(strs)),
+		0,
+		uintptr(unsafe.Pointer(&strs[0])),
+		0,
+	)
+}
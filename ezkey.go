@@ -0,0 +1,137 @@
+package statpool
+
+import (
+	"strings"
+	"time"
+)
+
+// EZKeyPool reports to a Pool under another StatHat account.  Its
+// stats are aggregated apart from the pool's own and sent in separate
+// payloads with its ez key, so a multi-tenant service can report each
+// tenant's stats to the tenant's account through one pool.
+type EZKeyPool struct {
+	p     *Pool
+	ezKey string
+}
+
+// ezKeyGroup is the stats of a flush sent under one ez key
+type ezKeyGroup struct {
+	ezKey string
+	stats []interface{}
+}
+
+// ForEZKey returns a view of the pool that reports under ezKey
+//
+//	tenant := pool.ForEZKey(account.EZKey)
+//	tenant.Count("requests", 1)
+func (p *Pool) ForEZKey(ezKey string) *EZKeyPool {
+	if ezKey == p.ezKey {
+		ezKey = ""
+	}
+	return &EZKeyPool{p: p, ezKey: ezKey}
+}
+
+func (e *EZKeyPool) Count(key string, val float64) {
+	stat := newCountStat(e.p.prefix+key, val)
+	stat.ezKey = e.ezKey
+	e.p.SendCount(stat)
+}
+
+func (e *EZKeyPool) Inc(key string) {
+	e.Count(key, 1)
+}
+
+func (e *EZKeyPool) Dec(key string) {
+	e.Count(key, -1)
+}
+
+func (e *EZKeyPool) Add(key string, delta float64) {
+	e.Count(key, delta)
+}
+
+func (e *EZKeyPool) Value(key string, val float64, timestamp time.Time) {
+	e.p.SendValue(newValueStat(ValueStat{Key: e.p.prefix + key, Value: val, Timestamp: timestamp.Unix(), ezKey: e.ezKey}))
+}
+
+func (e *EZKeyPool) Duration(key string, val time.Duration) {
+	e.p.SendValue(newValueStat(ValueStat{Key: e.p.prefix + key, Value: float64(val) / float64(time.Millisecond), ezKey: e.ezKey}))
+}
+
+func (e *EZKeyPool) Gauge(key string, val float64) {
+	e.p.SendValue(newValueStat(ValueStat{Key: e.p.prefix + key, Value: val, Timestamp: e.p.now().Unix(), gauge: true, ezKey: e.ezKey}))
+}
+
+func (e *EZKeyPool) DurationSince(key string, start time.Time) {
+	e.Duration(key, e.p.now().Sub(start))
+}
+
+func (e *EZKeyPool) SampledCount(key string, val float64, rate float64) {
+	if r, ok := e.p.sample(e.p.prefix+key, rate); ok {
+		e.Count(key, scale(val, r))
+	}
+}
+
+func (e *EZKeyPool) SampledDuration(key string, val time.Duration, rate float64) {
+	if _, ok := e.p.sample(e.p.prefix+key, rate); ok {
+		e.Duration(key, val)
+	}
+}
+
+func (e *EZKeyPool) Timer(key string) *Timer {
+	return NewTimer(e, key)
+}
+
+func (e *EZKeyPool) Time(key string) func() {
+	t := e.Timer(key)
+	return func() { t.Stop() }
+}
+
+// ezKeyOr returns ezKey, or the pool's if it's empty
+func (p *Pool) ezKeyOr(ezKey string) string {
+	if ezKey == "" {
+		return p.ezKey
+	}
+	return ezKey
+}
+
+// groupByEZKey splits stats by the ez key they're sent under, the
+// pool's first
+func groupByEZKey(stats []interface{}) []ezKeyGroup {
+	var (
+		groups = []ezKeyGroup{{}}
+		index  = map[string]int{"": 0}
+	)
+	for _, stat := range stats {
+		var ezKey string
+		switch s := stat.(type) {
+		case *CountStat:
+			ezKey = s.ezKey
+		case *ValueStat:
+			ezKey = s.ezKey
+		}
+		i, exists := index[ezKey]
+		if !exists {
+			i = len(groups)
+			index[ezKey] = i
+			groups = append(groups, ezKeyGroup{ezKey: ezKey})
+		}
+		groups[i].stats = append(groups[i].stats, stat)
+	}
+	return groups
+}
+
+// aggKey is the key a stat is aggregated under, apart from the same
+// key sent under another ez key
+func aggKey(ezKey, key string) string {
+	if ezKey == "" {
+		return key
+	}
+	return ezKey + "\x00" + key
+}
+
+func splitAggKey(k string) (ezKey, key string) {
+	if i := strings.IndexByte(k, 0); i >= 0 {
+		return k[:i], k[i+1:]
+	}
+	return "", k
+}
@@ -0,0 +1,207 @@
+package statpool
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultMaxFileSize is the size a FileSender's file is rotated at
+// unless set
+const DefaultMaxFileSize = 100 << 20
+
+// FileSender appends stats to a file as JSON lines, one object per
+// stat with the fields of a WebhookStat, for environments without
+// network egress or for importing later.  When the file passes its
+// max size it is renamed with the time appended, and gzipped if
+// compression is on, and a new file started.
+type FileSender struct {
+	path     string
+	maxSize  int64
+	compress bool
+
+	f    *os.File
+	size int64
+	mu   sync.Mutex
+
+	// rotation failures, reported apart from the send whose lines
+	// were written
+	onError   func(err error)
+	rotateErr error
+}
+
+// NewFilePool returns a pool that appends each flush to the file at
+// path
+func NewFilePool(path string, flushInterval time.Duration) *Pool {
+	return NewPoolWithOptions(
+		WithFlushInterval(flushInterval),
+		WithSender(NewFileSender(path)),
+	)
+}
+
+func NewFileSender(path string) *FileSender {
+	return &FileSender{path: path, maxSize: DefaultMaxFileSize}
+}
+
+// SetMaxSize sets the size in bytes the file is rotated at, zero
+// never rotates it
+func (s *FileSender) SetMaxSize(n int64) {
+	s.mu.Lock()
+	s.maxSize = n
+	s.mu.Unlock()
+}
+
+// SetCompress gzips files as they're rotated
+func (s *FileSender) SetCompress(compress bool) {
+	s.mu.Lock()
+	s.compress = compress
+	s.mu.Unlock()
+}
+
+// OnError is called with errors rotating the file.  Without it they're
+// returned by the next Send, before anything is written, so the lines
+// already written aren't sent again.
+func (s *FileSender) OnError(fn func(err error)) {
+	s.mu.Lock()
+	s.onError = fn
+	s.mu.Unlock()
+}
+
+func (s *FileSender) Send(_ context.Context, stats []interface{}) error {
+
+	err := s.write(stats)
+
+	s.mu.Lock()
+	fn, rotateErr := s.onError, s.rotateErr
+	if fn != nil {
+		s.rotateErr = nil
+	}
+	s.mu.Unlock()
+
+	// called outside the lock so it can configure the sender
+	if fn != nil && rotateErr != nil {
+		fn(rotateErr)
+	}
+	return err
+
+}
+
+// write appends the stats, keeping any error rotating the file after
+// to report apart
+func (s *FileSender) write(stats []interface{}) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.rotateErr; err != nil {
+		s.rotateErr = nil
+		return fmt.Errorf("rotating %s: %w", s.path, err)
+	}
+
+	if s.f == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	for _, stat := range webhookPayload(stats, time.Now()).Stats {
+		if err := enc.Encode(stat); err != nil {
+			return permanentError{&EncodeError{err}}
+		}
+	}
+	n, err := s.f.Write(buf.Bytes())
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+
+	if s.maxSize > 0 && s.size >= s.maxSize {
+		s.rotateErr = s.rotate()
+	}
+	return nil
+
+}
+
+// Close closes the file
+func (s *FileSender) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
+
+func (s *FileSender) open() error {
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f, s.size = f, info.Size()
+	return nil
+}
+
+// rotate moves the full file aside and starts a new one
+func (s *FileSender) rotate() error {
+
+	if err := s.f.Close(); err != nil {
+		return err
+	}
+	s.f = nil
+
+	rotated := s.path + "." + time.Now().UTC().Format("20060102T150405.000000000")
+	if err := os.Rename(s.path, rotated); err != nil {
+		return err
+	}
+	if s.compress {
+		if err := gzipFile(rotated); err != nil {
+			return err
+		}
+	}
+	return s.open()
+
+}
+
+// gzipFile replaces path with path.gz
+func gzipFile(path string) error {
+
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+
+}
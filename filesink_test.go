@@ -0,0 +1,131 @@
+package statpool
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileSender(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "statpool-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "stats.jsonl")
+	sender := NewFileSender(path)
+	sender.SetMaxSize(100)
+	sender.SetCompress(true)
+	defer sender.Close()
+	stats := NewPoolWithOptions(WithFlushInterval(time.Hour), WithSender(sender))
+
+	stats.Count("throws", 3)
+	if err := stats.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var line WebhookStat
+	if err := json.NewDecoder(f).Decode(&line); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if line.Key != "throws" || line.Type != "count" || line.Value != 3 {
+		t.Errorf("Unexpected line: %+v", line)
+	}
+
+	// the file passes its max size and is rotated
+	stats.Value("score", 180, time.Now())
+	if err := stats.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	stats.Stop()
+
+	rotated, _ := filepath.Glob(path + ".*.gz")
+	if len(rotated) != 1 {
+		t.Fatalf("Expected: 1 rotated file, got: %v", rotated)
+	}
+	gz, err := os.Open(rotated[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gz.Close()
+	zr, err := gzip.NewReader(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := 0
+	for s := bufio.NewScanner(zr); s.Scan(); lines++ {
+		if !strings.HasPrefix(s.Text(), `{"key":`) {
+			t.Errorf("Unexpected line: %s", s.Text())
+		}
+	}
+	if lines != 2 {
+		t.Errorf("Expected: 2 lines, got: %d", lines)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() != 0 {
+		t.Errorf("Expected a new empty file, got: %v %v", info, err)
+	}
+
+}
+
+func TestFileSenderRotateError(t *testing.T) {
+
+	var (
+		path   = filepath.Join(t.TempDir(), "stats.jsonl")
+		sender = NewFileSender(path)
+		stats  = []interface{}{&CountStat{Key: "throws", Count: 1}}
+		ctx    = context.Background()
+	)
+	defer sender.Close()
+
+	// removing the open file makes the rename on rotation fail
+	sender.SetMaxSize(0)
+	if err := sender.Send(ctx, stats); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(path)
+	sender.SetMaxSize(10)
+
+	// the lines were written, so the send succeeds and the next
+	// returns the error without writing
+	if err := sender.Send(ctx, stats); err != nil {
+		t.Errorf("Expected the send to succeed, got: %v", err)
+	}
+	if err := sender.Send(ctx, stats); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the rotation error, got: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing written, got: %v", err)
+	}
+
+	// or it goes to the error handler
+	var rotateErr error
+	sender.OnError(func(err error) { rotateErr = err })
+	if err := sender.Send(ctx, stats); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(path)
+	if err := sender.Send(ctx, stats); err != nil {
+		t.Errorf("Expected the send to succeed, got: %v", err)
+	}
+	if !errors.Is(rotateErr, os.ErrNotExist) {
+		t.Errorf("Expected the rotation error handled, got: %v", rotateErr)
+	}
+	if err := sender.Send(ctx, stats); err != nil {
+		t.Errorf("Expected the next send to succeed, got: %v", err)
+	}
+
+}
@@ -0,0 +1,194 @@
+package statpool
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// most keys a FilterPool remembers the decision for
+const filterCacheSize = 10000
+
+type (
+	// FilterPool passes on only the stats whose keys are allowed, so
+	// noisy stats can be switched off by configuration.
+	FilterPool struct {
+		s      Stater
+		filter *keyFilter
+	}
+
+	// keyFilter decides which keys are allowed, remembering its
+	// decisions
+	keyFilter struct {
+		allow []*regexp.Regexp
+		deny  []*regexp.Regexp
+
+		decided sync.Map
+		cached  int64
+	}
+)
+
+// NewFilterPool filters the stats sent to s.  A key is passed on if
+// it matches one of the allow patterns, or there are none, and none
+// of the deny patterns.  Patterns are globs where * matches any run
+// of characters and ? any one, e.g. "debug.*", or regular expressions
+// between slashes, e.g. "/^api\.(get|put)\./".
+func NewFilterPool(s Stater, allow, deny []string) (*FilterPool, error) {
+	kf, err := newKeyFilter(allow, deny)
+	if err != nil {
+		return nil, err
+	}
+	return &FilterPool{s: s, filter: kf}, nil
+}
+
+func newKeyFilter(allow, deny []string) (*keyFilter, error) {
+	f := &keyFilter{}
+	var err error
+	if f.allow, err = compilePatterns(allow); err != nil {
+		return nil, err
+	}
+	if f.deny, err = compilePatterns(deny); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, len(patterns))
+	for i, pattern := range patterns {
+		var expr string
+		if len(pattern) >= 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+			expr = pattern[1 : len(pattern)-1]
+		} else {
+			expr = regexp.QuoteMeta(pattern)
+			expr = strings.Replace(expr, `\*`, `.*`, -1)
+			expr = "^" + strings.Replace(expr, `\?`, `.`, -1) + "$"
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = re
+	}
+	return res, nil
+}
+
+// Allowed reports whether stats for key are passed on
+func (f *FilterPool) Allowed(key string) bool {
+	return f.filter.allowed(key)
+}
+
+func (f *keyFilter) allowed(key string) bool {
+
+	if v, exists := f.decided.Load(key); exists {
+		return v.(bool)
+	}
+
+	allowed := len(f.allow) == 0
+	for _, re := range f.allow {
+		if re.MatchString(key) {
+			allowed = true
+			break
+		}
+	}
+	for _, re := range f.deny {
+		if allowed && re.MatchString(key) {
+			allowed = false
+		}
+	}
+
+	if atomic.AddInt64(&f.cached, 1) <= filterCacheSize {
+		f.decided.Store(key, allowed)
+	}
+	return allowed
+
+}
+
+func (f *FilterPool) Count(key string, val float64) {
+	if f.Allowed(key) {
+		f.s.Count(key, val)
+	}
+}
+
+func (f *FilterPool) Value(key string, val float64, timestamp time.Time) {
+	if f.Allowed(key) {
+		f.s.Value(key, val, timestamp)
+	}
+}
+
+func (f *FilterPool) Duration(key string, val time.Duration) {
+	if f.Allowed(key) {
+		f.s.Duration(key, val)
+	}
+}
+
+// Gauge is sent as a value to Staters without gauges
+func (f *FilterPool) Gauge(key string, val float64) {
+	if !f.Allowed(key) {
+		return
+	}
+	if g, ok := f.s.(gauger); ok {
+		g.Gauge(key, val)
+	} else {
+		f.s.Value(key, val, time.Now())
+	}
+}
+
+// Histogram is sent as a value to Staters without histograms
+func (f *FilterPool) Histogram(key string, val float64) {
+	if !f.Allowed(key) {
+		return
+	}
+	if h, ok := f.s.(histogrammer); ok {
+		h.Histogram(key, val)
+	} else {
+		f.s.Value(key, val, time.Now())
+	}
+}
+
+func (f *FilterPool) DurationSince(key string, start time.Time) {
+	f.Duration(key, time.Since(start))
+}
+
+func (f *FilterPool) TimeCaller(start time.Time) {
+	f.Duration(callerKey(1), time.Since(start))
+}
+
+func (f *FilterPool) SampledDuration(key string, val time.Duration, rate float64) {
+	if _, ok := sample(key, rate); ok {
+		f.Duration(key, val)
+	}
+}
+
+func (f *FilterPool) Timer(key string) *Timer {
+	return NewTimer(f, key)
+}
+
+func (f *FilterPool) Time(key string) func() {
+	t := f.Timer(key)
+	return func() { t.Stop() }
+}
+
+// SetFilter only accepts stats whose keys, as reported including the
+// prefix, pass the allow and deny patterns, as in NewFilterPool.  No
+// patterns accepts every key.
+func (p *Pool) SetFilter(allow, deny []string) error {
+	if len(allow) == 0 && len(deny) == 0 {
+		p.filter.Store((*keyFilter)(nil))
+		return nil
+	}
+	kf, err := newKeyFilter(allow, deny)
+	if err != nil {
+		return err
+	}
+	p.filter.Store(kf)
+	return nil
+}
+
+// filtered reports whether key passes the pool's filter
+func (p *Pool) filtered(key string) bool {
+	kf, _ := p.filter.Load().(*keyFilter)
+	return kf == nil || kf.allowed(key)
+}
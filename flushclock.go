@@ -0,0 +1,98 @@
+package statpool
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// SetFlushJitter delays each flush by a random amount up to d so
+// a fleet of processes started together don't all flush at once.
+// The delay doesn't accumulate, flushes stay one interval apart on
+// average.  It takes effect when the pool's timer next starts.
+func (p *Pool) SetFlushJitter(d time.Duration) {
+	atomic.StoreInt64(&p.flushJitter, int64(d))
+}
+
+// SetAlignFlushes aligns flushes to multiples of the flush interval
+// on the wall clock, e.g. :00 of each minute for a one minute
+// interval, so counts line up with StatHat's buckets.  Any jitter is
+// added after the boundary.  It takes effect when the pool's timer
+// next starts.
+func (p *Pool) SetAlignFlushes(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&p.alignFlushes, v)
+}
+
+// SetFlushInterval changes how often the pool flushes, restarting its
+// timer if it's running.  Pools created by a Scheduler flush on the
+// scheduler's interval.
+func (p *Pool) SetFlushInterval(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	atomic.StoreInt64(&p.interval, int64(d))
+	select {
+	case p.retick <- struct{}{}:
+	default:
+	}
+}
+
+func (p *Pool) startTicker() {
+	go p.run(p.ticker())
+}
+
+// ticker starts the pool's flush timer
+func (p *Pool) ticker() (<-chan time.Time, func()) {
+	var (
+		interval = time.Duration(atomic.LoadInt64(&p.interval))
+		jitter   = time.Duration(atomic.LoadInt64(&p.flushJitter))
+		align    = atomic.LoadInt32(&p.alignFlushes) == 1
+	)
+	if _, real := p.clock.(realClock); !real || jitter <= 0 && !align {
+		return p.clock.Ticker(interval)
+	}
+	return newFlushTicker(interval, jitter, align)
+}
+
+// newFlushTicker ticks every interval, optionally aligned to the wall
+// clock and with each tick delayed up to jitter
+func newFlushTicker(interval, jitter time.Duration, align bool) (<-chan time.Time, func()) {
+
+	var (
+		c    = make(chan time.Time, 1)
+		stop = make(chan struct{})
+		next = time.Now()
+	)
+	if align {
+		next = next.Truncate(interval)
+	}
+
+	go func() {
+		for {
+			next = next.Add(interval)
+			delay := time.Until(next)
+			if jitter > 0 {
+				delay += time.Duration(rand.Int63n(int64(jitter)))
+			}
+			timer := time.NewTimer(delay)
+			select {
+			case t := <-timer.C:
+				// dropped like a time.Ticker if the last isn't taken
+				select {
+				case c <- t:
+				default:
+				}
+			case <-stop:
+				timer.Stop()
+				return
+			}
+		}
+	}()
+
+	return c, func() { close(stop) }
+
+}
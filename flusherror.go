@@ -0,0 +1,183 @@
+package statpool
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type (
+	// ChunkError is the failure to send one chunk of a flush
+	ChunkError struct {
+		ID    string // the chunk's idempotency key
+		Stats int    // how many stats it held
+		Err   error
+	}
+
+	// FlushError lists the chunks of a flush that failed to send
+	FlushError struct {
+		Chunks []*ChunkError
+		Total  int // chunks in the flush
+	}
+
+	// TransportError is a request that got no response, the endpoint
+	// being unreachable or the request timing out
+	TransportError struct {
+		Err error
+	}
+
+	// HTTPStatusError is a response with an unexpected status code
+	HTTPStatusError struct {
+		Code int
+	}
+
+	// APIError is a payload the endpoint accepted over http but
+	// rejected in its response
+	APIError struct {
+		Status  int
+		Message string
+	}
+
+	// EncodeError is a payload that couldn't be encoded
+	EncodeError struct {
+		Err error
+	}
+)
+
+// ErrStoppedUnsent is the error of the chunks a stopping pool was
+// still holding, e.g. while paused or throttled, wrapped with where
+// they were left if anywhere
+var ErrStoppedUnsent = errors.New("stopped with stats unsent")
+
+func (e *ChunkError) Error() string {
+	return fmt.Sprintf("chunk %s of %d stats: %s", e.ID, e.Stats, e.Err)
+}
+
+func (e *ChunkError) Unwrap() error {
+	return e.Err
+}
+
+func (e *FlushError) Error() string {
+	msgs := make([]string, len(e.Chunks))
+	for i, c := range e.Chunks {
+		msgs[i] = c.Error()
+	}
+	return fmt.Sprintf("%d of %d chunks failed: %s", len(e.Chunks), e.Total, strings.Join(msgs, "; "))
+}
+
+// Unwrap lets errors.Is and errors.As match any chunk's error
+func (e *FlushError) Unwrap() []error {
+	errs := make([]error, len(e.Chunks))
+	for i, c := range e.Chunks {
+		errs[i] = c
+	}
+	return errs
+}
+
+// FailedStats is how many stats were in the chunks that failed
+func (e *FlushError) FailedStats() int {
+	n := 0
+	for _, c := range e.Chunks {
+		n += c.Stats
+	}
+	return n
+}
+
+func (e *TransportError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable is true, the request may get through another time
+func (e *TransportError) Retryable() bool {
+	return true
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("Received http status code: %d", e.Code)
+}
+
+// Is matches an *HTTPStatusError with the same code, so
+//
+//	errors.Is(err, &HTTPStatusError{Code: http.StatusServiceUnavailable})
+func (e *HTTPStatusError) Is(target error) bool {
+	t, ok := target.(*HTTPStatusError)
+	return ok && t.Code == e.Code
+}
+
+// Retryable is true of 5xx and 429 responses
+func (e *HTTPStatusError) Retryable() bool {
+	return e.Code >= 500 || e.Code == http.StatusTooManyRequests
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%d : %s", e.Status, e.Message)
+}
+
+// Is matches an *APIError with the same status
+func (e *APIError) Is(target error) bool {
+	t, ok := target.(*APIError)
+	return ok && t.Status == e.Status
+}
+
+// Retryable is false, the endpoint will reject the payload again
+func (e *APIError) Retryable() bool {
+	return false
+}
+
+func (e *EncodeError) Error() string {
+	return "encoding payload: " + e.Err.Error()
+}
+
+func (e *EncodeError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable is false, the payload will fail to encode again
+func (e *EncodeError) Retryable() bool {
+	return false
+}
+
+// IsRetryable reports whether err, or an error it wraps, is a failure
+// that may succeed if the send is tried again
+func IsRetryable(err error) bool {
+	var r interface{ Retryable() bool }
+	return errors.As(err, &r) && r.Retryable()
+}
+
+// stopError adds the batches left unsent at stop to the final flush's
+// error, so stopping reports whether everything was delivered
+func stopError(err error, unsent []*ChunkError) error {
+
+	if len(unsent) == 0 {
+		return err
+	}
+	if err == nil {
+		return &FlushError{Chunks: unsent, Total: len(unsent)}
+	}
+
+	// chunks of the final flush that failed and were held are
+	// already listed, with why they weren't sent
+	fe, ok := err.(*FlushError)
+	if !ok {
+		return err
+	}
+	listed := map[string]*ChunkError{}
+	for _, c := range fe.Chunks {
+		listed[c.ID] = c
+	}
+	for _, c := range unsent {
+		if failed, exists := listed[c.ID]; exists {
+			failed.Err = fmt.Errorf("%w; %w", failed.Err, c.Err)
+			continue
+		}
+		fe.Chunks = append(fe.Chunks, c)
+		fe.Total++
+	}
+	return fe
+
+}
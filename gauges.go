@@ -0,0 +1,42 @@
+package statpool
+
+import "sync"
+
+// gaugeFuncs are polled for values at each flush
+type gaugeFuncs struct {
+	fns map[string]func() float64
+	sync.RWMutex
+}
+
+// RegisterGauge calls fn at each flush and reports the result as a
+// value of key, replacing any function already registered for it.
+// A pool with registered gauges always has something to flush, so it
+// won't idle shut down.
+func (p *Pool) RegisterGauge(key string, fn func() float64) {
+	p.gaugeFuncs.Lock()
+	if p.gaugeFuncs.fns == nil {
+		p.gaugeFuncs.fns = map[string]func() float64{}
+	}
+	p.gaugeFuncs.fns[p.prefix+key] = fn
+	p.gaugeFuncs.Unlock()
+	p.ensureRunning()
+}
+
+func (p *Pool) UnregisterGauge(key string) {
+	p.gaugeFuncs.Lock()
+	delete(p.gaugeFuncs.fns, p.prefix+key)
+	p.gaugeFuncs.Unlock()
+}
+
+// pollGauges appends the values of registered gauges to values
+func (p *Pool) pollGauges(values []interface{}, now int64) []interface{} {
+	p.gaugeFuncs.RLock()
+	defer p.gaugeFuncs.RUnlock()
+	for key, fn := range p.gaugeFuncs.fns {
+		val := fn()
+		if p.filterNonFinite(&val) {
+			values = append(values, &ValueStat{Key: key, Value: val, Timestamp: now})
+		}
+	}
+	return values
+}
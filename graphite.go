@@ -0,0 +1,112 @@
+package statpool
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GraphiteSender writes stats to a Carbon server over TCP in the
+// plaintext protocol, one "path value timestamp" line per stat.  The
+// connection is kept open between flushes and redialed after a
+// failure, the pool's retries covering the stats that failed.
+type GraphiteSender struct {
+	addr      string
+	namespace string
+	dialer    net.Dialer
+
+	conn net.Conn
+	mu   sync.Mutex
+}
+
+// NewGraphitePool returns a pool that flushes to the Carbon server at
+// addr, e.g. graphite:2003, with every path under namespace if set
+func NewGraphitePool(addr, namespace string, flushInterval time.Duration) *Pool {
+	return NewPoolWithOptions(
+		WithFlushInterval(flushInterval),
+		WithSender(NewGraphiteSender(addr, namespace)),
+	)
+}
+
+func NewGraphiteSender(addr, namespace string) *GraphiteSender {
+	return &GraphiteSender{addr: addr, namespace: strings.TrimSuffix(namespace, ".")}
+}
+
+func (g *GraphiteSender) Send(ctx context.Context, stats []interface{}) error {
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.conn == nil {
+		conn, err := g.dialer.DialContext(ctx, "tcp", g.addr)
+		if err != nil {
+			return err
+		}
+		g.conn = conn
+	}
+
+	deadline, _ := ctx.Deadline()
+	g.conn.SetWriteDeadline(deadline)
+
+	w := bufio.NewWriter(g.conn)
+	now := time.Now().Unix()
+	for _, stat := range stats {
+		switch s := stat.(type) {
+		case *CountStat:
+			g.writeLine(w, s.Key, s.Count, s.Timestamp, now)
+		case *ValueStat:
+			g.writeLine(w, s.Key, s.Value, s.Timestamp, now)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		// redial on the next send
+		g.conn.Close()
+		g.conn = nil
+		return err
+	}
+	return nil
+
+}
+
+// Close closes the connection to the server
+func (g *GraphiteSender) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.conn == nil {
+		return nil
+	}
+	err := g.conn.Close()
+	g.conn = nil
+	return err
+}
+
+func (g *GraphiteSender) writeLine(w *bufio.Writer, key string, val float64, ts, now int64) {
+	if ts == 0 {
+		ts = now
+	}
+	if g.namespace != "" {
+		w.WriteString(g.namespace)
+		w.WriteByte('.')
+	}
+	w.WriteString(graphitePath(key))
+	w.WriteByte(' ')
+	w.WriteString(strconv.FormatFloat(val, 'f', -1, 64))
+	w.WriteByte(' ')
+	w.WriteString(strconv.FormatInt(ts, 10))
+	w.WriteByte('\n')
+}
+
+// graphitePath replaces the whitespace that would break a line
+func graphitePath(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\n', '\r':
+			return '_'
+		}
+		return r
+	}, key)
+}
@@ -0,0 +1,74 @@
+package statpool
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGraphitePool(t *testing.T) {
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	lines := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				s := bufio.NewScanner(conn)
+				for s.Scan() {
+					lines <- s.Text()
+				}
+			}()
+		}
+	}()
+
+	sender := NewGraphiteSender(ln.Addr().String(), "app.")
+	defer sender.Close()
+	stats := NewPoolWithOptions(WithFlushInterval(time.Hour), WithSender(sender))
+
+	stats.Count("darts", 2)
+	stats.Value("high score", 180, time.Unix(1500000000, 0))
+	if err := stats.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]bool{"app.darts 2": true, "app.high_score 180 1500000000": true}
+	for i := 0; i < len(expected); i++ {
+		select {
+		case line := <-lines:
+			if !expected[line] && !expected[line[:strings.LastIndex(line, " ")]] {
+				t.Errorf("Unexpected line: %q", line)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for lines")
+		}
+	}
+
+	// a closed connection is redialed
+	sender.Close()
+	stats.Count("darts", 1)
+	if err := stats.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case line := <-lines:
+		if !strings.HasPrefix(line, "app.darts 1 ") {
+			t.Errorf("Unexpected line: %q", line)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the redialed line")
+	}
+	stats.Stop()
+
+}
@@ -0,0 +1,77 @@
+package statpool
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultRequestTimeout limits each request to an endpoint so a hung
+// connection can't stall flushes
+const DefaultRequestTimeout = 30 * time.Second
+
+// SetRequestTimeout limits how long each request to an endpoint may
+// take, including reading the response.  Zero removes the limit,
+// leaving only the http client's own timeout.
+func (p *Pool) SetRequestTimeout(d time.Duration) {
+	atomic.StoreInt64(&p.requestTimeout, int64(d))
+}
+
+// WithRequestTimeout limits each request, see SetRequestTimeout
+func WithRequestTimeout(d time.Duration) Option {
+	return func(p *Pool) { p.SetRequestTimeout(d) }
+}
+
+// WithProxy sends requests through the proxy returned by fn, e.g.
+// http.ProxyURL(u).  Like the other transport options it applies to
+// the pool's client, or a copy of the one given to WithHTTPClient if
+// that comes first.
+func WithProxy(fn func(*http.Request) (*url.URL, error)) Option {
+	return func(p *Pool) {
+		if t := p.transport(); t != nil {
+			t.Proxy = fn
+		}
+	}
+}
+
+// WithTLSConfig sets the TLS configuration used to connect to the
+// endpoints
+func WithTLSConfig(config *tls.Config) Option {
+	return func(p *Pool) {
+		if t := p.transport(); t != nil {
+			t.TLSClientConfig = config
+		}
+	}
+}
+
+// WithMaxIdleConns sets how many idle connections are kept open to
+// each endpoint between flushes
+func WithMaxIdleConns(n int) Option {
+	return func(p *Pool) {
+		if t := p.transport(); t != nil {
+			t.MaxIdleConns = n
+			t.MaxIdleConnsPerHost = n
+		}
+	}
+}
+
+// transport gives the pool its own copy of its client and the client's
+// transport to configure, or nil if the client has a transport other
+// than *http.Transport
+func (p *Pool) transport() *http.Transport {
+	rt := p.client.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	t, ok := rt.(*http.Transport)
+	if !ok {
+		return nil
+	}
+	t = t.Clone()
+	client := *p.client
+	client.Transport = t
+	p.client = &client
+	return t
+}
@@ -0,0 +1,54 @@
+package statpool
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+// hyperLogLog estimates the number of distinct members added to it
+// in a fixed 4KB, with a standard error of about 1.6%
+type hyperLogLog struct {
+	registers [1 << hllPrecision]uint8
+}
+
+const hllPrecision = 12
+
+func (h *hyperLogLog) add(member string) {
+	f := fnv.New64a()
+	f.Write([]byte(member))
+	x := mix64(f.Sum64())
+	i := x >> (64 - hllPrecision)
+	rho := uint8(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1))) + 1
+	if rho > h.registers[i] {
+		h.registers[i] = rho
+	}
+}
+
+func (h *hyperLogLog) estimate() float64 {
+	m := float64(len(h.registers))
+	sum, zeros := 0.0, 0
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	e := 0.7213 / (1 + 1.079/m) * m * m / sum
+	// linear counting is more accurate for small sets
+	if e <= 2.5*m && zeros > 0 {
+		e = m * math.Log(m/float64(zeros))
+	}
+	return math.Round(e)
+}
+
+// mix64 spreads fnv's bits so the register index and
+// leading zeros are independent (murmur3's finalizer)
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,59 @@
+package statpool
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// IdempotencyHeader carries an identifier unique to each payload.
+// Retries of a payload reuse it so a receiver can discard duplicates
+// after an ambiguous failure.
+const IdempotencyHeader = "Idempotency-Key"
+
+// how long a Server remembers payloads it has received
+const idempotencyWindow = 10 * time.Minute
+
+type idempotencySet struct {
+	sync.Mutex
+	seen map[string]time.Time
+}
+
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// add records key and reports whether it was new
+func (s *idempotencySet) add(key string, now time.Time) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.seen == nil {
+		s.seen = map[string]time.Time{}
+	}
+	if t, exists := s.seen[key]; exists && now.Sub(t) < idempotencyWindow {
+		return false
+	}
+	s.seen[key] = now
+
+	// prune expired keys as the set grows
+	if len(s.seen)%1024 == 0 {
+		for k, t := range s.seen {
+			if now.Sub(t) >= idempotencyWindow {
+				delete(s.seen, k)
+			}
+		}
+	}
+	return true
+}
+
+// remove forgets key so a payload that failed to
+// process can be accepted when retried
+func (s *idempotencySet) remove(key string) {
+	s.Lock()
+	delete(s.seen, key)
+	s.Unlock()
+}
@@ -0,0 +1,82 @@
+package statpool
+
+import (
+	"os"
+	"strings"
+)
+
+// InstanceOptions identifies the stats of one process in a fleet
+type InstanceOptions struct {
+	// added to each key, e.g. requests.web1, empty for none
+	ID string
+
+	// add the id as a tag with this name instead, e.g.
+	// requests.host=web1 with the default tag separator
+	TagName string
+
+	// also report each stat without the id, so there are
+	// both per instance and fleet wide stats
+	Aggregate bool
+}
+
+// SetInstance adds an instance id to the keys of each flush.  It must
+// be called before the pool is used.
+func (p *Pool) SetInstance(opts InstanceOptions) {
+	p.instance = opts
+}
+
+// shortHostname is the hostname up to the first dot, so it doesn't
+// nest keys
+func shortHostname() string {
+	host, _ := os.Hostname()
+	if i := strings.IndexByte(host, '.'); i > 0 {
+		host = host[:i]
+	}
+	return host
+}
+
+// addInstance adds the instance id to a flush's stats, keeping a copy
+// without it when aggregating
+func (p *Pool) addInstance(values []interface{}) []interface{} {
+
+	opts := p.instance
+	if opts.ID == "" {
+		return values
+	}
+
+	var (
+		suffix = "." + opts.ID
+		tag    = Tag{Key: opts.TagName, Value: opts.ID}
+	)
+	if opts.TagName != "" {
+		suffix = p.tagsep + opts.TagName + "=" + opts.ID
+	}
+
+	n := len(values)
+	for _, v := range values[:n] {
+		switch stat := v.(type) {
+		case *CountStat:
+			if opts.Aggregate {
+				agg := *stat
+				agg.pooled = false
+				values = append(values, &agg)
+			}
+			stat.Key += suffix
+			if opts.TagName != "" {
+				stat.Tags = append(stat.Tags[:len(stat.Tags):len(stat.Tags)], tag)
+			}
+		case *ValueStat:
+			if opts.Aggregate {
+				agg := *stat
+				agg.pooled = false
+				values = append(values, &agg)
+			}
+			stat.Key += suffix
+			if opts.TagName != "" {
+				stat.Tags = append(stat.Tags[:len(stat.Tags):len(stat.Tags)], tag)
+			}
+		}
+	}
+	return values
+
+}
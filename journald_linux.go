@@ -0,0 +1,100 @@
+package statpool
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// journald's native protocol socket
+var journaldSocket = "/run/systemd/journal/socket"
+
+// JournaldPool writes each stat to the systemd journal as a
+// structured entry with STAT_KEY, STAT_TYPE and STAT_VALUE fields.
+type JournaldPool struct {
+	conn       *net.UnixConn
+	identifier string
+}
+
+func NewJournaldPool(identifier string) (*JournaldPool, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journaldSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &JournaldPool{conn: conn, identifier: identifier}, nil
+}
+
+func (j *JournaldPool) Count(key string, val float64) {
+	j.send(key, "count", strconv.FormatFloat(val, 'g', -1, 64))
+}
+
+func (j *JournaldPool) Value(key string, val float64, _ time.Time) {
+	j.send(key, "value", strconv.FormatFloat(val, 'g', -1, 64))
+}
+
+func (j *JournaldPool) Duration(key string, val time.Duration) {
+	j.send(key, "duration", strconv.FormatFloat(float64(val)/float64(time.Millisecond), 'g', -1, 64))
+}
+
+func (j *JournaldPool) Gauge(key string, val float64) {
+	j.send(key, "gauge", strconv.FormatFloat(val, 'g', -1, 64))
+}
+
+func (j *JournaldPool) DurationSince(key string, start time.Time) {
+	j.Duration(key, time.Since(start))
+}
+
+func (j *JournaldPool) TimeCaller(start time.Time) {
+	j.Duration(callerKey(1), time.Since(start))
+}
+
+func (j *JournaldPool) SampledCount(key string, val float64, rate float64) {
+	if r, ok := sample(key, rate); ok {
+		j.Count(key, scale(val, r))
+	}
+}
+
+func (j *JournaldPool) SampledDuration(key string, val time.Duration, rate float64) {
+	if _, ok := sample(key, rate); ok {
+		j.Duration(key, val)
+	}
+}
+
+func (j *JournaldPool) Timer(key string) *Timer {
+	return NewTimer(j, key)
+}
+
+func (j *JournaldPool) Time(key string) func() {
+	t := j.Timer(key)
+	return func() { t.Stop() }
+}
+
+func (j *JournaldPool) Close() error {
+	return j.conn.Close()
+}
+
+func (j *JournaldPool) send(key, typ, val string) {
+	buf := &bytes.Buffer{}
+	journaldField(buf, "MESSAGE", key+":"+val)
+	journaldField(buf, "PRIORITY", "6")
+	journaldField(buf, "SYSLOG_IDENTIFIER", j.identifier)
+	journaldField(buf, "STAT_KEY", key)
+	journaldField(buf, "STAT_TYPE", typ)
+	journaldField(buf, "STAT_VALUE", val)
+	j.conn.Write(buf.Bytes())
+}
+
+// journaldField writes a field in the native protocol, using
+// the length prefixed form for values containing newlines
+func journaldField(buf *bytes.Buffer, name, val string) {
+	if !strings.Contains(val, "\n") {
+		buf.WriteString(name + "=" + val + "\n")
+		return
+	}
+	buf.WriteString(name + "\n")
+	binary.Write(buf, binary.LittleEndian, uint64(len(val)))
+	buf.WriteString(val + "\n")
+}
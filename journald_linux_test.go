@@ -0,0 +1,51 @@
+package statpool
+
+import (
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var _ StaterV2 = (*JournaldPool)(nil)
+
+func TestJournaldPool(t *testing.T) {
+
+	defer func(socket string) { journaldSocket = socket }(journaldSocket)
+	journaldSocket = filepath.Join(t.TempDir(), "journal.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: journaldSocket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	stats, err := NewJournaldPool("statpool-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stats.Close()
+
+	stats.Duration("quickest time", time.Millisecond)
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry := string(buf[:n])
+	for _, field := range []string{
+		"MESSAGE=quickest time:1\n",
+		"SYSLOG_IDENTIFIER=statpool-test\n",
+		"STAT_KEY=quickest time\n",
+		"STAT_TYPE=duration\n",
+		"STAT_VALUE=1\n",
+	} {
+		if !strings.Contains(entry, field) {
+			t.Errorf("Expected entry to contain %q, got: %q", field, entry)
+		}
+	}
+
+}
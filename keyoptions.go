@@ -0,0 +1,95 @@
+package statpool
+
+import "fmt"
+
+type (
+	KeyOptions struct {
+		// report counts as a monotonically increasing total
+		// instead of the delta accumulated during each interval
+		Cumulative bool
+
+		// how to handle negative or zero values
+		Validate ValuePolicy
+
+		// cap the values reported per interval, keeping
+		// a uniform random sample when more are received
+		MaxSamples int
+
+		// report a summary of the sampled values (count, sum, min,
+		// max and quantiles) instead of the raw samples
+		Summarize bool
+		Quantiles []float64
+
+		// re-report the last value each interval the key isn't
+		// updated so slowly changing gauges don't chart with gaps
+		RepeatLast bool
+
+		// how values recorded during an interval are combined,
+		// the pool's aggregation by default
+		Aggregate Aggregation
+
+		// decides which calls to the Sampled methods are kept,
+		// the pool's sampler by default
+		Sampler Sampler
+	}
+
+	ValuePolicy int
+)
+
+const (
+	AllowAll          ValuePolicy = iota // report values as given
+	RejectNegative                       // drop values below zero
+	ClampNegative                        // report values below zero as zero
+	RejectNonPositive                    // drop values at or below zero
+)
+
+// rejected values are counted under this key
+const rejectedValuesKey = "statpool.values_rejected"
+
+// SetKeyOptions configures reporting for a single stat.  The key
+// is the full stat key as reported, including any prefix.  It returns
+// an error and leaves the key's options as they were if a quantile is
+// outside [0, 1].
+func (p *Pool) SetKeyOptions(key string, opts KeyOptions) error {
+	for _, q := range opts.Quantiles {
+		if !(q >= 0 && q <= 1) {
+			return fmt.Errorf("invalid quantile for %s: %g", key, q)
+		}
+	}
+	p.keyoptsMu.Lock()
+	p.keyopts[key] = opts
+	p.keyoptsMu.Unlock()
+	return nil
+}
+
+func (p *Pool) keyOptions(key string) KeyOptions {
+	p.keyoptsMu.RLock()
+	opts := p.keyopts[key]
+	p.keyoptsMu.RUnlock()
+	return opts
+}
+
+// samples is the reservoir size for the key or zero if
+// values are reported unsampled
+func (o KeyOptions) samples() int {
+	if o.Summarize && o.MaxSamples <= 0 {
+		return defaultSummarySamples
+	}
+	return o.MaxSamples
+}
+
+// validate applies the value policy in place and reports
+// whether the stat should be kept
+func (v ValuePolicy) validate(stat *ValueStat) bool {
+	switch v {
+	case RejectNegative:
+		return stat.Value >= 0
+	case ClampNegative:
+		if stat.Value < 0 {
+			stat.Value = 0
+		}
+	case RejectNonPositive:
+		return stat.Value > 0
+	}
+	return true
+}
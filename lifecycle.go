@@ -0,0 +1,72 @@
+package statpool
+
+import "sync/atomic"
+
+// SetIdleShutdown parks the background goroutine and its timer after
+// n consecutive flush intervals without stats.  It is started again by
+// the next stat.  Zero, the default, keeps it running.
+func (p *Pool) SetIdleShutdown(n int) {
+	atomic.StoreInt32(&p.idleLimit, int32(n))
+}
+
+// ensureRunning starts the background goroutine if it isn't running,
+// or wakes the scheduler of a scheduled pool.  Stats are queued
+// before calling it so a goroutine parking at the same time either
+// sees the queued stat or leaves it for a new start.
+func (p *Pool) ensureRunning() {
+	defer p.wakeScheduler()
+	if atomic.LoadInt32(&p.running) == 1 {
+		return
+	}
+	p.runningMu.Lock()
+	if atomic.LoadInt32(&p.running) == 0 {
+		atomic.StoreInt32(&p.running, 1)
+		p.starter()
+	}
+	p.runningMu.Unlock()
+}
+
+// waiting reports whether batches from an earlier run, or held
+// before the pool started, are waiting to be resent
+func (p *Pool) waiting() bool {
+	return p.heldStats() > 0 || p.spooled() || p.spilled() > 0
+}
+
+// resendWaiting starts the pool if batches are waiting, so they're
+// sent without waiting for a new stat
+func (p *Pool) resendWaiting() {
+	if !p.configuring && p.waiting() {
+		p.ensureRunning()
+	}
+}
+
+// park reports whether the goroutine should exit after idle empty
+// intervals.  It stays running if stats arrived in the meantime or
+// batches are held to resend, in memory or spilled to disk.
+func (p *Pool) park(idle int) bool {
+
+	limit := int(atomic.LoadInt32(&p.idleLimit))
+	if limit <= 0 || idle < limit {
+		return false
+	}
+
+	p.runningMu.Lock()
+	defer p.runningMu.Unlock()
+
+	atomic.StoreInt32(&p.running, 0)
+
+	p.heldMu.Lock()
+	held := len(p.held)
+	p.heldMu.Unlock()
+
+	if p.queued() > 0 || held > 0 || p.spilled() > 0 {
+		atomic.StoreInt32(&p.running, 1)
+		return false
+	}
+
+	if p.devlogger != nil {
+		p.devlogger.Printf("parking after %d idle intervals", idle)
+	}
+	return true
+
+}
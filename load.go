@@ -0,0 +1,113 @@
+package statpool
+
+import (
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// LoadConfig describes synthetic load for capacity testing
+	LoadConfig struct {
+		// keys are named Prefix0 through Prefix(Keys-1)
+		Prefix string
+		Keys   int
+
+		// stats per second across all workers, and for how long
+		Rate     int
+		Duration time.Duration
+		Workers  int
+
+		// relative mix of stat types, all equal when zero
+		CountWeight    float64
+		ValueWeight    float64
+		DurationWeight float64
+	}
+
+	LoadResult struct {
+		Counts    int64
+		Values    int64
+		Durations int64
+		Elapsed   time.Duration
+	}
+)
+
+// how often load workers catch up to their target rate
+const loadTick = 5 * time.Millisecond
+
+func (r LoadResult) Total() int64 {
+	return r.Counts + r.Values + r.Durations
+}
+
+// GenerateLoad emits synthetic stats to s at the configured rate
+// and returns once the duration has elapsed.
+func GenerateLoad(s Stater, cfg LoadConfig) LoadResult {
+
+	if cfg.Keys < 1 {
+		cfg.Keys = 1
+	}
+	if cfg.Workers < 1 {
+		cfg.Workers = 1
+	}
+	if cfg.CountWeight+cfg.ValueWeight+cfg.DurationWeight <= 0 {
+		cfg.CountWeight, cfg.ValueWeight, cfg.DurationWeight = 1, 1, 1
+	}
+
+	var (
+		keys   = make([]string, cfg.Keys)
+		total  = cfg.CountWeight + cfg.ValueWeight + cfg.DurationWeight
+		result LoadResult
+		wg     sync.WaitGroup
+		start  = time.Now()
+	)
+	for i := range keys {
+		keys[i] = cfg.Prefix + strconv.Itoa(i)
+	}
+
+	rate := float64(cfg.Rate) / float64(cfg.Workers)
+
+	for w := 0; w < cfg.Workers; w++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+
+			var (
+				rnd     = rand.New(rand.NewSource(seed))
+				tick    = time.NewTicker(loadTick)
+				emitted int64
+			)
+			defer tick.Stop()
+
+			for now := range tick.C {
+				elapsed := now.Sub(start)
+				if elapsed > cfg.Duration {
+					elapsed = cfg.Duration
+				}
+				for target := int64(rate * elapsed.Seconds()); emitted < target; emitted++ {
+					key := keys[rnd.Intn(len(keys))]
+					switch roll := rnd.Float64() * total; {
+					case roll < cfg.CountWeight:
+						s.Count(key, 1)
+						atomic.AddInt64(&result.Counts, 1)
+					case roll < cfg.CountWeight+cfg.ValueWeight:
+						s.Value(key, rnd.Float64()*100, now)
+						atomic.AddInt64(&result.Values, 1)
+					default:
+						s.Duration(key, time.Duration(rnd.ExpFloat64()*float64(10*time.Millisecond)))
+						atomic.AddInt64(&result.Durations, 1)
+					}
+				}
+				if elapsed >= cfg.Duration {
+					return
+				}
+			}
+		}(start.UnixNano() + int64(w))
+	}
+
+	wg.Wait()
+	result.Elapsed = time.Since(start)
+	return result
+
+}
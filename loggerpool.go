@@ -2,7 +2,6 @@ package statpool
 
 import (
 	"log"
-	"math/rand"
 	"time"
 )
 
@@ -18,6 +17,18 @@ func (l *LoggerPool) Count(key string, val float64) {
 	l.l.Printf("%s:%g", key, val)
 }
 
+func (l *LoggerPool) Inc(key string) {
+	l.Count(key, 1)
+}
+
+func (l *LoggerPool) Dec(key string) {
+	l.Count(key, -1)
+}
+
+func (l *LoggerPool) Add(key string, delta float64) {
+	l.Count(key, delta)
+}
+
 func (l *LoggerPool) Value(key string, val float64, _ time.Time) {
 	l.l.Printf("%s:%g", key, val)
 }
@@ -26,8 +37,55 @@ func (l *LoggerPool) Duration(key string, val time.Duration) {
 	l.l.Printf("%s:%s", key, val)
 }
 
+func (l *LoggerPool) Gauge(key string, val float64) {
+	l.l.Printf("%s:%g", key, val)
+}
+
+func (l *LoggerPool) Histogram(key string, val float64) {
+	l.l.Printf("%s:%g", key, val)
+}
+
+func (l *LoggerPool) CountWithTags(key string, val float64, tags ...Tag) {
+	l.l.Printf("%s%s:%g", key, formatTags(tags), val)
+}
+
+func (l *LoggerPool) ValueWithTags(key string, val float64, _ time.Time, tags ...Tag) {
+	l.l.Printf("%s%s:%g", key, formatTags(tags), val)
+}
+
+func (l *LoggerPool) GaugeWithTags(key string, val float64, tags ...Tag) {
+	l.l.Printf("%s%s:%g", key, formatTags(tags), val)
+}
+
+func (l *LoggerPool) DurationWithTags(key string, val time.Duration, tags ...Tag) {
+	l.l.Printf("%s%s:%s", key, formatTags(tags), val)
+}
+
+func (l *LoggerPool) DurationSince(key string, start time.Time) {
+	l.Duration(key, time.Since(start))
+}
+
+func (l *LoggerPool) Timer(key string) *Timer {
+	return NewTimer(l, key)
+}
+
+func (l *LoggerPool) Time(key string) func() {
+	t := l.Timer(key)
+	return func() { t.Stop() }
+}
+
+func (l *LoggerPool) TimeCaller(start time.Time) {
+	l.Duration(callerKey(1), time.Since(start))
+}
+
+func (l *LoggerPool) SampledCount(key string, val float64, rate float64) {
+	if r, ok := sample(key, rate); ok {
+		l.Count(key, scale(val, r))
+	}
+}
+
 func (l *LoggerPool) SampledDuration(key string, val time.Duration, rate float64) {
-	if rate < rand.Float64() {
+	if _, ok := sample(key, rate); ok {
 		l.l.Printf("%s:%s", key, val)
 	}
 }
@@ -0,0 +1,104 @@
+package statpool
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type (
+	// MiddlewareOption configures HTTPMiddleware
+	MiddlewareOption func(*middleware)
+
+	middleware struct {
+		s      Stater
+		prefix string
+		route  func(*http.Request) string
+	}
+
+	// statusWriter captures the status code written by a handler
+	statusWriter struct {
+		http.ResponseWriter
+		status int
+	}
+)
+
+// HTTPMiddleware records for every request, under the key prefix and
+// the request's route,
+//
+//	http.<route>.requests     count of requests
+//	http.<route>.duration     time spent handling them
+//	http.<route>.status.2xx   count of requests per status class
+//
+// Routes default to the method and path, e.g. "GET /users".  Paths
+// with ids in them should be mapped to a route with WithRouteFunc to
+// keep the number of keys down.  Handlers can get s from the request
+// context with FromContext.
+func HTTPMiddleware(s Stater, opts ...MiddlewareOption) func(http.Handler) http.Handler {
+	m := &middleware{
+		s:      s,
+		prefix: "http.",
+		route:  func(req *http.Request) string { return req.Method + " " + req.URL.Path },
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: w}
+			next.ServeHTTP(sw, req.WithContext(NewContext(req.Context(), m.s)))
+			m.record(m.route(req), sw.code(), time.Since(start))
+		})
+	}
+}
+
+// WithRouteFunc names the route a request is recorded under
+func WithRouteFunc(fn func(*http.Request) string) MiddlewareOption {
+	return func(m *middleware) { m.route = fn }
+}
+
+// WithKeyPrefix replaces the http. prefix of the keys recorded
+func WithKeyPrefix(prefix string) MiddlewareOption {
+	return func(m *middleware) { m.prefix = prefix }
+}
+
+func (m *middleware) record(route string, status int, d time.Duration) {
+	key := m.prefix + route
+	m.s.Count(key+".requests", 1)
+	m.s.Duration(key+".duration", d)
+	m.s.Count(key+".status."+strconv.Itoa(status/100)+"xx", 1)
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// code is the status sent, handlers that write nothing send 200
+func (w *statusWriter) code() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
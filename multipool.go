@@ -0,0 +1,145 @@
+package statpool
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+type (
+	// MultiPool forwards every stat to several Staters.  Each Stater
+	// is fed from its own queue so a slow one doesn't block callers
+	// or the others; stats for a Stater whose queue is full are dropped.
+	MultiPool struct {
+		sinks []*multiSink
+		log   *log.Logger
+		wg    sync.WaitGroup
+	}
+
+	multiSink struct {
+		s   Stater
+		ops chan func(Stater)
+	}
+
+	gauger interface {
+		Gauge(key string, val float64)
+	}
+
+	histogrammer interface {
+		Histogram(key string, val float64)
+	}
+)
+
+func NewMultiPool(staters ...Stater) *MultiPool {
+	m := &MultiPool{
+		log: log.New(os.Stderr, "statpool: ", log.LstdFlags),
+	}
+	for _, s := range staters {
+		sink := &multiSink{s: s, ops: make(chan func(Stater), DefaultBufferSize)}
+		m.sinks = append(m.sinks, sink)
+		m.wg.Add(1)
+		go m.run(sink)
+	}
+	return m
+}
+
+func (m *MultiPool) SetLogger(l *log.Logger) {
+	m.log = l
+}
+
+func (m *MultiPool) Count(key string, val float64) {
+	m.each(func(s Stater) { s.Count(key, val) })
+}
+
+func (m *MultiPool) Value(key string, val float64, timestamp time.Time) {
+	m.each(func(s Stater) { s.Value(key, val, timestamp) })
+}
+
+func (m *MultiPool) Duration(key string, val time.Duration) {
+	m.each(func(s Stater) { s.Duration(key, val) })
+}
+
+// Gauge is sent as a value to Staters without gauges
+func (m *MultiPool) Gauge(key string, val float64) {
+	now := time.Now()
+	m.each(func(s Stater) {
+		if g, ok := s.(gauger); ok {
+			g.Gauge(key, val)
+		} else {
+			s.Value(key, val, now)
+		}
+	})
+}
+
+// Histogram is sent as a value to Staters without histograms
+func (m *MultiPool) Histogram(key string, val float64) {
+	now := time.Now()
+	m.each(func(s Stater) {
+		if h, ok := s.(histogrammer); ok {
+			h.Histogram(key, val)
+		} else {
+			s.Value(key, val, now)
+		}
+	})
+}
+
+func (m *MultiPool) DurationSince(key string, start time.Time) {
+	m.Duration(key, time.Since(start))
+}
+
+func (m *MultiPool) TimeCaller(start time.Time) {
+	m.Duration(callerKey(1), time.Since(start))
+}
+
+// SampledDuration samples once so every Stater gets the same calls
+func (m *MultiPool) SampledDuration(key string, val time.Duration, rate float64) {
+	if _, ok := sample(key, rate); ok {
+		m.Duration(key, val)
+	}
+}
+
+func (m *MultiPool) Timer(key string) *Timer {
+	return NewTimer(m, key)
+}
+
+func (m *MultiPool) Time(key string) func() {
+	t := m.Timer(key)
+	return func() { t.Stop() }
+}
+
+// Close waits for queued stats to be handed to every Stater.  It
+// doesn't stop or close the Staters themselves.
+func (m *MultiPool) Close() {
+	for _, sink := range m.sinks {
+		close(sink.ops)
+	}
+	m.wg.Wait()
+}
+
+func (m *MultiPool) each(op func(Stater)) {
+	for _, sink := range m.sinks {
+		select {
+		case sink.ops <- op:
+		default:
+			m.log.Printf("%T backed up, dropping stat", sink.s)
+		}
+	}
+}
+
+func (m *MultiPool) run(sink *multiSink) {
+	defer m.wg.Done()
+	for op := range sink.ops {
+		m.call(sink.s, op)
+	}
+}
+
+// call recovers a panicking Stater so the others keep receiving
+func (m *MultiPool) call(s Stater, op func(Stater)) {
+	defer func() {
+		if err := recover(); err != nil {
+			m.log.Printf("%T failed: %v", s, err)
+		}
+	}()
+	op(s)
+}
@@ -8,7 +8,22 @@ func NewNilPool() NilPool {
 	return NilPool{}
 }
 
-func (_ NilPool) Count(_ string, _ float64)                               {}
-func (_ NilPool) Value(_ string, _ float64, _ time.Time)                  {}
-func (_ NilPool) Duration(_ string, _ time.Duration)                      {}
-func (_ NilPool) SampledDuration(_ string, _ time.Duration, rate float64) {}
+func (_ NilPool) Count(_ string, _ float64)                                {}
+func (_ NilPool) Inc(_ string)                                             {}
+func (_ NilPool) Dec(_ string)                                             {}
+func (_ NilPool) Add(_ string, _ float64)                                  {}
+func (_ NilPool) Value(_ string, _ float64, _ time.Time)                   {}
+func (_ NilPool) Duration(_ string, _ time.Duration)                       {}
+func (_ NilPool) Gauge(_ string, _ float64)                                {}
+func (_ NilPool) Histogram(_ string, _ float64)                            {}
+func (_ NilPool) CountWithTags(_ string, _ float64, _ ...Tag)              {}
+func (_ NilPool) ValueWithTags(_ string, _ float64, _ time.Time, _ ...Tag) {}
+func (_ NilPool) GaugeWithTags(_ string, _ float64, _ ...Tag)              {}
+func (_ NilPool) DurationWithTags(_ string, _ time.Duration, _ ...Tag)     {}
+func (_ NilPool) Unique(_ string, _ string)                                {}
+func (_ NilPool) DurationSince(_ string, _ time.Time)                      {}
+func (_ NilPool) TimeCaller(_ time.Time)                                   {}
+func (n NilPool) Timer(key string) *Timer                                  { return NewTimer(n, key) }
+func (_ NilPool) Time(_ string) func()                                     { return func() {} }
+func (_ NilPool) SampledCount(_ string, _ float64, _ float64)              {}
+func (_ NilPool) SampledDuration(_ string, _ time.Duration, rate float64)  {}
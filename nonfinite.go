@@ -0,0 +1,65 @@
+package statpool
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+type NonFinitePolicy int32
+
+const (
+	DropNonFinite    NonFinitePolicy = iota // drop NaN and ±Inf samples
+	ReplaceNonFinite                        // report NaN as 0 and ±Inf as ±MaxFloat64
+)
+
+// filtered samples are counted under this key
+const nonFiniteKey = "statpool.nonfinite_filtered"
+
+// SetNonFinitePolicy controls how NaN and ±Inf samples are handled.
+// They cannot be JSON encoded so they are never sent as is.  The
+// policy is applied again at flush to aggregates that overflow, e.g.
+// the sum of two replaced +Inf counts.
+func (p *Pool) SetNonFinitePolicy(policy NonFinitePolicy) {
+	atomic.StoreInt32((*int32)(&p.nonfinite), int32(policy))
+}
+
+// filterNonFinite applies the pool's policy to v and reports
+// whether the sample should be kept
+func (p *Pool) filterNonFinite(v *float64) bool {
+	if !math.IsNaN(*v) && !math.IsInf(*v, 0) {
+		return true
+	}
+	p.SendCount(&CountStat{Key: p.prefix + nonFiniteKey, Count: 1})
+	if NonFinitePolicy(atomic.LoadInt32((*int32)(&p.nonfinite))) != ReplaceNonFinite {
+		return false
+	}
+	switch {
+	case math.IsInf(*v, 1):
+		*v = math.MaxFloat64
+	case math.IsInf(*v, -1):
+		*v = -math.MaxFloat64
+	default:
+		*v = 0
+	}
+	return true
+}
+
+// finite applies the policy to the stats of a flush, so an aggregate
+// that overflowed can't fail to encode along with its whole chunk
+func (p *Pool) finite(values []interface{}) []interface{} {
+	kept := values[:0]
+	for _, v := range values {
+		switch stat := v.(type) {
+		case *CountStat:
+			if !p.filterNonFinite(&stat.Count) {
+				continue
+			}
+		case *ValueStat:
+			if !p.filterNonFinite(&stat.Value) {
+				continue
+			}
+		}
+		kept = append(kept, v)
+	}
+	return kept
+}
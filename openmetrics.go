@@ -0,0 +1,180 @@
+package statpool
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	ContentTypeOpenMetrics = "application/openmetrics-text"
+	ContentTypePrometheus  = "text/plain"
+)
+
+// counterState tracks the last seen value of cumulative
+// series so they can be reported as per-interval counts
+type counterState struct {
+	sync.Mutex
+	last map[string]float64
+}
+
+// delta reports the increase of a cumulative series since it was
+// last seen.  The first observation only establishes a baseline and
+// a decrease is treated as a counter reset.
+func (c *counterState) delta(key string, val float64) (float64, bool) {
+	c.Lock()
+	defer c.Unlock()
+	if c.last == nil {
+		c.last = map[string]float64{}
+	}
+	last, seen := c.last[key]
+	c.last[key] = val
+	if !seen {
+		return 0, false
+	}
+	if val < last {
+		return val, true
+	}
+	return val - last, true
+}
+
+// ReadOpenMetrics ingests OpenMetrics or Prometheus text exposition.
+// Counters (and histogram/summary _count and _sum series) are reported
+// as counts of their increase since the previous read, gauges and
+// summary quantiles as values.  Histogram buckets are skipped.  Labels
+// are kept in the key, e.g. http_requests{code="200"}.
+func (s *Server) ReadOpenMetrics(r io.Reader, timestampsInMillis bool) error {
+
+	var (
+		types   = map[string]string{}
+		stats   []interface{}
+		scanner = bufio.NewScanner(r)
+	)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			if fields := strings.Fields(line); len(fields) >= 4 && fields[1] == "TYPE" {
+				types[fields[2]] = fields[3]
+			}
+			continue
+		}
+
+		name, key, val, ts, err := parseOpenMetricsLine(line)
+		if err != nil {
+			return err
+		}
+		if math.IsNaN(val) {
+			continue
+		}
+
+		var timestamp int64
+		if ts != 0 {
+			if timestampsInMillis {
+				ts /= 1000
+			}
+			timestamp = int64(ts)
+		}
+
+		switch family, suffix := metricFamily(name, types); types[family] {
+		case "counter":
+			if suffix == "_created" {
+				continue
+			}
+			if d, ok := s.counters.delta(key, val); ok {
+				stats = append(stats, &CountStat{Key: key, Count: d, Timestamp: timestamp})
+			}
+		case "histogram", "summary":
+			switch suffix {
+			case "_count", "_sum":
+				if d, ok := s.counters.delta(key, val); ok {
+					stats = append(stats, &CountStat{Key: key, Count: d, Timestamp: timestamp})
+				}
+			case "":
+				// summary quantiles
+				stats = append(stats, &ValueStat{Key: key, Value: val, Timestamp: timestamp})
+			}
+		default:
+			stats = append(stats, &ValueStat{Key: key, Value: val, Timestamp: timestamp})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	s.receive(stats)
+	return nil
+
+}
+
+// ScrapeOpenMetrics fetches url and ingests the exposition it returns.
+// Call it periodically to collect metrics from a non-Go process.
+func (s *Server) ScrapeOpenMetrics(url string) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &HTTPStatusError{resp.StatusCode}
+	}
+	return s.ReadOpenMetrics(resp.Body, !strings.HasPrefix(resp.Header.Get("Content-Type"), ContentTypeOpenMetrics))
+}
+
+// metricFamily finds the declared family of a series name
+// along with any _count, _sum, _bucket or _total suffix
+func metricFamily(name string, types map[string]string) (string, string) {
+	if _, exists := types[name]; exists {
+		return name, ""
+	}
+	for _, suffix := range []string{"_count", "_sum", "_bucket", "_total", "_created"} {
+		if family := strings.TrimSuffix(name, suffix); family != name {
+			if _, exists := types[family]; exists {
+				return family, suffix
+			}
+		}
+	}
+	return name, ""
+}
+
+// parseOpenMetricsLine splits a sample line of the form
+// name{labels} value [timestamp]
+func parseOpenMetricsLine(line string) (name, key string, val, ts float64, err error) {
+
+	rest := line
+	if i := strings.IndexAny(line, "{ "); i < 0 {
+		return "", "", 0, 0, fmt.Errorf("invalid openmetrics line: %q", line)
+	} else if line[i] == '{' {
+		j := strings.LastIndex(line, "}")
+		if j < i {
+			return "", "", 0, 0, fmt.Errorf("invalid openmetrics line: %q", line)
+		}
+		name, key, rest = line[:i], line[:j+1], line[j+1:]
+	} else {
+		name, key, rest = line[:i], line[:i], line[i:]
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) < 1 || len(fields) > 2 {
+		return "", "", 0, 0, fmt.Errorf("invalid openmetrics line: %q", line)
+	}
+	if val, err = strconv.ParseFloat(fields[0], 64); err != nil {
+		return "", "", 0, 0, fmt.Errorf("invalid openmetrics value: %q", line)
+	}
+	if len(fields) == 2 {
+		if ts, err = strconv.ParseFloat(fields[1], 64); err != nil {
+			return "", "", 0, 0, fmt.Errorf("invalid openmetrics timestamp: %q", line)
+		}
+	}
+
+	return name, key, val, ts, nil
+
+}
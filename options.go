@@ -0,0 +1,276 @@
+package statpool
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Option configures a Pool created with NewPoolWithOptions
+type Option func(*Pool)
+
+// NewPoolWithOptions creates a pool reporting to StatHat every
+// DefaultFlushInterval unless configured otherwise.
+//
+//	pool := statpool.NewPoolWithOptions(
+//		statpool.WithEZKey(key),
+//		statpool.WithFlushInterval(time.Minute),
+//		statpool.WithPrefix("api."),
+//	)
+func NewPoolWithOptions(opts ...Option) *Pool {
+	p := newPool(DefaultStathatEndpoint, "")
+	p.configuring = true
+	for _, opt := range opts {
+		opt(p)
+	}
+	p.configuring = false
+	p.resendWaiting()
+	return p
+}
+
+// WithEndpoint sets the endpoints stats are sent to, see SetEndpoints
+func WithEndpoint(urls ...string) Option {
+	return func(p *Pool) { p.SetEndpoints(urls...) }
+}
+
+// WithHealthCheck probes failed endpoints to bring them back, see
+// SetHealthCheck
+func WithHealthCheck(path string, interval time.Duration) Option {
+	return func(p *Pool) { p.SetHealthCheck(path, interval) }
+}
+
+func WithEZKey(ezKey string) Option {
+	return func(p *Pool) { p.ezKey = ezKey }
+}
+
+func WithFlushInterval(d time.Duration) Option {
+	return func(p *Pool) { p.interval = int64(d) }
+}
+
+func WithHTTPClient(client *http.Client) Option {
+	return func(p *Pool) { p.client = client }
+}
+
+// WithChunkSize sets the most stats sent in a single request
+func WithChunkSize(n int) Option {
+	return func(p *Pool) {
+		if n > 0 {
+			p.chunkSize = n
+		}
+	}
+}
+
+// WithBufferSize sets how many stats of each type can be queued
+// for aggregation before new stats are dropped
+func WithBufferSize(n int) Option {
+	return func(p *Pool) {
+		if n > 0 {
+			p.count = make(chan *CountStat, n)
+			p.value = make(chan *ValueStat, n)
+		}
+	}
+}
+
+// WithLogger sets where flush errors and dropped stats are logged
+func WithLogger(l *log.Logger) Option {
+	return func(p *Pool) { p.log = l }
+}
+
+func WithDevLogger(l *log.Logger) Option {
+	return func(p *Pool) { p.SetDevLogger(l) }
+}
+
+func WithPrefix(prefix string) Option {
+	return func(p *Pool) { p.SetPrefix(prefix) }
+}
+
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(p *Pool) { p.SetRetryPolicy(policy) }
+}
+
+// WithSpool spools batches that can't be sent to dir, see SetSpool
+func WithSpool(dir string, maxBytes int64) Option {
+	return func(p *Pool) {
+		if err := p.SetSpool(dir, maxBytes); err != nil {
+			p.report(fmt.Errorf("spool disabled: %w", err), 0)
+		}
+	}
+}
+
+// WithRelabelRules renames and drops keys at flush, see
+// SetRelabelRules
+func WithRelabelRules(rules ...RelabelRule) Option {
+	return func(p *Pool) {
+		if err := p.SetRelabelRules(rules...); err != nil {
+			p.report(fmt.Errorf("relabel rules ignored: %w", err), 0)
+		}
+	}
+}
+
+// WithSender delivers stats with s instead of http, see SetSender
+func WithSender(s Sender) Option {
+	return func(p *Pool) { p.SetSender(s) }
+}
+
+// WithDryRun writes payloads to w rather than posting them, see
+// SetDryRun
+func WithDryRun(w io.Writer) Option {
+	return func(p *Pool) { p.SetDryRun(w) }
+}
+
+// WithOnError routes errors to fn instead of the logger, see OnError
+func WithOnError(fn func(err error, droppedStats int)) Option {
+	return func(p *Pool) { p.OnError(fn) }
+}
+
+// WithGzip compresses payloads, see SetGzip
+func WithGzip() Option {
+	return func(p *Pool) { p.SetGzip(true) }
+}
+
+// WithReportStats reports the pool's own stats, see SetReportStats
+func WithReportStats() Option {
+	return func(p *Pool) { p.SetReportStats(true) }
+}
+
+// WithDropPolicy sets how stats are dropped, see SetDropPolicy
+func WithDropPolicy(policy DropPolicy, timeout time.Duration) Option {
+	return func(p *Pool) { p.SetDropPolicy(policy, timeout) }
+}
+
+func WithOnDrop(fn func(Stat)) Option {
+	return func(p *Pool) { p.OnDrop(fn) }
+}
+
+// WithAggregation sets how values are combined, see SetAggregation
+func WithAggregation(a Aggregation) Option {
+	return func(p *Pool) { p.SetAggregation(a) }
+}
+
+// WithSampler sets the sampler for Sampled methods, see SetSampler
+func WithSampler(s Sampler) Option {
+	return func(p *Pool) { p.SetSampler(s) }
+}
+
+// WithMemoryLimit spills held stats to disk, see SetMemoryLimit
+func WithMemoryLimit(maxBytes int64, dir string) Option {
+	return func(p *Pool) {
+		if err := p.SetMemoryLimit(maxBytes, dir); err != nil {
+			p.report(fmt.Errorf("memory limit disabled: %w", err), 0)
+		}
+	}
+}
+
+// WithRecoveryFile saves unsent stats at stop, see SetRecoveryFile
+func WithRecoveryFile(path string) Option {
+	return func(p *Pool) {
+		if err := p.SetRecoveryFile(path); err != nil {
+			p.report(fmt.Errorf("recovery file: %w", err), 0)
+		}
+	}
+}
+
+// WithHoldLimit sets the most stats held to resend, see SetHoldLimit
+func WithHoldLimit(n int) Option {
+	return func(p *Pool) { p.SetHoldLimit(n) }
+}
+
+// WithCircuitBreaker stops sending during outages, see SetCircuitBreaker
+func WithCircuitBreaker(failures int, probeInterval time.Duration) Option {
+	return func(p *Pool) { p.SetCircuitBreaker(failures, probeInterval) }
+}
+
+func WithOnStateChange(fn func(from, to BreakerState)) Option {
+	return func(p *Pool) { p.OnStateChange(fn) }
+}
+
+// WithMaxSends limits the chunks sent at once, see SetMaxSends
+func WithMaxSends(n int) Option {
+	return func(p *Pool) { p.SetMaxSends(n) }
+}
+
+// WithStreaming streams json payloads, see SetStreaming
+func WithStreaming() Option {
+	return func(p *Pool) { p.SetStreaming(true) }
+}
+
+// WithMaxPayloadBytes limits the size of requests, see SetMaxPayloadBytes
+func WithMaxPayloadBytes(n int) Option {
+	return func(p *Pool) { p.SetMaxPayloadBytes(n) }
+}
+
+// WithHighWaterMark flushes early, see SetHighWaterMark
+func WithHighWaterMark(n int) Option {
+	return func(p *Pool) { p.SetHighWaterMark(n) }
+}
+
+// WithAdaptiveFlush follows the traffic, see SetAdaptiveFlush
+func WithAdaptiveFlush(min, max time.Duration, threshold int) Option {
+	return func(p *Pool) { p.SetAdaptiveFlush(min, max, threshold) }
+}
+
+// WithFlushJitter delays each flush at random, see SetFlushJitter
+func WithFlushJitter(d time.Duration) Option {
+	return func(p *Pool) { p.SetFlushJitter(d) }
+}
+
+// WithAlignedFlushes aligns flushes to the wall clock, see SetAlignFlushes
+func WithAlignedFlushes() Option {
+	return func(p *Pool) { p.SetAlignFlushes(true) }
+}
+
+// WithDisabled creates the pool disabled, see Disable
+func WithDisabled() Option {
+	return func(p *Pool) { p.Disable() }
+}
+
+// WithPaused creates the pool paused, see Pause
+func WithPaused() Option {
+	return func(p *Pool) { p.Pause() }
+}
+
+// WithClock sets the pool's time source, see SetClock
+func WithClock(c Clock) Option {
+	return func(p *Pool) { p.SetClock(c) }
+}
+
+// WithKeySanitizer cleans up keys with s, see SetKeySanitizer
+func WithKeySanitizer(s KeySanitizer) Option {
+	return func(p *Pool) { p.SetKeySanitizer(s) }
+}
+
+// WithCardinalityLimit caps the distinct keys accepted, see
+// SetCardinalityLimit
+func WithCardinalityLimit(perInterval, total int, policy CardinalityPolicy) Option {
+	return func(p *Pool) { p.SetCardinalityLimit(perInterval, total, policy) }
+}
+
+// WithHostSuffix adds the hostname, up to the first dot, to keys,
+// see SetInstance
+func WithHostSuffix() Option {
+	return func(p *Pool) { p.instance.ID = shortHostname() }
+}
+
+// WithInstanceID adds id to keys, see SetInstance
+func WithInstanceID(id string) Option {
+	return func(p *Pool) { p.instance.ID = id }
+}
+
+// WithInstanceTag adds the host or instance id as a tag named name
+// rather than a key suffix, see SetInstance
+func WithInstanceTag(name string) Option {
+	return func(p *Pool) { p.instance.TagName = name }
+}
+
+// WithInstanceAggregate also reports stats without the host or
+// instance id, see SetInstance
+func WithInstanceAggregate() Option {
+	return func(p *Pool) { p.instance.Aggregate = true }
+}
+
+// WithShards spreads aggregation over n goroutines, see SetShards
+func WithShards(n int) Option {
+	return func(p *Pool) { p.SetShards(n) }
+}
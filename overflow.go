@@ -0,0 +1,121 @@
+package statpool
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// rough size in memory of a held stat besides its key and tags
+const heldStatOverhead = 96
+
+// overflow spills the oldest held batches to disk past a memory budget
+type overflow struct {
+	spool
+	budget int64
+}
+
+// SetMemoryLimit caps the memory used by stats held to resend, while
+// the endpoint is down, throttling or paused, at about maxBytes.  The
+// oldest batches past it are written to files in dir and read back a
+// budget at a time with the flushes that send, including by the next
+// pool to use dir, so it should be kept across restarts.  Zero
+// maxBytes keeps everything in memory, up to the hold limit.
+func (p *Pool) SetMemoryLimit(maxBytes int64, dir string) error {
+	if maxBytes <= 0 {
+		p.overflow.Store((*overflow)(nil))
+		return nil
+	}
+	if dir == "" {
+		return errors.New("memory limit needs a directory to spill to")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	p.overflow.Store(&overflow{spool: spool{dir: dir}, budget: maxBytes})
+	return nil
+}
+
+func (p *Pool) getOverflow() *overflow {
+	o, _ := p.overflow.Load().(*overflow)
+	return o
+}
+
+// batchBytes estimates the memory held by a batch
+func batchBytes(b *batch) int64 {
+	n := int64(len(b.ezKey))
+	for _, v := range b.stats {
+		n += heldStatOverhead
+		switch stat := v.(type) {
+		case *CountStat:
+			n += int64(len(stat.Key))
+			for _, tag := range stat.Tags {
+				n += int64(len(tag.Key) + len(tag.Value))
+			}
+		case *ValueStat:
+			n += int64(len(stat.Key))
+			for _, tag := range stat.Tags {
+				n += int64(len(tag.Key) + len(tag.Value))
+			}
+		}
+	}
+	return n
+}
+
+// overBudget removes the oldest held batches past the memory budget,
+// called with heldMu locked
+func (o *overflow) overBudget(held []*batch) (kept, spill []*batch) {
+	var size int64
+	for _, b := range held {
+		size += batchBytes(b)
+	}
+	for size > o.budget && len(held) > 0 {
+		size -= batchBytes(held[0])
+		spill = append(spill, held[0])
+		held = held[1:]
+	}
+	return held, spill
+}
+
+// spill writes batches to the overflow files, returning those that
+// couldn't be written
+func (p *Pool) spill(o *overflow, batches []*batch) []*batch {
+	for i, b := range batches {
+		if err := o.write(p.ezKeyOr(b.ezKey), b); err != nil {
+			p.report(fmt.Errorf("overflow write failed: %w", err), 0)
+			return batches[i:]
+		}
+	}
+	if p.devlogger != nil {
+		p.devlogger.Printf("spilled %d batches over the memory limit", len(batches))
+	}
+	return nil
+}
+
+// spilled counts the batches waiting in the overflow files
+func (p *Pool) spilled() int {
+	o := p.getOverflow()
+	if o == nil {
+		return 0
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	files, err := o.files()
+	if err != nil {
+		return 0
+	}
+	return len(files)
+}
+
+// takeOverflow reads back a memory budget of the oldest spilled batches
+func (p *Pool) takeOverflow() []*batch {
+	o := p.getOverflow()
+	if o == nil {
+		return nil
+	}
+	batches, err := o.take(o.budget)
+	if err != nil {
+		p.report(fmt.Errorf("overflow read failed: %w", err), 0)
+	}
+	return batches
+}
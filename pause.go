@@ -0,0 +1,22 @@
+package statpool
+
+import "sync/atomic"
+
+// Pause stops the pool sending while it goes on aggregating, e.g.
+// while the egress proxy is down for maintenance.  Each interval's
+// stats are held until Resume, up to the hold limit, past which the
+// oldest are dropped and reported.  Stats still held when the pool
+// stops are reported as unsent.
+func (p *Pool) Pause() {
+	atomic.StoreInt32(&p.paused, 1)
+}
+
+// Resume sends the stats held while paused with the next flush
+func (p *Pool) Resume() {
+	atomic.StoreInt32(&p.paused, 0)
+}
+
+// Paused reports whether sending is paused
+func (p *Pool) Paused() bool {
+	return atomic.LoadInt32(&p.paused) == 1
+}
@@ -0,0 +1,102 @@
+package statpool
+
+import "time"
+
+// PrefixedPool reports to a Pool with its own prefix added to keys,
+// after the pool's prefix.  It is cheap to create and safe to use
+// concurrently with other PrefixedPools of the same pool.
+type PrefixedPool struct {
+	p      *Pool
+	prefix string
+}
+
+// WithPrefix returns a view of the pool that prefixes keys, so each
+// subsystem can report under its own namespace
+//
+//	db := pool.WithPrefix("db.")
+//	db.Count("queries", 1) // reported as db.queries
+func (p *Pool) WithPrefix(prefix string) *PrefixedPool {
+	return &PrefixedPool{p: p, prefix: prefix}
+}
+
+// WithPrefix nests another prefix after this one
+func (w *PrefixedPool) WithPrefix(prefix string) *PrefixedPool {
+	return &PrefixedPool{p: w.p, prefix: w.prefix + prefix}
+}
+
+func (w *PrefixedPool) Count(key string, val float64) {
+	w.p.Count(w.prefix+key, val)
+}
+
+func (w *PrefixedPool) Inc(key string) {
+	w.Count(key, 1)
+}
+
+func (w *PrefixedPool) Dec(key string) {
+	w.Count(key, -1)
+}
+
+func (w *PrefixedPool) Add(key string, delta float64) {
+	w.Count(key, delta)
+}
+
+func (w *PrefixedPool) Value(key string, val float64, timestamp time.Time) {
+	w.p.Value(w.prefix+key, val, timestamp)
+}
+
+func (w *PrefixedPool) Duration(key string, val time.Duration) {
+	w.p.Duration(w.prefix+key, val)
+}
+
+func (w *PrefixedPool) Gauge(key string, val float64) {
+	w.p.Gauge(w.prefix+key, val)
+}
+
+func (w *PrefixedPool) Histogram(key string, val float64) {
+	w.p.Histogram(w.prefix+key, val)
+}
+
+func (w *PrefixedPool) CountWithTags(key string, val float64, tags ...Tag) {
+	w.p.CountWithTags(w.prefix+key, val, tags...)
+}
+
+func (w *PrefixedPool) ValueWithTags(key string, val float64, timestamp time.Time, tags ...Tag) {
+	w.p.ValueWithTags(w.prefix+key, val, timestamp, tags...)
+}
+
+func (w *PrefixedPool) GaugeWithTags(key string, val float64, tags ...Tag) {
+	w.p.GaugeWithTags(w.prefix+key, val, tags...)
+}
+
+func (w *PrefixedPool) DurationWithTags(key string, val time.Duration, tags ...Tag) {
+	w.p.DurationWithTags(w.prefix+key, val, tags...)
+}
+
+func (w *PrefixedPool) Unique(key string, member string) {
+	w.p.Unique(w.prefix+key, member)
+}
+
+func (w *PrefixedPool) DurationSince(key string, start time.Time) {
+	w.Duration(key, time.Since(start))
+}
+
+func (w *PrefixedPool) TimeCaller(start time.Time) {
+	w.Duration(callerKey(1), time.Since(start))
+}
+
+func (w *PrefixedPool) SampledCount(key string, val float64, rate float64) {
+	w.p.SampledCount(w.prefix+key, val, rate)
+}
+
+func (w *PrefixedPool) SampledDuration(key string, val time.Duration, rate float64) {
+	w.p.SampledDuration(w.prefix+key, val, rate)
+}
+
+func (w *PrefixedPool) Timer(key string) *Timer {
+	return NewTimer(w, key)
+}
+
+func (w *PrefixedPool) Time(key string) func() {
+	t := w.Timer(key)
+	return func() { t.Stop() }
+}
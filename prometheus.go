@@ -0,0 +1,267 @@
+package statpool
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// escaping of label values and help text
+	promEscape     = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	promHelpEscape = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+// DefaultPrometheusBuckets are the histogram bucket bounds used
+// unless set with SetBuckets, suited to durations in seconds
+var DefaultPrometheusBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type (
+	// PrometheusPool keeps stats in memory and serves them in the
+	// Prometheus text format for scraping.  Counts are reported as
+	// counters, gauges as gauges, and values, durations (in seconds)
+	// and histograms as histograms.  Tags are reported as labels and
+	// keys are sanitized to valid metric names.
+	PrometheusPool struct {
+		buckets  []float64
+		families map[string]*promFamily
+		mu       sync.Mutex
+	}
+
+	promFamily struct {
+		typ    string
+		help   string
+		series map[string]*promSeries
+	}
+
+	promSeries struct {
+		value   float64
+		count   uint64
+		bounds  []float64
+		buckets []uint64
+	}
+)
+
+func NewPrometheusPool() *PrometheusPool {
+	return &PrometheusPool{
+		buckets:  DefaultPrometheusBuckets,
+		families: map[string]*promFamily{},
+	}
+}
+
+// SetBuckets sets the upper bounds of histogram buckets for
+// histograms created after it is called
+func (p *PrometheusPool) SetBuckets(bounds ...float64) {
+	bounds = append([]float64(nil), bounds...)
+	sort.Float64s(bounds)
+	p.mu.Lock()
+	p.buckets = bounds
+	p.mu.Unlock()
+}
+
+func (p *PrometheusPool) Count(key string, val float64) {
+	p.observe("counter", key, val, nil)
+}
+
+func (p *PrometheusPool) Value(key string, val float64, _ time.Time) {
+	p.observe("histogram", key, val, nil)
+}
+
+func (p *PrometheusPool) Duration(key string, val time.Duration) {
+	p.observe("histogram", key, val.Seconds(), nil)
+}
+
+func (p *PrometheusPool) Gauge(key string, val float64) {
+	p.observe("gauge", key, val, nil)
+}
+
+func (p *PrometheusPool) Histogram(key string, val float64) {
+	p.observe("histogram", key, val, nil)
+}
+
+func (p *PrometheusPool) CountWithTags(key string, val float64, tags ...Tag) {
+	p.observe("counter", key, val, tags)
+}
+
+func (p *PrometheusPool) ValueWithTags(key string, val float64, _ time.Time, tags ...Tag) {
+	p.observe("histogram", key, val, tags)
+}
+
+func (p *PrometheusPool) GaugeWithTags(key string, val float64, tags ...Tag) {
+	p.observe("gauge", key, val, tags)
+}
+
+func (p *PrometheusPool) DurationWithTags(key string, val time.Duration, tags ...Tag) {
+	p.observe("histogram", key, val.Seconds(), tags)
+}
+
+func (p *PrometheusPool) DurationSince(key string, start time.Time) {
+	p.Duration(key, time.Since(start))
+}
+
+func (p *PrometheusPool) Timer(key string) *Timer {
+	return NewTimer(p, key)
+}
+
+func (p *PrometheusPool) Time(key string) func() {
+	t := p.Timer(key)
+	return func() { t.Stop() }
+}
+
+func (p *PrometheusPool) TimeCaller(start time.Time) {
+	p.Duration(callerKey(1), time.Since(start))
+}
+
+func (p *PrometheusPool) SampledCount(key string, val float64, rate float64) {
+	if r, ok := sample(key, rate); ok {
+		p.Count(key, scale(val, r))
+	}
+}
+
+func (p *PrometheusPool) SampledDuration(key string, val time.Duration, rate float64) {
+	if _, ok := sample(key, rate); ok {
+		p.Duration(key, val)
+	}
+}
+
+// observe records val in the series for key and tags.  Stats whose
+// key is already reported as a different type are dropped.
+func (p *PrometheusPool) observe(typ, key string, val float64, tags []Tag) {
+
+	name, labels := promName(key), promLabels(tags)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f, exists := p.families[name]
+	if !exists {
+		f = &promFamily{typ: typ, series: map[string]*promSeries{}}
+		if d, ok := DefaultSchema.Lookup(key); ok {
+			f.help = d.Help
+		}
+		p.families[name] = f
+	}
+	if f.typ != typ {
+		return
+	}
+
+	s, exists := f.series[labels]
+	if !exists {
+		s = &promSeries{}
+		if typ == "histogram" {
+			s.bounds, s.buckets = p.buckets, make([]uint64, len(p.buckets))
+		}
+		f.series[labels] = s
+	}
+
+	switch typ {
+	case "counter":
+		s.value += val
+	case "gauge":
+		s.value = val
+	case "histogram":
+		s.value += val
+		s.count++
+		for i, bound := range s.bounds {
+			if val <= bound {
+				s.buckets[i]++
+			}
+		}
+	}
+
+}
+
+// ServeHTTP writes every series in the Prometheus text format
+func (p *PrometheusPool) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+
+	w.Header().Set("Content-Type", ContentTypePrometheus+"; version=0.0.4")
+	buf := bufio.NewWriter(w)
+	defer buf.Flush()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	names := make([]string, 0, len(p.families))
+	for name := range p.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := p.families[name]
+		if f.help != "" {
+			fmt.Fprintf(buf, "# HELP %s %s\n", name, promHelpEscape.Replace(f.help))
+		}
+		fmt.Fprintf(buf, "# TYPE %s %s\n", name, f.typ)
+
+		labelSets := make([]string, 0, len(f.series))
+		for labels := range f.series {
+			labelSets = append(labelSets, labels)
+		}
+		sort.Strings(labelSets)
+
+		for _, labels := range labelSets {
+			s := f.series[labels]
+			if f.typ != "histogram" {
+				fmt.Fprintf(buf, "%s%s %s\n", name, promLabelSet(labels, ""), promFloat(s.value))
+				continue
+			}
+			for i, bound := range s.bounds {
+				fmt.Fprintf(buf, "%s_bucket%s %d\n", name, promLabelSet(labels, `le="`+promFloat(bound)+`"`), s.buckets[i])
+			}
+			fmt.Fprintf(buf, "%s_bucket%s %d\n", name, promLabelSet(labels, `le="+Inf"`), s.count)
+			fmt.Fprintf(buf, "%s_sum%s %s\n", name, promLabelSet(labels, ""), promFloat(s.value))
+			fmt.Fprintf(buf, "%s_count%s %d\n", name, promLabelSet(labels, ""), s.count)
+		}
+	}
+
+}
+
+// promName replaces characters not allowed in metric names
+func promName(key string) string {
+	b := []byte(key)
+	for i, c := range b {
+		if !(c == '_' || c == ':' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || i > 0 && '0' <= c && c <= '9') {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// promLabels formats tags as sorted, escaped label pairs
+func promLabels(tags []Tag) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(tags))
+	for i, tag := range tags {
+		pairs[i] = strings.Replace(promName(tag.Key), ":", "_", -1) + `="` + promEscape.Replace(tag.Value) + `"`
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func promLabelSet(labels, extra string) string {
+	switch {
+	case labels == "" && extra == "":
+		return ""
+	case labels == "":
+		return "{" + extra + "}"
+	case extra == "":
+		return "{" + labels + "}"
+	}
+	return "{" + labels + "," + extra + "}"
+}
+
+func promFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
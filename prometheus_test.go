@@ -0,0 +1,45 @@
+package statpool
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPrometheusPool(t *testing.T) {
+
+	stats := NewPrometheusPool()
+	stats.SetBuckets(0.1, 1)
+
+	stats.Count("darts.thrown", 1)
+	stats.CountWithTags("darts.thrown", 2, T("hand", "left"))
+	stats.Count("darts.thrown", 3)
+	stats.Gauge("players", 4)
+	stats.Duration("throw", 500*time.Millisecond)
+	stats.Duration("throw", 2*time.Second)
+
+	// a key already reported as a counter isn't also a gauge
+	stats.Gauge("darts.thrown", 5)
+
+	w := httptest.NewRecorder()
+	stats.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := ioutil.ReadAll(w.Body)
+
+	expected := `# TYPE darts_thrown counter
+darts_thrown 4
+darts_thrown{hand="left"} 2
+# TYPE players gauge
+players 4
+# TYPE throw histogram
+throw_bucket{le="0.1"} 0
+throw_bucket{le="1"} 1
+throw_bucket{le="+Inf"} 2
+throw_sum 2.5
+throw_count 2
+`
+	if string(body) != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, body)
+	}
+
+}
@@ -0,0 +1,128 @@
+package statpool
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type (
+	// RecentStat is a stat captured for debugging along
+	// with where it came from and what the pool did with it
+	RecentStat struct {
+		Stat
+		Source  string `json:"source"`
+		Outcome string `json:"outcome"`
+	}
+
+	recentRing struct {
+		enabled int32
+
+		sync.Mutex
+		buf  []RecentStat
+		next int
+		full bool
+	}
+)
+
+// directory of this package's source, frames
+// from it are skipped when finding a stat's source
+var packageDir = func() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Dir(file)
+}()
+
+// SetRecentStats keeps the last n stats emitted to the pool for
+// RecentStats and DebugHandler.  Capturing records the caller of
+// each stat so it costs more than a plain emit, pass 0 to disable.
+func (p *Pool) SetRecentStats(n int) {
+	r := &p.recent
+	r.Lock()
+	if n > 0 {
+		r.buf = make([]RecentStat, n)
+	} else {
+		r.buf = nil
+	}
+	r.next, r.full = 0, false
+	r.Unlock()
+	if n > 0 {
+		atomic.StoreInt32(&r.enabled, 1)
+	} else {
+		atomic.StoreInt32(&r.enabled, 0)
+	}
+}
+
+// RecentStats returns the captured stats, oldest first
+func (p *Pool) RecentStats() []RecentStat {
+	r := &p.recent
+	r.Lock()
+	defer r.Unlock()
+	if !r.full {
+		return append([]RecentStat(nil), r.buf[:r.next]...)
+	}
+	return append(append([]RecentStat(nil), r.buf[r.next:]...), r.buf[:r.next]...)
+}
+
+// DebugHandler serves the captured stats as json.  Add ?key= to
+// only show stats whose key contains the given string.
+func (p *Pool) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		stats := p.RecentStats()
+		if key := req.URL.Query().Get("key"); key != "" {
+			filtered := stats[:0]
+			for _, stat := range stats {
+				if strings.Contains(stat.Key, key) {
+					filtered = append(filtered, stat)
+				}
+			}
+			stats = filtered
+		}
+		w.Header().Set("Content-Type", ContentTypeJSON)
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(stats)
+	})
+}
+
+func (p *Pool) record(typ StatType, key string, val float64, outcome string) {
+
+	r := &p.recent
+	if atomic.LoadInt32(&r.enabled) == 0 {
+		return
+	}
+
+	stat := RecentStat{
+		Stat:    Stat{Key: key, Type: typ, Value: val, Time: p.now()},
+		Source:  statSource(),
+		Outcome: outcome,
+	}
+
+	r.Lock()
+	if len(r.buf) > 0 {
+		r.buf[r.next] = stat
+		r.next = (r.next + 1) % len(r.buf)
+		r.full = r.full || r.next == 0
+	}
+	r.Unlock()
+
+}
+
+// statSource finds the first caller outside of this package
+func statSource() string {
+	pcs := make([]uintptr, 16)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	for {
+		frame, more := frames.Next()
+		if filepath.Dir(frame.File) != packageDir || strings.HasSuffix(frame.File, "_test.go") {
+			return frame.File + ":" + strconv.Itoa(frame.Line)
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
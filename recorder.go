@@ -0,0 +1,187 @@
+package statpool
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// RecorderPool keeps every stat in memory for tests to inspect.
+// Tagged stats are recorded under their key flattened with the
+// default tag separator, e.g. requests.code=200.
+//
+//	stats := statpool.NewRecorderPool()
+//	handleRequest(stats)
+//	if stats.CountFor("requests") != 1 {
+//		t.Error("expected a request to be counted")
+//	}
+type RecorderPool struct {
+	counts    map[string]float64
+	values    map[string][]float64
+	durations map[string][]time.Duration
+	uniques   map[string]map[string]bool
+	mu        sync.Mutex
+}
+
+func NewRecorderPool() *RecorderPool {
+	r := &RecorderPool{}
+	r.Reset()
+	return r
+}
+
+func (r *RecorderPool) Count(key string, val float64) {
+	r.mu.Lock()
+	r.counts[key] += val
+	r.mu.Unlock()
+}
+
+func (r *RecorderPool) Inc(key string) {
+	r.Count(key, 1)
+}
+
+func (r *RecorderPool) Dec(key string) {
+	r.Count(key, -1)
+}
+
+func (r *RecorderPool) Add(key string, delta float64) {
+	r.Count(key, delta)
+}
+
+func (r *RecorderPool) Value(key string, val float64, _ time.Time) {
+	r.mu.Lock()
+	r.values[key] = append(r.values[key], val)
+	r.mu.Unlock()
+}
+
+func (r *RecorderPool) Duration(key string, val time.Duration) {
+	r.mu.Lock()
+	r.durations[key] = append(r.durations[key], val)
+	r.mu.Unlock()
+}
+
+func (r *RecorderPool) Gauge(key string, val float64) {
+	r.Value(key, val, time.Now())
+}
+
+func (r *RecorderPool) Histogram(key string, val float64) {
+	r.Value(key, val, time.Now())
+}
+
+func (r *RecorderPool) CountWithTags(key string, val float64, tags ...Tag) {
+	r.Count(flattenTags(key, tags, DefaultTagSeparator), val)
+}
+
+func (r *RecorderPool) ValueWithTags(key string, val float64, timestamp time.Time, tags ...Tag) {
+	r.Value(flattenTags(key, tags, DefaultTagSeparator), val, timestamp)
+}
+
+func (r *RecorderPool) GaugeWithTags(key string, val float64, tags ...Tag) {
+	r.Gauge(flattenTags(key, tags, DefaultTagSeparator), val)
+}
+
+func (r *RecorderPool) DurationWithTags(key string, val time.Duration, tags ...Tag) {
+	r.Duration(flattenTags(key, tags, DefaultTagSeparator), val)
+}
+
+func (r *RecorderPool) Unique(key string, member string) {
+	r.mu.Lock()
+	if r.uniques[key] == nil {
+		r.uniques[key] = map[string]bool{}
+	}
+	r.uniques[key][member] = true
+	r.mu.Unlock()
+}
+
+func (r *RecorderPool) DurationSince(key string, start time.Time) {
+	r.Duration(key, time.Since(start))
+}
+
+func (r *RecorderPool) TimeCaller(start time.Time) {
+	r.Duration(callerKey(1), time.Since(start))
+}
+
+func (r *RecorderPool) Timer(key string) *Timer {
+	return NewTimer(r, key)
+}
+
+func (r *RecorderPool) Time(key string) func() {
+	t := r.Timer(key)
+	return func() { t.Stop() }
+}
+
+// SampledCount records every count unscaled so tests are deterministic
+func (r *RecorderPool) SampledCount(key string, val float64, _ float64) {
+	r.Count(key, val)
+}
+
+// SampledDuration records every duration so tests are deterministic
+func (r *RecorderPool) SampledDuration(key string, val time.Duration, _ float64) {
+	r.Duration(key, val)
+}
+
+// UniqueFor returns the exact number of distinct members of key
+func (r *RecorderPool) UniqueFor(key string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.uniques[key])
+}
+
+// CountFor returns the total counted for key
+func (r *RecorderPool) CountFor(key string) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.counts[key]
+}
+
+// ValuesFor returns the values recorded for key in order, including
+// gauges and histogram observations
+func (r *RecorderPool) ValuesFor(key string) []float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]float64(nil), r.values[key]...)
+}
+
+// DurationsFor returns the durations recorded for key in order
+func (r *RecorderPool) DurationsFor(key string) []time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]time.Duration(nil), r.durations[key]...)
+}
+
+// Keys returns every key recorded
+func (r *RecorderPool) Keys() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	seen := map[string]bool{}
+	var keys []string
+	add := func(key string) {
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	for key := range r.counts {
+		add(key)
+	}
+	for key := range r.values {
+		add(key)
+	}
+	for key := range r.durations {
+		add(key)
+	}
+	for key := range r.uniques {
+		add(key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Reset forgets everything recorded
+func (r *RecorderPool) Reset() {
+	r.mu.Lock()
+	r.counts = map[string]float64{}
+	r.values = map[string][]float64{}
+	r.durations = map[string][]time.Duration{}
+	r.uniques = map[string]map[string]bool{}
+	r.mu.Unlock()
+}
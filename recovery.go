@@ -0,0 +1,143 @@
+package statpool
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// recoveredBatch is a line of the recovery file
+type recoveredBatch struct {
+	ID      string          `json:"id"`
+	Created int64           `json:"created"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// SetRecoveryFile saves the stats the pool couldn't send when it
+// stops to path, including any spilled over the memory limit, so a
+// deploy during an outage doesn't lose the last interval.  Stats
+// saved there by a previous run are resent as the pool starts, and
+// the file is removed once they're sent or saved again.  It must be
+// called before the pool is used, and an empty path saves nothing.
+func (p *Pool) SetRecoveryFile(path string) error {
+
+	p.recoveryFile = path
+	if path == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var (
+		batches []*batch
+		invalid int
+		lines   = bufio.NewScanner(bytes.NewReader(data))
+	)
+	lines.Buffer(nil, len(data)+1)
+	for lines.Scan() {
+		b, err := decodeRecovered(lines.Bytes())
+		if err != nil {
+			invalid++
+			continue
+		}
+		batches = append(batches, b)
+	}
+	p.recoveredMu.Lock()
+	p.recovered = make(map[string]bool, len(batches))
+	for _, b := range batches {
+		p.recovered[b.id] = true
+	}
+	p.recoveredMu.Unlock()
+
+	p.hold(batches...)
+	p.resendWaiting()
+	if invalid > 0 {
+		return fmt.Errorf("skipped %d invalid lines of recovery file %s", invalid, path)
+	}
+	return nil
+
+}
+
+func decodeRecovered(line []byte) (*batch, error) {
+	var rb recoveredBatch
+	if err := json.Unmarshal(line, &rb); err != nil {
+		return nil, err
+	}
+	ezKey, stats, err := DecodePayload(ContentTypeJSON, bytes.NewReader(rb.Payload))
+	if err != nil {
+		return nil, err
+	}
+	return &batch{id: rb.ID, ezKey: ezKey, stats: stats, created: time.Unix(0, rb.Created)}, nil
+}
+
+// recoveredSent removes the recovery file once the last batch loaded
+// from it is sent
+func (p *Pool) recoveredSent(id string) {
+	p.recoveredMu.Lock()
+	defer p.recoveredMu.Unlock()
+	if !p.recovered[id] {
+		return
+	}
+	delete(p.recovered, id)
+	if len(p.recovered) == 0 {
+		if err := os.Remove(p.recoveryFile); err != nil && !os.IsNotExist(err) {
+			p.report(fmt.Errorf("recovery file not removed: %w", err), 0)
+		}
+	}
+}
+
+// clearRecovered removes the recovery file when nothing is left to
+// save in it at stop
+func (p *Pool) clearRecovered() {
+	p.recoveredMu.Lock()
+	defer p.recoveredMu.Unlock()
+	if len(p.recovered) == 0 {
+		return
+	}
+	p.recovered = nil
+	if err := os.Remove(p.recoveryFile); err != nil && !os.IsNotExist(err) {
+		p.report(fmt.Errorf("recovery file not removed: %w", err), 0)
+	}
+}
+
+// saveRecovery replaces the recovery file with batches, including any
+// loaded from it and not yet sent
+func (p *Pool) saveRecovery(batches []*batch) error {
+
+	buf := &bytes.Buffer{}
+	for _, b := range batches {
+		payload := &bytes.Buffer{}
+		if _, err := encodePayload(payload, p.ezKeyOr(b.ezKey), b.stats, false); err != nil {
+			return err
+		}
+		line, err := json.Marshal(recoveredBatch{ID: b.id, Created: b.created.UnixNano(), Payload: payload.Bytes()})
+		if err != nil {
+			return err
+		}
+		buf.Write(append(line, '\n'))
+	}
+
+	p.recoveredMu.Lock()
+	defer p.recoveredMu.Unlock()
+
+	// write then rename so a partial file is never loaded
+	if err := ioutil.WriteFile(p.recoveryFile+".tmp", buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(p.recoveryFile+".tmp", p.recoveryFile); err != nil {
+		return err
+	}
+	p.recovered = nil
+	return nil
+
+}
@@ -0,0 +1,133 @@
+package statpool
+
+import (
+	"regexp"
+	"strings"
+)
+
+type (
+	// RelabelRule renames or drops the stats whose keys match it at
+	// flush, so keys can be migrated without changing call sites.
+	// Keys are matched as reported, including any prefix.
+	RelabelRule struct {
+		Match   MatchType
+		Pattern string
+
+		// the new key, replacing the whole key for exact matches,
+		// the matched prefix for prefix matches, and each match of
+		// the pattern for regexes, where $1 expands to the first
+		// submatch
+		Replacement string
+
+		// drop matching stats instead of renaming them
+		Drop bool
+	}
+
+	MatchType int
+
+	relabelRule struct {
+		RelabelRule
+		re *regexp.Regexp
+	}
+)
+
+const (
+	ExactMatch  MatchType = iota // the key is the pattern
+	PrefixMatch                  // the key starts with the pattern
+	RegexMatch                   // the key matches the regular expression
+)
+
+// SetRelabelRules applies rules to the keys of each flush, the first
+// rule matching a key deciding its fate.  Counts renamed to the same
+// key are summed.  It returns an error and leaves the rules as they
+// were if a regex doesn't compile.
+func (p *Pool) SetRelabelRules(rules ...RelabelRule) error {
+	compiled := make([]relabelRule, len(rules))
+	for i, r := range rules {
+		compiled[i].RelabelRule = r
+		if r.Match == RegexMatch {
+			re, err := regexp.Compile(r.Pattern)
+			if err != nil {
+				return err
+			}
+			compiled[i].re = re
+		}
+	}
+	p.relabels.Store(compiled)
+	return nil
+}
+
+// relabel returns the key as renamed by the rule, whether the stat
+// is kept, and whether the rule matched
+func (r relabelRule) relabel(key string) (string, bool, bool) {
+	switch r.Match {
+	case ExactMatch:
+		if key != r.Pattern {
+			return key, true, false
+		}
+		return r.Replacement, !r.Drop, true
+	case PrefixMatch:
+		if !strings.HasPrefix(key, r.Pattern) {
+			return key, true, false
+		}
+		return r.Replacement + key[len(r.Pattern):], !r.Drop, true
+	case RegexMatch:
+		if !r.re.MatchString(key) {
+			return key, true, false
+		}
+		return r.re.ReplaceAllString(key, r.Replacement), !r.Drop, true
+	}
+	return key, true, false
+}
+
+// relabel applies the rules to a flush's stats, merging counts
+// renamed to the same key
+func (p *Pool) relabel(values []interface{}) []interface{} {
+
+	rules, _ := p.relabels.Load().([]relabelRule)
+	if len(rules) == 0 {
+		return values
+	}
+
+	var (
+		kept   = values[:0]
+		counts = map[string]*CountStat{}
+	)
+	for _, v := range values {
+
+		var key *string
+		switch stat := v.(type) {
+		case *CountStat:
+			key = &stat.Key
+		case *ValueStat:
+			key = &stat.Key
+		default:
+			kept = append(kept, v)
+			continue
+		}
+
+		keep := true
+		for _, r := range rules {
+			var matched bool
+			if *key, keep, matched = r.relabel(*key); matched {
+				break
+			}
+		}
+		if !keep || *key == "" {
+			continue
+		}
+
+		if count, ok := v.(*CountStat); ok {
+			k := aggKey(count.ezKey, count.Key)
+			if merged, exists := counts[k]; exists {
+				merged.Count += count.Count
+				continue
+			}
+			counts[k] = count
+		}
+		kept = append(kept, v)
+
+	}
+	return kept
+
+}
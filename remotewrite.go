@@ -0,0 +1,183 @@
+package statpool
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RemoteWriteSender pushes stats to a Prometheus remote-write
+// endpoint, such as Mimir, Thanos receive or VictoriaMetrics, as a
+// snappy encoded protobuf WriteRequest.  Each stat is a series
+// named by its sanitized key, labelled with its tags, holding a
+// single sample.  Counts are the interval's count, not a running
+// total, unless the key is Cumulative.
+//
+// Responses of 5xx are retried by the pool, other failures aren't.
+type RemoteWriteSender struct {
+	url      string
+	client   *http.Client
+	username string
+	password string
+	tagsep   string
+}
+
+func NewRemoteWriteSender(url string) *RemoteWriteSender {
+	return &RemoteWriteSender{
+		url:    url,
+		client: &http.Client{Timeout: DefaultRequestTimeout},
+		tagsep: DefaultTagSeparator,
+	}
+}
+
+// SetBasicAuth sends the username and password with each request
+func (r *RemoteWriteSender) SetBasicAuth(username, password string) {
+	r.username, r.password = username, password
+}
+
+// SetTagSeparator sets the separator the pool flattens tags into keys
+// with, so they can be trimmed from the metric name
+func (r *RemoteWriteSender) SetTagSeparator(sep string) {
+	r.tagsep = sep
+}
+
+func (r *RemoteWriteSender) Send(ctx context.Context, stats []interface{}) error {
+
+	body := snappyEncode(r.writeRequest(stats, time.Now()))
+	req, err := http.NewRequestWithContext(ctx, "POST", r.url, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if r.username != "" || r.password != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return &TransportError{err}
+	}
+	defer resp.Body.Close()
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+
+	switch {
+	case resp.StatusCode/100 == 2:
+		return nil
+	case resp.StatusCode >= 500:
+		return fmt.Errorf("remote write: %w: %s", &HTTPStatusError{resp.StatusCode}, msg)
+	}
+	return permanentError{fmt.Errorf("remote write: %w: %s", &HTTPStatusError{resp.StatusCode}, msg)}
+
+}
+
+// writeRequest encodes the stats as a prometheus.WriteRequest
+func (r *RemoteWriteSender) writeRequest(stats []interface{}, now time.Time) []byte {
+
+	var req, series, buf []byte
+	for _, stat := range stats {
+		var (
+			key  string
+			val  float64
+			ts   int64
+			tags []Tag
+		)
+		switch s := stat.(type) {
+		case *CountStat:
+			key, val, ts, tags = s.Key, s.Count, s.Timestamp, s.Tags
+		case *ValueStat:
+			key, val, ts, tags = s.Key, s.Value, s.Timestamp, s.Tags
+		default:
+			continue
+		}
+		millis := now.UnixNano() / int64(time.Millisecond)
+		if ts != 0 {
+			millis = ts * 1000
+		}
+
+		series = series[:0]
+		name := promName(strings.TrimSuffix(key, flattenTags("", tags, r.tagsep)))
+		for _, l := range remoteWriteLabels(name, tags) {
+			buf = protoString(buf[:0], 1, l.Key)
+			buf = protoString(buf, 2, l.Value)
+			series = protoBytes(series, 1, buf)
+		}
+		buf = protoTag(buf[:0], 1, 1)
+		buf = appendFixed64(buf, math.Float64bits(val))
+		buf = protoTag(buf, 2, 0)
+		buf = appendUvarint(buf, uint64(millis))
+		series = protoBytes(series, 2, buf)
+
+		req = protoBytes(req, 1, series)
+	}
+	return req
+
+}
+
+// remoteWriteLabels returns the series' labels sorted by name, as
+// remote-write requires
+func remoteWriteLabels(name string, tags []Tag) []Tag {
+	labels := []Tag{{Key: "__name__", Value: name}}
+	for _, t := range tags {
+		labels = append(labels, Tag{Key: strings.Replace(promName(t.Key), ":", "_", -1), Value: t.Value})
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Key < labels[j].Key })
+	return labels
+}
+
+func protoTag(b []byte, field, wireType int) []byte {
+	return appendUvarint(b, uint64(field<<3|wireType))
+}
+
+func protoBytes(b []byte, field int, v []byte) []byte {
+	b = protoTag(b, field, 2)
+	b = appendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func protoString(b []byte, field int, v string) []byte {
+	b = protoTag(b, field, 2)
+	b = appendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	return append(b, tmp[:binary.PutUvarint(tmp[:], v)]...)
+}
+
+func appendFixed64(b []byte, v uint64) []byte {
+	var tmp [8]byte
+	binary.LittleEndian.PutUint64(tmp[:], v)
+	return append(b, tmp[:]...)
+}
+
+// snappyEncode frames src as a snappy block of literals.  It isn't
+// compressed, but any snappy decoder reads it.
+func snappyEncode(src []byte) []byte {
+	dst := appendUvarint(nil, uint64(len(src)))
+	for len(src) > 0 {
+		n := len(src)
+		if n > 1<<16 {
+			n = 1 << 16
+		}
+		if n <= 60 {
+			dst = append(dst, byte(n-1)<<2)
+		} else {
+			// the length follows in two bytes
+			dst = append(dst, 61<<2, byte(n-1), byte((n-1)>>8))
+		}
+		dst = append(dst, src[:n]...)
+		src = src[n:]
+	}
+	return dst
+}
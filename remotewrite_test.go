@@ -0,0 +1,115 @@
+package statpool
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// snappyDecode reads a snappy block of literals
+func snappyDecode(t *testing.T, src []byte) []byte {
+	n, i := binary.Uvarint(src)
+	src = src[i:]
+	var dst []byte
+	for len(src) > 0 {
+		tag := src[0]
+		if tag&3 != 0 {
+			t.Fatalf("Unexpected snappy tag: %x", tag)
+		}
+		l := int(tag>>2) + 1
+		src = src[1:]
+		if tag>>2 == 61 {
+			l = int(src[0]) | int(src[1])<<8 + 1
+			src = src[2:]
+		}
+		dst = append(dst, src[:l]...)
+		src = src[l:]
+	}
+	if uint64(len(dst)) != n {
+		t.Fatalf("Expected: %d bytes, got: %d", n, len(dst))
+	}
+	return dst
+}
+
+// protoFields splits an encoded message into its length delimited
+// and fixed64 fields, keeping varints as their values
+func protoFields(t *testing.T, b []byte) map[int][][]byte {
+	fields := map[int][][]byte{}
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		b = b[n:]
+		field := int(key >> 3)
+		switch key & 7 {
+		case 0:
+			_, n := binary.Uvarint(b)
+			fields[field] = append(fields[field], b[:n])
+			b = b[n:]
+		case 1:
+			fields[field] = append(fields[field], b[:8])
+			b = b[8:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			fields[field] = append(fields[field], b[n:n+int(l)])
+			b = b[n+int(l):]
+		default:
+			t.Fatalf("Unexpected wire type: %d", key&7)
+		}
+	}
+	return fields
+}
+
+func TestRemoteWriteSender(t *testing.T) {
+
+	bodies := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "darts" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("Content-Encoding") != "snappy" {
+			t.Errorf("Expected snappy encoding, got: %q", r.Header.Get("Content-Encoding"))
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies <- body
+	}))
+	defer server.Close()
+
+	sender := NewRemoteWriteSender(server.URL)
+	sender.SetBasicAuth("darts", "secret")
+	stats := NewPoolWithOptions(WithFlushInterval(time.Hour), WithSender(sender))
+	stats.CountWithTags("throws", 3, T("hand", "left"))
+	if err := stats.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := protoFields(t, snappyDecode(t, <-bodies))
+	if len(req[1]) != 1 {
+		t.Fatalf("Expected: 1 series, got: %d", len(req[1]))
+	}
+	series := protoFields(t, req[1][0])
+	labels := map[string]string{}
+	for _, l := range series[1] {
+		f := protoFields(t, l)
+		labels[string(f[1][0])] = string(f[2][0])
+	}
+	if labels["__name__"] != "throws" || labels["hand"] != "left" || len(labels) != 2 {
+		t.Errorf("Unexpected labels: %v", labels)
+	}
+	sample := protoFields(t, series[2][0])
+	if val := math.Float64frombits(binary.LittleEndian.Uint64(sample[1][0])); val != 3 {
+		t.Errorf("Expected: 3, got: %g", val)
+	}
+
+	// unauthorized isn't retried
+	sender.SetBasicAuth("darts", "wrong")
+	stats.Count("throws", 1)
+	if err := stats.Flush(); err == nil {
+		t.Error("Expected an error for the unauthorized flush")
+	}
+	stats.Stop()
+
+}
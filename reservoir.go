@@ -0,0 +1,106 @@
+package statpool
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+)
+
+// reservoir keeps a uniform random sample of at most
+// size values seen during an interval along with
+// exact count, sum, min and max over all of them
+type reservoir struct {
+	size    int
+	seen    int
+	samples []*ValueStat
+
+	sum, min, max float64
+
+	// report a summary rather than the samples
+	summarize bool
+	quantiles []float64
+}
+
+var (
+	defaultSummarySamples     = 1024
+	defaultSummaryQuantiles   = []float64{0.5, 0.9, 0.99}
+	defaultHistogramQuantiles = []float64{0.5, 0.95, 0.99}
+)
+
+func newReservoir(size int) *reservoir {
+	return &reservoir{
+		size:    size,
+		samples: make([]*ValueStat, 0, size),
+		min:     math.Inf(1),
+		max:     math.Inf(-1),
+	}
+}
+
+// newKeyReservoir creates the reservoir for a key's options,
+// histograms are always summarized
+func newKeyReservoir(opts KeyOptions, histogram bool) *reservoir {
+	size := opts.samples()
+	if size <= 0 {
+		size = defaultSummarySamples
+	}
+	r := newReservoir(size)
+	r.summarize = opts.Summarize || histogram
+	r.quantiles = opts.Quantiles
+	if len(r.quantiles) == 0 {
+		r.quantiles = defaultSummaryQuantiles
+		if histogram {
+			r.quantiles = defaultHistogramQuantiles
+		}
+	}
+	return r
+}
+
+func (r *reservoir) add(v *ValueStat) {
+	r.seen++
+	r.sum += v.Value
+	r.min = math.Min(r.min, v.Value)
+	r.max = math.Max(r.max, v.Value)
+	if len(r.samples) < r.size {
+		r.samples = append(r.samples, v)
+		return
+	}
+	if i := rand.Intn(r.seen); i < r.size {
+		r.samples[i] = v
+	}
+}
+
+// summary reports the reservoir as derived stats
+// (key.count, key.sum, key.min, key.max, key.p50, ...)
+func (r *reservoir) summary(key string, now int64) []interface{} {
+	if r.seen == 0 {
+		return nil
+	}
+
+	sorted := make([]float64, len(r.samples))
+	for i, v := range r.samples {
+		sorted[i] = v.Value
+	}
+	sort.Float64s(sorted)
+
+	stat := func(suffix string, val float64) interface{} {
+		return &ValueStat{Key: key + "." + suffix, Value: val, Timestamp: now}
+	}
+
+	stats := []interface{}{
+		stat("count", float64(r.seen)),
+		stat("sum", r.sum),
+		stat("min", r.min),
+		stat("max", r.max),
+	}
+	for _, q := range r.quantiles {
+		i := int(math.Ceil(q*float64(len(sorted)))) - 1
+		if i < 0 {
+			i = 0
+		} else if i >= len(sorted) {
+			i = len(sorted) - 1
+		}
+		stats = append(stats, stat("p"+strconv.FormatFloat(q*100, 'f', -1, 64), sorted[i]))
+	}
+	return stats
+}
@@ -0,0 +1,119 @@
+package statpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// RetryPolicy controls how a batch that failed to send is retried.
+// Payloads rejected by an http endpoint aren't retried, only failures
+// from unreachable endpoints and 5xx responses.  Batches still failing
+// once the retries are exhausted are re-queued for the next flush,
+// which only resends the batches that failed.
+type RetryPolicy struct {
+	// total attempts made for a batch per flush, zero or one for
+	// no retries
+	MaxAttempts int
+
+	// delay before the first retry, doubled for each retry after
+	// and capped at MaxDelay when set
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+
+	// fraction of each delay randomized away, from 0 to 1
+	Jitter float64
+
+	// give up retrying once this much time has passed since the
+	// first attempt, zero for no limit
+	MaxElapsed time.Duration
+}
+
+// SetRetryPolicy sets how failed sends are retried.  The zero policy,
+// the default, makes one attempt per flush.
+func (p *Pool) SetRetryPolicy(policy RetryPolicy) {
+	p.retry.Store(policy)
+}
+
+func (p *Pool) retryPolicy() RetryPolicy {
+	policy, _ := p.retry.Load().(RetryPolicy)
+	return policy
+}
+
+// backoff is the delay before the given retry, starting from 1
+func (r RetryPolicy) backoff(retry int) time.Duration {
+	d := r.BaseDelay
+	for i := 1; i < retry && (r.MaxDelay <= 0 || d < r.MaxDelay); i++ {
+		d *= 2
+	}
+	if r.MaxDelay > 0 && d > r.MaxDelay {
+		d = r.MaxDelay
+	}
+	if r.Jitter > 0 {
+		d -= time.Duration(r.Jitter * rand.Float64() * float64(d))
+	}
+	return d
+}
+
+// postWithRetry posts the batch, retrying transient failures with
+// exponential backoff.  A batch that can't be sent is spooled if
+// there is a spool, and otherwise re-queued for the next flush.
+func (p *Pool) postWithRetry(ctx context.Context, b *batch) error {
+
+	var (
+		policy = p.retryPolicy()
+		start  = time.Now()
+	)
+
+	for attempt := 1; ; attempt++ {
+
+		if attempt > 1 {
+			atomic.AddInt64(&p.counters.retries, 1)
+		}
+
+		err := p.sender.Send(withEZKey(withIdempotencyKey(ctx, b.id), b.ezKey), b.stats)
+		if err == nil {
+			return nil
+		}
+
+		// resent once the endpoint allows
+		if errors.Is(err, errThrottled) {
+			p.hold(b)
+			p.report(err, 0)
+			return err
+		}
+
+		if errors.As(err, &permanentError{}) {
+			p.report(err, len(b.stats))
+			p.logUnprocessed(b)
+			return err
+		}
+
+		delay := policy.backoff(attempt)
+		if attempt >= policy.MaxAttempts || (policy.MaxElapsed > 0 && time.Since(start)+delay > policy.MaxElapsed) {
+			p.unsent(err, b, attempt)
+			return err
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			p.unsent(err, b, attempt)
+			return err
+		}
+	}
+
+}
+
+// unsent spools or re-queues a batch that couldn't be sent
+func (p *Pool) unsent(err error, b *batch, attempts int) {
+	if p.spoolBatch(b) {
+		p.report(err, 0)
+		return
+	}
+	p.hold(b)
+	p.report(fmt.Errorf("send failed after %d attempts, re-queued %d stats: %w", attempts, len(b.stats), err), 0)
+}
@@ -0,0 +1,58 @@
+package statpool
+
+import (
+	"runtime"
+	"time"
+)
+
+// RuntimeCollector reports Go runtime stats to s every interval until
+// the returned stop function is called:
+//
+//	runtime.goroutines      number of goroutines
+//	runtime.heap_inuse      bytes in in-use heap spans
+//	runtime.gc_pause        time paused for gc during the interval
+//	runtime.gc_cycles       count of gc cycles completed
+//
+// Goroutines and heap in-use are reported as gauges when s has them.
+func RuntimeCollector(s Stater, interval time.Duration) (stop func()) {
+
+	var (
+		done = make(chan struct{})
+		last runtime.MemStats
+	)
+	runtime.ReadMemStats(&last)
+
+	go func() {
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				var m runtime.MemStats
+				runtime.ReadMemStats(&m)
+				reportRuntime(s, &m, &last)
+				last = m
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+func reportRuntime(s Stater, m, last *runtime.MemStats) {
+	gauge := func(key string, val float64) {
+		if g, ok := s.(gauger); ok {
+			g.Gauge(key, val)
+		} else {
+			s.Value(key, val, time.Now())
+		}
+	}
+	gauge("runtime.goroutines", float64(runtime.NumGoroutine()))
+	gauge("runtime.heap_inuse", float64(m.HeapInuse))
+	if cycles := m.NumGC - last.NumGC; cycles > 0 {
+		s.Duration("runtime.gc_pause", time.Duration(m.PauseTotalNs-last.PauseTotalNs))
+		s.Count("runtime.gc_cycles", float64(cycles))
+	}
+}
@@ -0,0 +1,117 @@
+package statpool
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type (
+	// Sampler decides which calls to the Sampled methods are recorded
+	Sampler interface {
+		// Sample reports whether a call for key made at rate is kept,
+		// and the rate the kept stat stands for.  Counts are scaled
+		// up by the inverse of that rate.
+		Sample(key string, rate float64) (float64, bool)
+	}
+
+	SamplerFunc func(key string, rate float64) (float64, bool)
+
+	// ProbabilitySampler keeps calls at random with the probability
+	// it is set to, or with the rate passed to the call when zero
+	ProbabilitySampler float64
+
+	rateLimitSampler struct {
+		n      int
+		per    time.Duration
+		window time.Time
+		seen   map[string]int
+		mu     sync.Mutex
+	}
+)
+
+var (
+	AlwaysSample Sampler = SamplerFunc(func(string, float64) (float64, bool) { return 1, true })
+	NeverSample  Sampler = SamplerFunc(func(string, float64) (float64, bool) { return 0, false })
+)
+
+func (fn SamplerFunc) Sample(key string, rate float64) (float64, bool) {
+	return fn(key, rate)
+}
+
+func (s ProbabilitySampler) Sample(_ string, rate float64) (float64, bool) {
+	if s != 0 {
+		rate = float64(s)
+	}
+	switch {
+	case rate >= 1:
+		return 1, true
+	case rate <= 0:
+		return 0, false
+	}
+	return rate, rand.Float64() < rate
+}
+
+// RateLimitSampler keeps the first n calls for each key every per.
+// Kept counts aren't scaled since the calls dropped aren't known.
+func RateLimitSampler(n int, per time.Duration) Sampler {
+	return &rateLimitSampler{n: n, per: per, seen: map[string]int{}}
+}
+
+func (s *rateLimitSampler) Sample(key string, _ float64) (float64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now := time.Now(); now.Sub(s.window) >= s.per {
+		s.window = now
+		s.seen = map[string]int{}
+	}
+	s.seen[key]++
+	return 1, s.seen[key] <= s.n
+}
+
+// SetSampler sets the sampler used by the Sampled methods for keys
+// that don't set one in their KeyOptions.  By default calls are kept
+// with the probability passed to them.
+func (p *Pool) SetSampler(s Sampler) {
+	p.sampler.Store(&s)
+}
+
+// SetSampleRates sets the rates the Sampled methods use for keys,
+// as reported including the prefix, in place of the rates passed
+// to them
+func (p *Pool) SetSampleRates(rates map[string]float64) {
+	copied := make(map[string]float64, len(rates))
+	for key, rate := range rates {
+		copied[key] = rate
+	}
+	p.sampleRates.Store(copied)
+}
+
+// sample applies the key's sampler, or the pool's, to a call
+func (p *Pool) sample(key string, rate float64) (float64, bool) {
+	if rates, _ := p.sampleRates.Load().(map[string]float64); rates != nil {
+		if r, exists := rates[key]; exists {
+			rate = r
+		}
+	}
+	if s := p.keyOptions(key).Sampler; s != nil {
+		return s.Sample(key, rate)
+	}
+	if s, _ := p.sampler.Load().(*Sampler); s != nil && *s != nil {
+		return (*s).Sample(key, rate)
+	}
+	return sample(key, rate)
+}
+
+// sample applies the default sampler for staters without one
+func sample(key string, rate float64) (float64, bool) {
+	return ProbabilitySampler(0).Sample(key, rate)
+}
+
+// scale compensates a kept count for the calls sampled out
+func scale(val, rate float64) float64 {
+	if rate > 0 && rate < 1 {
+		return val / rate
+	}
+	return val
+}
@@ -0,0 +1,161 @@
+package statpool
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultMaxKeyLength is the longest key a KeySanitizer allows unless
+// set, the most StatHat accepts
+const DefaultMaxKeyLength = 255
+
+// KeySanitizer cleans up keys as stats are accepted: characters
+// StatHat rejects, control characters and invalid utf-8, are
+// replaced, runs of whitespace are collapsed to a single space and
+// trimmed, and long keys are truncated.
+type KeySanitizer struct {
+	// longer keys are truncated and end in a hash of the whole key
+	// so they stay distinct, zero for DefaultMaxKeyLength
+	MaxLength int
+
+	// replaces rejected characters, "_" when empty
+	Replacement string
+
+	// lowercase keys so differently cased call sites report
+	// together
+	Lowercase bool
+}
+
+type keySanitizing struct {
+	s         KeySanitizer
+	onInvalid func(key, sanitized string)
+}
+
+// SetKeySanitizer cleans up the keys of stats sent to the pool with
+// s, the zero KeySanitizer using the defaults.  Keys are accepted as
+// they are unless a sanitizer or OnInvalidKey is set.
+func (p *Pool) SetKeySanitizer(s KeySanitizer) {
+	old, _ := p.sanitize.Load().(*keySanitizing)
+	k := &keySanitizing{s: s}
+	if old != nil {
+		k.onInvalid = old.onInvalid
+	}
+	p.sanitize.Store(k)
+}
+
+// OnInvalidKey calls fn with each key the sanitizer changes and what
+// it was changed to, empty if the stat was dropped, to find the call
+// sites passing bad keys.  It sanitizes with the defaults if no
+// sanitizer is set.  fn is called on the goroutine sending the stat.
+func (p *Pool) OnInvalidKey(fn func(key, sanitized string)) {
+	old, _ := p.sanitize.Load().(*keySanitizing)
+	k := &keySanitizing{onInvalid: fn}
+	if old != nil {
+		k.s = old.s
+	}
+	p.sanitize.Store(k)
+}
+
+// sanitized cleans up key if there's a sanitizer, and reports whether
+// anything is left of it
+func (p *Pool) sanitized(key *string) bool {
+	k, _ := p.sanitize.Load().(*keySanitizing)
+	if k == nil {
+		return true
+	}
+	clean := k.s.Sanitize(*key)
+	if clean != *key && k.onInvalid != nil {
+		k.onInvalid(*key, clean)
+	}
+	*key = clean
+	return clean != ""
+}
+
+// Sanitize returns key cleaned up, empty if nothing is left of it
+func (s KeySanitizer) Sanitize(key string) string {
+
+	max := s.MaxLength
+	if max <= 0 {
+		max = DefaultMaxKeyLength
+	}
+	if s.clean(key, max) {
+		return key
+	}
+
+	replacement := s.Replacement
+	if replacement == "" {
+		replacement = "_"
+	}
+
+	var (
+		b     strings.Builder
+		space bool
+	)
+	for i, r := range key {
+		if unicode.IsSpace(r) {
+			space = b.Len() > 0
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		switch {
+		case r == utf8.RuneError && !strings.HasPrefix(key[i:], string(utf8.RuneError)):
+			b.WriteString(replacement)
+		case !unicode.IsPrint(r):
+			b.WriteString(replacement)
+		case s.Lowercase:
+			b.WriteRune(unicode.ToLower(r))
+		default:
+			b.WriteRune(r)
+		}
+	}
+	clean := b.String()
+
+	if len(clean) > max {
+		// cut on a rune boundary, leaving room for the hash
+		h := fnv.New32a()
+		h.Write([]byte(clean))
+		suffix := fmt.Sprintf(".%08x", h.Sum32())
+		cut := max - len(suffix)
+		if cut < 0 {
+			return suffix[len(suffix)-max:]
+		}
+		for cut > 0 && !utf8.RuneStart(clean[cut]) {
+			cut--
+		}
+		clean = clean[:cut] + suffix
+	}
+	return clean
+
+}
+
+// clean reports whether key needs no changes, without allocating
+func (s KeySanitizer) clean(key string, max int) bool {
+	if key == "" || len(key) > max {
+		return key == ""
+	}
+	space := false
+	for i, r := range key {
+		switch {
+		case r == utf8.RuneError && !strings.HasPrefix(key[i:], string(utf8.RuneError)):
+			return false
+		case r == ' ':
+			if space || i == 0 {
+				return false
+			}
+			space = true
+			continue
+		case unicode.IsSpace(r), !unicode.IsPrint(r):
+			return false
+		case s.Lowercase && unicode.ToLower(r) != r:
+			return false
+		}
+		space = false
+	}
+	return !space
+}
@@ -0,0 +1,206 @@
+package statpool
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Scheduler flushes many pools from a single timer and bounds the
+// number of requests they send at once.  Its pools don't start
+// goroutines of their own, a single goroutine aggregates their stats
+// and starts their flushes.  Pools created by a Scheduler share its
+// http client so connections are reused between them.
+type Scheduler struct {
+	client *http.Client
+	tick   *time.Ticker
+	stop   chan struct{}
+
+	// pools with queued stats, and flush and stop requests
+	wake     chan *Pool
+	requests chan scheduledRequest
+
+	// limits concurrent sends across all pools
+	workers chan struct{}
+
+	mu    sync.Mutex
+	pools map[*Pool]*scheduled
+}
+
+type (
+	// scheduled is a running pool's state between ticks
+	scheduled struct {
+		idle int
+		done chan struct{}
+	}
+
+	scheduledRequest struct {
+		p    *Pool
+		stop bool
+		req  flushRequest
+	}
+)
+
+// wakeBuffer is how many pools can wait for the scheduler to take
+// their queued stats, the rest are taken on the next tick
+const wakeBuffer = 1024
+
+func NewScheduler(flushInterval time.Duration, workers int) *Scheduler {
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	s := &Scheduler{
+		client:   &http.Client{},
+		tick:     time.NewTicker(flushInterval),
+		stop:     make(chan struct{}),
+		wake:     make(chan *Pool, wakeBuffer),
+		requests: make(chan scheduledRequest),
+		workers:  make(chan struct{}, workers),
+		pools:    map[*Pool]*scheduled{},
+	}
+
+	go s.run()
+
+	return s
+}
+
+// NewPool creates a pool flushed by the scheduler.  Stopping or
+// parking the pool removes it from the scheduler.
+func (s *Scheduler) NewPool(url, ezKey string) *Pool {
+
+	p := newPool(url, ezKey)
+	p.client = s.client
+	p.sched = s
+	p.starter = func() {
+		st := &scheduled{done: make(chan struct{})}
+
+		s.mu.Lock()
+		s.pools[p] = st
+		s.mu.Unlock()
+
+		p.runShards(st.done)
+		p.resendOnStart()
+	}
+
+	return p
+}
+
+// Stop stops the shared timer.  Pools are not flushed or
+// stopped and must be stopped individually, the scheduler's
+// goroutine exits once they are.
+func (s *Scheduler) Stop() {
+	close(s.stop)
+}
+
+// run aggregates the pools' stats and flushes them on each tick
+func (s *Scheduler) run() {
+
+	stop := s.stop
+	for {
+		select {
+		case <-s.tick.C:
+			for p, st := range s.running() {
+				if p.endInterval(&st.idle) {
+					s.remove(p, st)
+				}
+			}
+
+		case p := <-s.wake:
+			atomic.StoreInt32(&p.woken, 0)
+			if st := s.state(p); st != nil {
+				p.agg.drain(p.count, p.value, p.batch)
+				p.flushHighWater()
+			}
+
+		case r := <-s.requests:
+			p, st := r.p, s.state(r.p)
+			stats := p.rotate()
+			if !r.stop {
+				go func() { r.req.err <- p.countedFlush(r.req.ctx, stats) }()
+				break
+			}
+			if st != nil {
+				s.remove(p, st)
+			}
+			go func() {
+				err := p.countedFlush(r.req.ctx, stats)
+				r.req.err <- stopError(err, p.dropHeld())
+			}()
+
+		case <-stop:
+			s.tick.Stop()
+			stop = nil
+		}
+
+		if stop == nil && len(s.running()) == 0 {
+			return
+		}
+	}
+
+}
+
+// running returns the pools being flushed
+func (s *Scheduler) running() map[*Pool]*scheduled {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pools := make(map[*Pool]*scheduled, len(s.pools))
+	for p, st := range s.pools {
+		pools[p] = st
+	}
+	return pools
+}
+
+func (s *Scheduler) state(p *Pool) *scheduled {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pools[p]
+}
+
+// remove stops flushing p unless it has since been started again
+func (s *Scheduler) remove(p *Pool, st *scheduled) {
+	s.mu.Lock()
+	if s.pools[p] == st {
+		delete(s.pools, p)
+	}
+	s.mu.Unlock()
+	close(st.done)
+}
+
+// signal sends a flush or stop request for p to the scheduler
+func (s *Scheduler) signal(ctx context.Context, p *Pool, stop bool, req flushRequest) error {
+	select {
+	case s.requests <- scheduledRequest{p, stop, req}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// wakeScheduler has the scheduler take p's queued stats before the
+// next tick
+func (p *Pool) wakeScheduler() {
+	if p.sched == nil || !atomic.CompareAndSwapInt32(&p.woken, 0, 1) {
+		return
+	}
+	select {
+	case p.sched.wake <- p:
+	default:
+		atomic.StoreInt32(&p.woken, 0)
+	}
+}
+
+// acquire waits for a free send worker, or until ctx is done
+func (s *Scheduler) acquire(ctx context.Context) error {
+	select {
+	case s.workers <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Scheduler) release() { <-s.workers }
@@ -0,0 +1,138 @@
+package statpool
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	MetricType string
+
+	// Description documents a stat key
+	Description struct {
+		Key  string     `json:"name"`
+		Type MetricType `json:"type"`
+		Unit string     `json:"unit,omitempty"`
+		Help string     `json:"description,omitempty"`
+	}
+
+	// Schema is a set of described stat keys
+	Schema struct {
+		mu    sync.RWMutex
+		descs map[string]Description
+	}
+
+	// typed key handles, usually generated from a schema file
+	CountKey    string
+	ValueKey    string
+	DurationKey string
+)
+
+const (
+	CountMetric    MetricType = "count"
+	ValueMetric    MetricType = "value"
+	DurationMetric MetricType = "duration"
+)
+
+// DefaultSchema holds keys registered with Describe
+var DefaultSchema = NewSchema()
+
+func NewSchema() *Schema {
+	return &Schema{descs: map[string]Description{}}
+}
+
+// Describe registers d with the DefaultSchema
+func Describe(d Description) {
+	DefaultSchema.Describe(d)
+}
+
+func (s *Schema) Describe(d Description) {
+	s.mu.Lock()
+	s.descs[d.Key] = d
+	s.mu.Unlock()
+}
+
+func (s *Schema) Lookup(key string) (Description, bool) {
+	s.mu.RLock()
+	d, exists := s.descs[key]
+	s.mu.RUnlock()
+	return d, exists
+}
+
+// Descriptions returns every registered description sorted by key
+func (s *Schema) Descriptions() []Description {
+	s.mu.RLock()
+	descs := make([]Description, 0, len(s.descs))
+	for _, d := range s.descs {
+		descs = append(descs, d)
+	}
+	s.mu.RUnlock()
+	sort.Slice(descs, func(i, j int) bool { return descs[i].Key < descs[j].Key })
+	return descs
+}
+
+func (k CountKey) Count(s Stater, val float64) {
+	s.Count(string(k), val)
+}
+
+func (k ValueKey) Value(s Stater, val float64, timestamp time.Time) {
+	s.Value(string(k), val, timestamp)
+}
+
+func (k DurationKey) Duration(s Stater, val time.Duration) {
+	s.Duration(string(k), val)
+}
+
+type UndeclaredPolicy int
+
+const (
+	DropUndeclared UndeclaredPolicy = iota // drop stats with undeclared keys
+	FoldUndeclared                         // report them under UndeclaredKey
+)
+
+const (
+	// undeclared stats are folded into this key
+	UndeclaredKey = "__undeclared__"
+
+	// undeclared stats are counted under this key
+	undeclaredKeysKey = "statpool.undeclared_keys"
+)
+
+type schemaEnforcement struct {
+	schema *Schema
+	policy UndeclaredPolicy
+}
+
+// EnforceSchema only accepts stats whose keys, without the pool
+// prefix, are described in s.  Pass a nil schema to accept all keys.
+func (p *Pool) EnforceSchema(s *Schema, policy UndeclaredPolicy) {
+	p.enforce.Store(&schemaEnforcement{schema: s, policy: policy})
+}
+
+// declared checks key against the enforced schema, folding it if
+// needed, and reports whether the stat should be kept
+func (p *Pool) declared(key *string) bool {
+
+	e, _ := p.enforce.Load().(*schemaEnforcement)
+	if e == nil || e.schema == nil {
+		return true
+	}
+
+	name := strings.TrimPrefix(*key, p.prefix)
+	if strings.HasPrefix(name, "statpool.") || name == UndeclaredKey {
+		return true
+	}
+	if _, exists := e.schema.Lookup(name); exists {
+		return true
+	}
+
+	p.SendCount(&CountStat{Key: p.prefix + undeclaredKeysKey, Count: 1})
+	if e.policy == FoldUndeclared {
+		*key = p.prefix + UndeclaredKey
+		return true
+	}
+	return false
+
+}
@@ -0,0 +1,139 @@
+package statpool
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// self stats are reported under these keys
+const (
+	droppedKey     = "statpool.dropped"
+	flushTimeKey   = "statpool.flush_time"
+	sentKey        = "statpool.sent"
+	payloadKey     = "statpool.payload_bytes"
+	sendErrorsKey  = "statpool.send_errors"
+	sendRetriesKey = "statpool.send_retries"
+	sendQueueKey   = "statpool.send_queue"
+)
+
+type (
+	// PoolStats are counts of what a pool has done since it was created
+	PoolStats struct {
+		// stats dropped because the channels were backed up
+		Dropped int64
+
+		// flushes that sent anything, and how long the last took
+		Flushes   int64
+		FlushTime time.Duration
+
+		// stats and payload bytes accepted by the endpoint
+		Sent  int64
+		Bytes int64
+
+		// failed requests, and retries of them
+		Errors  int64
+		Retries int64
+
+		// whether the endpoint has paused sending with a
+		// Retry-After, and until when
+		Paused      bool
+		PausedUntil time.Time
+
+		// stats held to resend once sending resumes
+		Held int64
+
+		// state of the circuit breaker
+		Breaker BreakerState
+
+		// chunks waiting for a send worker
+		SendQueue int64
+	}
+
+	poolCounters struct {
+		dropped   int64
+		flushes   int64
+		flushTime int64
+		sent      int64
+		bytes     int64
+		errors    int64
+		retries   int64
+
+		// chunks waiting for a send worker, and the most
+		// since the self stats were last reported
+		sendQueue     int64
+		peakSendQueue int64
+
+		// reporting of the counters as stats
+		report   int32
+		reported PoolStats
+		mu       sync.Mutex
+	}
+)
+
+// Stats returns a snapshot of the pool's own operation
+func (p *Pool) Stats() PoolStats {
+	c := &p.counters
+	stats := PoolStats{
+		Dropped:   atomic.LoadInt64(&c.dropped),
+		Flushes:   atomic.LoadInt64(&c.flushes),
+		FlushTime: time.Duration(atomic.LoadInt64(&c.flushTime)),
+		Sent:      atomic.LoadInt64(&c.sent),
+		Bytes:     atomic.LoadInt64(&c.bytes),
+		Errors:    atomic.LoadInt64(&c.errors),
+		Retries:   atomic.LoadInt64(&c.retries),
+		Held:      p.heldStats(),
+		Breaker:   p.breaker.current(),
+		SendQueue: atomic.LoadInt64(&c.sendQueue),
+	}
+	if resumeAt := time.Unix(0, atomic.LoadInt64(&p.resumeAt)); p.now().Before(resumeAt) {
+		stats.Paused, stats.PausedUntil = true, resumeAt
+	}
+	return stats
+}
+
+// SetReportStats reports the pool's own stats along with the rest,
+// as counts of what changed since the last flush under statpool.
+// keys, the flush time as a value in milliseconds and the most chunks
+// waiting for a send worker as a value.  Since each
+// flush then has something to report, the pool won't idle shut down.
+func (p *Pool) SetReportStats(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&p.counters.report, v)
+}
+
+// flushed records a flush that took d and queues the changes in
+// the self stats when they are reported
+func (p *Pool) flushed(d time.Duration) {
+
+	c := &p.counters
+	atomic.AddInt64(&c.flushes, 1)
+	atomic.StoreInt64(&c.flushTime, int64(d))
+
+	if atomic.LoadInt32(&c.report) == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	stats, last := p.Stats(), c.reported
+	c.reported = stats
+	c.mu.Unlock()
+
+	for key, delta := range map[string]int64{
+		droppedKey:     stats.Dropped - last.Dropped,
+		sentKey:        stats.Sent - last.Sent,
+		payloadKey:     stats.Bytes - last.Bytes,
+		sendErrorsKey:  stats.Errors - last.Errors,
+		sendRetriesKey: stats.Retries - last.Retries,
+	} {
+		if delta > 0 {
+			p.SendCount(&CountStat{Key: p.prefix + key, Count: float64(delta)})
+		}
+	}
+	p.SendValue(&ValueStat{Key: p.prefix + flushTimeKey, Value: float64(d) / float64(time.Millisecond)})
+	p.SendValue(&ValueStat{Key: p.prefix + sendQueueKey, Value: float64(atomic.SwapInt64(&c.peakSendQueue, 0))})
+
+}
@@ -0,0 +1,224 @@
+package statpool
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// Sender delivers a batch of aggregated stats, *CountStat and
+	// *ValueStat, somewhere.  The pool retries, spools and throttles
+	// around it, so a Sender only needs to make a single attempt.
+	Sender interface {
+		Send(ctx context.Context, stats []interface{}) error
+	}
+
+	// SenderFunc adapts a function to a Sender
+	SenderFunc func(ctx context.Context, stats []interface{}) error
+
+	// httpSender posts to the pool's endpoints
+	httpSender struct {
+		p *Pool
+	}
+
+	// permanentError is a failure that won't succeed on retry
+	permanentError struct {
+		error
+	}
+
+	idempotencyKeyCtx struct{}
+	ezKeyCtx          struct{}
+)
+
+func (f SenderFunc) Send(ctx context.Context, stats []interface{}) error {
+	return f(ctx, stats)
+}
+
+func (e permanentError) Unwrap() error {
+	return e.error
+}
+
+// SetSender replaces sending to the pool's http endpoints with s.
+// Setting nil restores the default.
+func (p *Pool) SetSender(s Sender) {
+	if s == nil {
+		s = httpSender{p}
+	}
+	p.sender = s
+}
+
+// IdempotencyKey returns the identifier of the batch being sent.  It
+// is the same each time a batch is resent, so receivers can discard
+// duplicates.
+func IdempotencyKey(ctx context.Context) string {
+	id, _ := ctx.Value(idempotencyKeyCtx{}).(string)
+	return id
+}
+
+func withIdempotencyKey(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyCtx{}, id)
+}
+
+func withEZKey(ctx context.Context, ezKey string) context.Context {
+	if ezKey == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, ezKeyCtx{}, ezKey)
+}
+
+// ezKeyFor returns the ez key a batch is sent under
+func (p *Pool) ezKeyFor(ctx context.Context) string {
+	ezKey, _ := ctx.Value(ezKeyCtx{}).(string)
+	return p.ezKeyOr(ezKey)
+}
+
+func (s httpSender) Send(ctx context.Context, stats []interface{}) error {
+	return s.p.post(ctx, stats)
+}
+
+// post sends the stats to the first healthy endpoint, failing over
+// to the others in order when one is unreachable
+func (p *Pool) post(ctx context.Context, stats []interface{}) error {
+
+	var (
+		err      error
+		failover bool
+	)
+	for _, ep := range p.endpointOrder() {
+		if err, failover = p.postTo(ctx, ep, stats); !failover {
+			// a down endpoint tried as a last resort is back
+			if err == nil && !ep.healthy(p.now()) {
+				ep.markUp()
+			}
+			break
+		}
+		p.markDown(ep)
+		p.report(fmt.Errorf("endpoint %s failed: %w", ep.url, err), 0)
+	}
+
+	if err != nil && !failover && !errors.Is(err, errThrottled) {
+		return permanentError{err}
+	}
+	return err
+
+}
+
+// postTo sends the stats to a single endpoint and reports whether
+// the failure warrants trying another endpoint
+func (p *Pool) postTo(ctx context.Context, ep *endpoint, stats []interface{}) (error, bool) {
+
+	var (
+		ezKey       = p.ezKeyFor(ctx)
+		binary      = atomic.LoadInt32(&p.binary) == 1
+		compressed  = atomic.LoadInt32(&p.gzip) == 1
+		streamed    = atomic.LoadInt32(&p.streaming) == 1 && !binary
+		body        io.Reader
+		contentType = ContentTypeJSON
+		size        int64
+	)
+
+	if streamed {
+		stream := streamPayload(ezKey, stats, compressed, &size)
+		defer stream.Close()
+		body = stream
+	} else {
+		buf := &bytes.Buffer{}
+		var err error
+		if contentType, err = encodePayload(buf, ezKey, stats, binary); err != nil {
+			return &EncodeError{err}, false
+		}
+		body, size = buf, int64(buf.Len())
+
+		if compressed {
+			zbuf := &bytes.Buffer{}
+			zw := gzip.NewWriter(zbuf)
+			if _, err := zw.Write(buf.Bytes()); err != nil {
+				return &EncodeError{err}, false
+			}
+			if err := zw.Close(); err != nil {
+				return &EncodeError{err}, false
+			}
+			body, size = zbuf, int64(zbuf.Len())
+		}
+	}
+
+	if timeout := time.Duration(atomic.LoadInt64(&p.requestTimeout)); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", ep.url+"?ezkey="+url.QueryEscape(ezKey), body)
+	if err != nil {
+		return err, false
+	}
+
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(IdempotencyHeader, IdempotencyKey(ctx))
+	if compressed {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		atomic.AddInt64(&p.counters.errors, 1)
+		return &TransportError{err}, true
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		atomic.AddInt64(&p.counters.errors, 1)
+	}
+
+	// receiver doesn't speak the binary protocol.  A 415 doesn't say
+	// whether the encoding or the compression was refused, so json is
+	// tried first, keeping gzip for receivers that only lack binary.
+	if binary && resp.StatusCode == http.StatusUnsupportedMediaType {
+		p.log.Println("binary encoding not supported by endpoint, falling back to json")
+		atomic.StoreInt32(&p.binary, 0)
+		return p.postTo(ctx, ep, stats)
+	}
+
+	// receiver doesn't accept compressed bodies
+	if compressed && resp.StatusCode == http.StatusUnsupportedMediaType {
+		p.log.Println("gzip not supported by endpoint, sending uncompressed")
+		atomic.StoreInt32(&p.gzip, 0)
+		return p.postTo(ctx, ep, stats)
+	}
+
+	// back off and resend once the endpoint allows, 5xx responses
+	// only pause sending when they say for how long
+	if resp.StatusCode == http.StatusTooManyRequests || (resp.StatusCode >= 500 && resp.Header.Get("Retry-After") != "") {
+		wait := parseRetryAfter(resp.Header.Get("Retry-After"), p.now())
+		p.throttle(wait)
+		return fmt.Errorf("%w, pausing flushes for %s", errThrottled, wait), false
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return &HTTPStatusError{resp.StatusCode}, resp.StatusCode >= 500
+	}
+
+	var sresp statResponse
+	if err := json.NewDecoder(resp.Body).Decode(&sresp); err != nil {
+		return err, false
+	}
+
+	if sresp.Status != http.StatusOK {
+		atomic.AddInt64(&p.counters.errors, 1)
+		return &APIError{sresp.Status, sresp.Message}, false
+	}
+
+	atomic.AddInt64(&p.counters.sent, int64(len(stats)))
+	atomic.AddInt64(&p.counters.bytes, atomic.LoadInt64(&size))
+	return nil, false
+
+}
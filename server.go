@@ -0,0 +1,238 @@
+package statpool
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Server accepts stats from other processes and aggregates them
+// into a Pool.  It speaks the statpool http protocol (json or
+// binary payloads), the statsd line protocol over udp and the
+// Graphite plaintext protocol over tcp.
+type Server struct {
+	pool *Pool
+	log  *log.Logger
+
+	// recently received payloads
+	received idempotencySet
+
+	// cumulative openmetrics series
+	counters counterState
+}
+
+func NewServer(pool *Pool) *Server {
+	return &Server{
+		pool: pool,
+		log:  log.New(os.Stderr, "statpool server: ", log.LstdFlags),
+	}
+}
+
+func (s *Server) SetLogger(l *log.Logger) {
+	s.log = l
+}
+
+// ServeHTTP accepts payloads posted by a Pool and responds the way
+// the StatHat ez api does.  Payloads repeating an idempotency key
+// seen recently are acknowledged without being counted again.  Bodies
+// may be gzip compressed.
+// OpenMetrics and Prometheus text pushed to it are ingested with
+// ReadOpenMetrics.
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+
+	if req.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body io.Reader = req.Body
+	switch encoding := req.Header.Get("Content-Encoding"); encoding {
+	case "", "identity":
+	case "gzip":
+		zr, err := gzip.NewReader(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer zr.Close()
+		body = zr
+	default:
+		http.Error(w, "unsupported content encoding: "+encoding, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mediaType == ContentTypeOpenMetrics || mediaType == ContentTypePrometheus {
+		if err := s.ReadOpenMetrics(body, mediaType == ContentTypePrometheus); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	id := req.Header.Get(IdempotencyHeader)
+	if id != "" && !s.received.add(id, time.Now()) {
+		w.Header().Set("Content-Type", ContentTypeJSON)
+		json.NewEncoder(w).Encode(&statResponse{Status: http.StatusOK, Message: "duplicate"})
+		return
+	}
+
+	_, stats, err := DecodePayload(req.Header.Get("Content-Type"), body)
+	if err != nil {
+		if id != "" {
+			s.received.remove(id)
+		}
+		if errors.Is(err, ErrUnsupportedContentType) {
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
+		}
+		w.Header().Set("Content-Type", ContentTypeJSON)
+		json.NewEncoder(w).Encode(&statResponse{Status: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+
+	s.receive(stats)
+
+	w.Header().Set("Content-Type", ContentTypeJSON)
+	json.NewEncoder(w).Encode(&statResponse{Status: http.StatusOK, Message: "ok"})
+
+}
+
+// ServeStatsd reads statsd packets from conn until it is closed.
+func (s *Server) ServeStatsd(conn net.PacketConn) error {
+	buf := make([]byte, 65535)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			if line = strings.TrimSpace(line); line == "" {
+				continue
+			}
+			stat, err := parseStatsd(line)
+			if err != nil {
+				s.log.Println(err)
+				continue
+			}
+			s.receive([]interface{}{stat})
+		}
+	}
+}
+
+// ServeGraphite accepts Graphite plaintext connections on l
+// until it is closed.
+func (s *Server) ServeGraphite(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			scanner := bufio.NewScanner(conn)
+			for scanner.Scan() {
+				line := strings.TrimSpace(scanner.Text())
+				if line == "" {
+					continue
+				}
+				stat, err := parseGraphite(line)
+				if err != nil {
+					s.log.Println(err)
+					continue
+				}
+				s.receive([]interface{}{stat})
+			}
+		}()
+	}
+}
+
+// receive hands stats to the pool under its prefix, blocking rather
+// than dropping so large payloads aren't lost to the channel buffers
+func (s *Server) receive(stats []interface{}) {
+	for _, val := range stats {
+		switch stat := val.(type) {
+		case *CountStat:
+			stat.Key = s.pool.prefix + stat.Key
+			s.pool.sendCount(stat, true)
+		case *ValueStat:
+			stat.Key = s.pool.prefix + stat.Key
+			s.pool.sendValue(stat, true)
+		}
+	}
+}
+
+// parseStatsd parses a single statsd line of the form
+// key:value|type[|@rate][|#tags]
+func parseStatsd(line string) (interface{}, error) {
+
+	// tags contain colons too, so look for the value before the first pipe
+	colon := -1
+	if pipe := strings.Index(line, "|"); pipe > 0 {
+		colon = strings.LastIndex(line[:pipe], ":")
+	}
+	if colon < 1 {
+		return nil, fmt.Errorf("invalid statsd line: %q", line)
+	}
+
+	key, fields := line[:colon], strings.Split(line[colon+1:], "|")
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("invalid statsd line: %q", line)
+	}
+
+	val, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid statsd value: %q", line)
+	}
+
+	rate := 1.0
+	for _, field := range fields[2:] {
+		if strings.HasPrefix(field, "@") {
+			if rate, err = strconv.ParseFloat(field[1:], 64); err != nil || rate <= 0 {
+				return nil, fmt.Errorf("invalid statsd sample rate: %q", line)
+			}
+		}
+	}
+
+	switch fields[1] {
+	case "c":
+		return &CountStat{Key: key, Count: val / rate}, nil
+	case "g", "ms", "h":
+		return &ValueStat{Key: key, Value: val}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported statsd type: %q", line)
+
+}
+
+// parseGraphite parses a single Graphite plaintext line of
+// the form path value timestamp
+func parseGraphite(line string) (interface{}, error) {
+
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("invalid graphite line: %q", line)
+	}
+
+	val, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid graphite value: %q", line)
+	}
+
+	ts, err := strconv.ParseFloat(fields[2], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid graphite timestamp: %q", line)
+	}
+
+	return &ValueStat{Key: fields[0], Value: val, Timestamp: int64(ts)}, nil
+
+}
@@ -0,0 +1,251 @@
+package statpool
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServer(t *testing.T) {
+
+	upstream := NewPool(ts.URL, EZKey, time.Hour)
+	defer upstream.Stop()
+
+	server := NewServer(upstream)
+	relay := httptest.NewServer(server)
+	defer relay.Close()
+
+	// two hosts reporting the same counter
+	for i := 0; i < 2; i++ {
+		host := NewPool(relay.URL, "host", time.Hour)
+		host.SetBinaryEncoding(i == 0)
+		host.Count("requests", 2)
+		time.Sleep(10 * time.Millisecond)
+		host.Stop()
+	}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go server.ServeStatsd(conn)
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Write([]byte("requests:1|c|@0.5\nlatency:20|ms"))
+	client.Close()
+
+	time.Sleep(10 * time.Millisecond)
+	upstream.Flush()
+
+	var p Payload
+	if err := json.Unmarshal(<-reqs, &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.EZKey != EZKey {
+		t.Errorf("Expected: %q, got: %q", EZKey, p.EZKey)
+	}
+	for _, stat := range p.Data {
+		switch stat.Key {
+		case "requests":
+			if stat.Count != 6 {
+				t.Errorf("Expected: 6, got: %g", stat.Count)
+			}
+		case "latency":
+			if stat.Value != 20 {
+				t.Errorf("Expected: 20, got: %g", stat.Value)
+			}
+		default:
+			t.Errorf("Unexpected stat: %+v", stat)
+		}
+	}
+
+	resp, err := http.Post(relay.URL, "application/xml", &bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected: %d, got: %d", http.StatusUnsupportedMediaType, resp.StatusCode)
+	}
+
+}
+
+func TestParseGraphite(t *testing.T) {
+
+	stat, err := parseGraphite("servers.web1.load 0.5 1400000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := stat.(*ValueStat); v.Key != "servers.web1.load" || v.Value != 0.5 || v.Timestamp != 1400000000 {
+		t.Errorf("Unexpected stat: %+v", v)
+	}
+
+	if _, err := parseGraphite("servers.web1.load 0.5"); err == nil {
+		t.Error("Expected an error for a missing timestamp")
+	}
+
+}
+
+func TestServerIdempotency(t *testing.T) {
+
+	upstream := NewPool(ts.URL, EZKey, time.Hour)
+	defer upstream.Stop()
+
+	relay := httptest.NewServer(NewServer(upstream))
+	defer relay.Close()
+
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("POST", relay.URL, bytes.NewBufferString(`{"ezkey":"host","data":[{"stat":"requests","count":1}]}`))
+		req.Header.Set("Content-Type", ContentTypeJSON)
+		req.Header.Set(IdempotencyHeader, "payload-1")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	upstream.Flush()
+
+	var p Payload
+	if err := json.Unmarshal(<-reqs, &p); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Data) != 1 || p.Data[0].Count != 1 {
+		t.Errorf("Expected a single count of 1, got: %+v", p.Data)
+	}
+
+}
+
+func TestReadOpenMetrics(t *testing.T) {
+
+	upstream := NewPool(ts.URL, EZKey, time.Hour)
+	server := NewServer(upstream)
+
+	for _, requests := range []string{"10", "15"} {
+		if err := server.ReadOpenMetrics(bytes.NewBufferString(`# HELP http_requests Requests served.
+# TYPE http_requests counter
+http_requests_total{code="200"} `+requests+`
+# TYPE queue_depth gauge
+queue_depth 3 1400000000000
+# TYPE latency histogram
+latency_bucket{le="0.1"} 4
+latency_count 4
+`), true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	upstream.Stop()
+
+	var p Payload
+	if err := json.Unmarshal(<-reqs, &p); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]float64{
+		`http_requests_total{code="200"}`: 5,
+		`latency_count`:                   0,
+		`queue_depth`:                     3,
+	}
+	if len(p.Data) != 4 {
+		t.Errorf("Expected: 4 stats, got: %+v", p.Data)
+	}
+	for _, stat := range p.Data {
+		switch stat.Key {
+		case "queue_depth":
+			if stat.Value != 3 || stat.Timestamp != 1400000000 {
+				t.Errorf("Unexpected stat: %+v", stat)
+			}
+		default:
+			if v, ok := expected[stat.Key]; !ok || stat.Count != v {
+				t.Errorf("Unexpected stat: %+v", stat)
+			}
+		}
+	}
+
+	if _, _, _, _, err := parseOpenMetricsLine(`broken{le="1" 2`); err == nil {
+		t.Error("Expected an error for unterminated labels")
+	}
+
+}
+
+func TestFaultInjector(t *testing.T) {
+
+	var (
+		reached = 0
+		next    = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { reached++ })
+		faults  = NewFaultInjector(next, 1)
+		post    = func() int {
+			w := httptest.NewRecorder()
+			faults.ServeHTTP(w, httptest.NewRequest("POST", "/", nil))
+			return w.Code
+		}
+	)
+
+	faults.ThrottleRate, faults.RetryAfter = 1, 2*time.Second
+	w := httptest.NewRecorder()
+	faults.ServeHTTP(w, httptest.NewRequest("POST", "/", nil))
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "2" {
+		t.Errorf("Expected a 429 with Retry-After: 2, got: %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// partial failures reach next and fail with a retryable status
+	faults.ThrottleRate, faults.PartialRate = 0, 1
+	if code := post(); reached != 1 || code != http.StatusInternalServerError {
+		t.Errorf("Expected the request to reach next and fail, got: %d %d", reached, code)
+	}
+
+	faults.PartialRate, faults.ErrorRate = 0, 0.5
+	failed := 0
+	for i := 0; i < 100; i++ {
+		if post() == http.StatusInternalServerError {
+			failed++
+		}
+	}
+	if failed < 25 || failed > 75 {
+		t.Errorf("Expected about 50 injected errors, got: %d", failed)
+	}
+
+}
+
+func TestFaultInjectorSender(t *testing.T) {
+
+	var (
+		sent   = 0
+		faults = &FaultInjector{}
+		sender = faults.Sender(SenderFunc(func(ctx context.Context, stats []interface{}) error {
+			sent++
+			return nil
+		}))
+		send = func() error {
+			return sender.Send(context.Background(), []interface{}{&CountStat{Key: "darts", Count: 1}})
+		}
+	)
+
+	if err := send(); err != nil || sent != 1 {
+		t.Errorf("Expected a send without faults, got: %d %v", sent, err)
+	}
+
+	faults.ErrorRate = 1
+	if err := send(); !IsRetryable(err) || sent != 1 {
+		t.Errorf("Expected a retryable error without sending, got: %d %v", sent, err)
+	}
+
+	faults.ErrorRate, faults.PartialRate = 0, 1
+	if err := send(); !IsRetryable(err) || sent != 2 {
+		t.Errorf("Expected a retryable error after sending, got: %d %v", sent, err)
+	}
+
+}
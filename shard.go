@@ -0,0 +1,149 @@
+package statpool
+
+import (
+	"hash/fnv"
+	"sync/atomic"
+)
+
+// shard aggregates the stats of the keys hashed to it in its own
+// goroutine, so producers of different keys don't share a channel
+type shard struct {
+	count  chan *CountStat
+	value  chan *ValueStat
+	batch  chan []interface{}
+	rotate chan chan []interface{}
+	agg    *aggregator
+
+	// stats aggregated since the last rotate
+	pending int64
+}
+
+// SetShards spreads aggregation over n goroutines, each with its own
+// channels, by hash of the stat key.  Their stats are merged at each
+// flush.  It helps when many goroutines send stats at once and the
+// single aggregator can't keep up.  It must be called before the
+// pool is used, after any WithBufferSize.
+func (p *Pool) SetShards(n int) {
+	p.shards = nil
+	for i := 1; i < n; i++ {
+		p.shards = append(p.shards, &shard{
+			count:  make(chan *CountStat, cap(p.count)),
+			value:  make(chan *ValueStat, cap(p.value)),
+			batch:  make(chan []interface{}, batchBuffer),
+			rotate: make(chan chan []interface{}),
+			agg:    newAggregator(p),
+		})
+	}
+}
+
+// shardFor returns the channels for key, the pool's own for the
+// first shard
+func (p *Pool) shardFor(key string) (chan *CountStat, chan *ValueStat) {
+	i := p.shardIndex(key)
+	if i == 0 {
+		return p.count, p.value
+	}
+	s := p.shards[i-1]
+	return s.count, s.value
+}
+
+// shardIndex hashes key to a shard, zero being the pool's own
+func (p *Pool) shardIndex(key string) int {
+	if len(p.shards) == 0 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(p.shards)+1))
+}
+
+// batchFor returns the batch channel of shard i
+func (p *Pool) batchFor(i int) chan []interface{} {
+	if i == 0 {
+		return p.batch
+	}
+	return p.shards[i-1].batch
+}
+
+// queued is how many stats are waiting in the channels
+func (p *Pool) queued() int {
+	n := len(p.count) + len(p.value) + len(p.batch)
+	for _, s := range p.shards {
+		n += len(s.count) + len(s.value) + len(s.batch)
+	}
+	return n
+}
+
+// runShards starts the shards' goroutines, they exit when done closes
+func (p *Pool) runShards(done chan struct{}) {
+	for _, s := range p.shards {
+		go s.run(done)
+	}
+}
+
+// rotateShards returns the stats of every shard's interval
+func (p *Pool) rotateShards() []interface{} {
+	var stats []interface{}
+	for _, s := range p.shards {
+		c := make(chan []interface{})
+		s.rotate <- c
+		stats = append(stats, <-c...)
+	}
+	return stats
+}
+
+func (s *shard) run(done chan struct{}) {
+	for {
+		select {
+		case v := <-s.count:
+			s.agg.addCount(v)
+			s.added()
+		case v := <-s.value:
+			s.agg.addValue(v)
+			s.added()
+		case b := <-s.batch:
+			s.agg.addBatch(b)
+			s.added()
+		case c := <-s.rotate:
+			s.agg.drain(s.count, s.value, s.batch)
+			atomic.StoreInt64(&s.pending, 0)
+			c <- s.agg.rotate()
+		case <-done:
+			return
+		}
+	}
+}
+
+// added publishes how many stats the shard has aggregated and has
+// the pool check its backlog
+func (s *shard) added() {
+	atomic.StoreInt64(&s.pending, int64(s.agg.pending()))
+	s.agg.p.checkBacklog()
+}
+
+// pending is how many stats are aggregated for the next flush across
+// the pool's own aggregator and its shards
+func (p *Pool) pending() int {
+	n := p.agg.pending()
+	for _, s := range p.shards {
+		n += int(atomic.LoadInt64(&s.pending))
+	}
+	return n
+}
+
+// checkBacklog has the pool's goroutine, or its scheduler, check for
+// a high water mark or adaptive flush threshold reached through the
+// shards
+func (p *Pool) checkBacklog() {
+	if a, _ := p.adaptive.Load().(*adaptiveFlush); a == nil && atomic.LoadInt64(&p.highWater) <= 0 {
+		return
+	}
+	if p.sched != nil {
+		p.wakeScheduler()
+		return
+	}
+	select {
+	case p.backlog <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,223 @@
+package statpool
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// spool keeps batches that couldn't be sent on disk
+	spool struct {
+		dir string
+		max int64
+		mu  sync.Mutex
+	}
+
+	// spoolPayload is a payload as spooled, keeping the tags that
+	// requests leave out
+	spoolPayload struct {
+		EZKey string      `json:"ezkey"`
+		Data  []spoolStat `json:"data"`
+	}
+
+	spoolStat struct {
+		jsonStat
+		Tags []Tag `json:"tags,omitempty"`
+	}
+)
+
+// SetSpool writes batches that can't be sent, because the endpoint is
+// unreachable or erroring, to json files in dir.  Spooled batches are
+// sent again as the pool starts and with each flush after, including
+// any left by a previous run, except while the circuit breaker is
+// open.
+// When the files grow past maxBytes the oldest are removed.  Zero
+// maxBytes doesn't limit the spool and an empty dir disables it.
+func (p *Pool) SetSpool(dir string, maxBytes int64) error {
+	if dir == "" {
+		p.spool.Store((*spool)(nil))
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	p.spool.Store(&spool{dir: dir, max: maxBytes})
+	p.resendWaiting()
+	return nil
+}
+
+// spooled reports whether the spool has batches
+func (p *Pool) spooled() bool {
+	s := p.getSpool()
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	files, err := s.files()
+	return err == nil && len(files) > 0
+}
+
+func (p *Pool) getSpool() *spool {
+	s, _ := p.spool.Load().(*spool)
+	return s
+}
+
+// spoolBatch writes the batch to the spool if there is one
+func (p *Pool) spoolBatch(b *batch) bool {
+	s := p.getSpool()
+	if s == nil {
+		return false
+	}
+	if err := s.write(p.ezKeyOr(b.ezKey), b); err != nil {
+		p.report(fmt.Errorf("spool write failed: %w", err), 0)
+		return false
+	}
+	if p.devlogger != nil {
+		p.devlogger.Printf("spooled %d stats", len(b.stats))
+	}
+	return true
+}
+
+// takeSpooled removes and returns the batches in the spool
+func (p *Pool) takeSpooled() []*batch {
+	s := p.getSpool()
+	if s == nil {
+		return nil
+	}
+	batches, err := s.take(0)
+	if err != nil {
+		p.report(fmt.Errorf("spool read failed: %w", err), 0)
+	}
+	return batches
+}
+
+// files are named by creation time so they sort oldest first
+func (s *spool) files() ([]os.FileInfo, error) {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	files := infos[:0]
+	for _, info := range infos {
+		if !info.IsDir() && strings.HasSuffix(info.Name(), ".json") {
+			files = append(files, info)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+	return files, nil
+}
+
+func (s *spool) write(ezKey string, b *batch) error {
+
+	payload := spoolPayload{EZKey: ezKey, Data: make([]spoolStat, 0, len(b.stats))}
+	for _, val := range b.stats {
+		switch stat := val.(type) {
+		case *CountStat:
+			count := stat.Count
+			payload.Data = append(payload.Data, spoolStat{jsonStat{Key: stat.Key, Count: &count, Timestamp: stat.Timestamp}, stat.Tags})
+		case *ValueStat:
+			value := stat.Value
+			payload.Data = append(payload.Data, spoolStat{jsonStat{Key: stat.Key, Value: &value, Timestamp: stat.Timestamp}, stat.Tags})
+		}
+	}
+	buf := &bytes.Buffer{}
+	if err := json.NewEncoder(buf).Encode(&payload); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// write then rename so a partial file is never replayed
+	name := filepath.Join(s.dir, fmt.Sprintf("%020d-%s.json", b.created.UnixNano(), b.id))
+	if err := ioutil.WriteFile(name+".tmp", buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(name+".tmp", name); err != nil {
+		return err
+	}
+
+	if s.max <= 0 {
+		return nil
+	}
+
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, f := range files {
+		total += f.Size()
+	}
+	for len(files) > 0 && total > s.max {
+		if err := os.Remove(filepath.Join(s.dir, files[0].Name())); err != nil {
+			return err
+		}
+		total -= files[0].Size()
+		files = files[1:]
+	}
+	return nil
+
+}
+
+// take removes and returns the oldest batches, up to about limit
+// bytes of files if limit is positive
+func (s *spool) take(limit int64) ([]*batch, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, err := s.files()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		batches []*batch
+		read    int64
+	)
+	for _, f := range files {
+		if read += f.Size(); limit > 0 && read > limit && len(batches) > 0 {
+			break
+		}
+		name := filepath.Join(s.dir, f.Name())
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return batches, err
+		}
+		if err := os.Remove(name); err != nil {
+			return batches, err
+		}
+
+		var nanos int64
+		var id string
+		if _, err := fmt.Sscanf(strings.TrimSuffix(f.Name(), ".json"), "%d-%s", &nanos, &id); err != nil {
+			return batches, fmt.Errorf("invalid spool file %s: %s", f.Name(), err)
+		}
+		var payload spoolPayload
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return batches, fmt.Errorf("invalid spool file %s: %s", f.Name(), err)
+		}
+		stats := make([]interface{}, 0, len(payload.Data))
+		for _, s := range payload.Data {
+			switch {
+			case s.Count != nil:
+				stats = append(stats, &CountStat{Key: s.Key, Count: *s.Count, Timestamp: s.Timestamp, Tags: s.Tags})
+			case s.Value != nil:
+				stats = append(stats, &ValueStat{Key: s.Key, Value: *s.Value, Timestamp: s.Timestamp, Tags: s.Tags})
+			}
+		}
+		batches = append(batches, &batch{id: id, ezKey: payload.EZKey, stats: stats, created: time.Unix(0, nanos)})
+	}
+	return batches, nil
+
+}
@@ -0,0 +1,72 @@
+package statpool
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// stale stats dropped at flush are counted under this key
+const staleKey = "statpool.stale_dropped"
+
+// SetMaxAge drops stats older than d at flush time instead of sending
+// them, so stats held through a long outage don't land in current
+// charts.  Stats without a timestamp are aged from when they were first
+// flushed.  Zero, the default, sends stats of any age.
+func (p *Pool) SetMaxAge(d time.Duration) {
+	atomic.StoreInt64(&p.maxAge, int64(d))
+}
+
+// dropStale removes stats older than the max age from values and
+// from any held batches, counting what was dropped
+func (p *Pool) dropStale(values []interface{}, held []*batch, now time.Time) ([]interface{}, []*batch) {
+
+	maxAge := time.Duration(atomic.LoadInt64(&p.maxAge))
+	if maxAge <= 0 {
+		return values, held
+	}
+
+	var (
+		cutoff  = now.Add(-maxAge)
+		dropped = 0
+		fresh   = func(stats []interface{}) []interface{} {
+			kept := stats[:0]
+			for _, val := range stats {
+				var ts int64
+				switch stat := val.(type) {
+				case *CountStat:
+					ts = stat.Timestamp
+				case *ValueStat:
+					ts = stat.Timestamp
+				}
+				if ts != 0 && ts < cutoff.Unix() {
+					dropped++
+					continue
+				}
+				kept = append(kept, val)
+			}
+			return kept
+		}
+	)
+
+	values = fresh(values)
+
+	kept := held[:0]
+	for _, b := range held {
+		if b.created.Before(cutoff) {
+			dropped += len(b.stats)
+			continue
+		}
+		if b.stats = fresh(b.stats); len(b.stats) > 0 {
+			kept = append(kept, b)
+		}
+	}
+
+	if dropped > 0 {
+		p.report(fmt.Errorf("dropping %d stats older than %s", dropped, maxAge), dropped)
+		p.SendCount(&CountStat{Key: p.prefix + staleKey, Count: float64(dropped)})
+	}
+
+	return values, kept
+
+}
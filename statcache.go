@@ -0,0 +1,38 @@
+package statpool
+
+import "sync"
+
+// stats created by the pool's own methods are reused once the
+// aggregator has merged them into a stat already waiting to be sent,
+// saving an allocation per call for repeated keys.  Stats passed to
+// SendCount and SendValue belong to the caller and are never reused.
+var (
+	countStats = sync.Pool{New: func() interface{} { return &CountStat{} }}
+	valueStats = sync.Pool{New: func() interface{} { return &ValueStat{} }}
+)
+
+func newCountStat(key string, count float64) *CountStat {
+	s := countStats.Get().(*CountStat)
+	*s = CountStat{Key: key, Count: count, pooled: true}
+	return s
+}
+
+func newValueStat(v ValueStat) *ValueStat {
+	s := valueStats.Get().(*ValueStat)
+	*s = v
+	s.pooled = true
+	return s
+}
+
+// freeCount returns a merged stat for reuse if the pool created it
+func freeCount(s *CountStat) {
+	if s.pooled {
+		countStats.Put(s)
+	}
+}
+
+func freeValue(s *ValueStat) {
+	if s.pooled {
+		valueStats.Put(s)
+	}
+}
@@ -2,13 +2,12 @@ package statpool
 
 import (
 	"bytes"
-	"encoding/json"
-	"fmt"
+	"context"
 	"log"
-	"math/rand"
 	"net/http"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -19,38 +18,218 @@ type (
 		Duration(key string, val time.Duration)
 	}
 
+	// StaterV2 is the fuller interface implemented by every pool in
+	// the package, so code can swap between them
+	StaterV2 interface {
+		Stater
+		Gauge(key string, val float64)
+		DurationSince(key string, start time.Time)
+		SampledDuration(key string, val time.Duration, rate float64)
+		Timer(key string) *Timer
+	}
+
 	Pool struct {
 		// api key
-		ezKey  string
-		url    string
-		client *http.Client
-		log    *log.Logger
+		ezKey     string
+		interval  int64
+		chunkSize int
+		client    *http.Client
+		log       *log.Logger
+		onError   func(err error, dropped int)
+		binary    int32
+		gzip      int32
+		streaming int32
+
+		// stat methods are no-ops while set
+		disabled int32
+
+		// stats are held instead of sent while set
+		paused int32
+
+		// limits each request, in nanoseconds
+		requestTimeout int64
+
+		// flush early once this many stats are waiting
+		highWater int64
+
+		// bounds of the flush interval when it follows the traffic
+		adaptive atomic.Value
+
+		// spreading and alignment of flushes
+		flushJitter  int64
+		alignFlushes int32
+
+		// most json bytes in a request, if limited
+		maxPayload int64
 
 		// output stats to
 		devlogger *log.Logger
 
+		// shared flush timer and senders, if any, and whether the
+		// scheduler has been woken to take queued stats
+		sched *Scheduler
+		woken int32
+
+		// time source of timestamps and flushes
+		clock Clock
+
+		// limits the chunks sent at once
+		sendSlots chan struct{}
+
+		// starts the background goroutine on demand
+		starter   func()
+		running   int32
+		runningMu sync.Mutex
+		idleLimit int32
+
+		// set while options are applied, so nothing starts early
+		configuring bool
+
+		// aggregation state kept while the goroutine is parked
+		agg *aggregator
+
+		// further aggregators, by hash of the key
+		shards []*shard
+
 		// communication
-		stop     chan struct{}
+		retick   chan struct{}
+		backlog  chan struct{}
+		stop     chan flushRequest
 		done     chan struct{}
-		flush    chan struct{}
+		flush    chan flushRequest
 		flushing sync.WaitGroup
 		count    chan *CountStat
 		value    chan *ValueStat
+		batch    chan []interface{}
 
 		// prefix all keys with
 		prefix string
+
+		// joins flattened tags to keys
+		tagsep string
+
+		// handling of NaN and ±Inf samples
+		nonfinite NonFinitePolicy
+
+		// handling of stats sent while the channels are backed up
+		dropPolicy   DropPolicy
+		blockTimeout int64
+		onDrop       func(Stat)
+
+		// how values are combined when the key doesn't say
+		aggregation Aggregation
+
+		// decides which sampled calls are kept
+		sampler     atomic.Value
+		sampleRates atomic.Value
+
+		// mirrors of accepted stats
+		subs subscribers
+
+		// endpoints in order of preference
+		endpoints   []*endpoint
+		endpointsMu sync.RWMutex
+
+		// probes failed endpoints to bring them back
+		healthPath     string
+		healthInterval time.Duration
+
+		// batches held back while the endpoint is throttling
+		held      []*batch
+		heldMu    sync.Mutex
+		holdLimit int64
+		resumeAt  int64
+
+		// stats older than this are dropped at flush
+		maxAge int64
+
+		// delivers batches, http to the endpoints by default
+		sender Sender
+
+		// how failed sends are retried
+		retry atomic.Value
+
+		// batches that failed to send are written here
+		spool atomic.Value
+
+		// held batches past the memory limit, on disk
+		overflow atomic.Value
+
+		// stats unsent at stop are saved to and resent from, and
+		// the ids of those loaded until they're sent
+		recoveryFile string
+		recovered    map[string]bool
+		recoveredMu  sync.Mutex
+
+		// recently emitted stats for debugging
+		recent recentRing
+
+		// only accept keys declared in a schema
+		enforce atomic.Value
+
+		// cleans up keys as they're accepted
+		sanitize atomic.Value
+
+		// only accept keys passing these patterns
+		filter atomic.Value
+
+		// limits the distinct keys accepted
+		cardinality atomic.Value
+
+		// renames and drops keys at flush
+		relabels atomic.Value
+
+		// identifies this process's stats
+		instance InstanceOptions
+
+		// per-key reporting options
+		keyopts   map[string]KeyOptions
+		keyoptsMu sync.RWMutex
+
+		// stops sending during outages
+		breaker breaker
+
+		// the pool's own operation
+		counters poolCounters
+
+		// registered gauge functions
+		gaugeFuncs gaugeFuncs
 	}
 
 	ValueStat struct {
 		Key       string  `json:"stat"`
 		Value     float64 `json:"value"`
 		Timestamp int64   `json:"t,omitempty"`
+		Tags      []Tag   `json:"-"`
+
+		// only the last gauge value per interval is reported
+		gauge bool
+
+		// histogram values are reported as a summary
+		histogram bool
+
+		// members of unique stats are counted at flush
+		unique bool
+		member string
+
+		// sent under this ez key rather than the pool's
+		ezKey string
+
+		// created by the pool and reusable once merged
+		pooled bool
 	}
 
 	CountStat struct {
 		Key       string  `json:"stat"`
 		Count     float64 `json:"count"`
 		Timestamp int64   `json:"t,omitempty"`
+		Tags      []Tag   `json:"-"`
+
+		// sent under this ez key rather than the pool's
+		ezKey string
+
+		// created by the pool and reusable once merged
+		pooled bool
 	}
 
 	statPayload struct {
@@ -65,125 +244,382 @@ type (
 
 const (
 	DefaultStathatEndpoint = "https://api.stathat.com/ez"
-	chunkSize              = 3000
+	DefaultFlushInterval   = 10 * time.Second
+	DefaultChunkSize       = 3000
+	DefaultBufferSize      = 512
 )
 
+// NewPool creates a pool that flushes to url every flushInterval.
+// Its background goroutine is started by the first stat.
 func NewPool(url, ezKey string, flushInterval time.Duration) *Pool {
+	p := newPool(url, ezKey)
+	p.interval = int64(flushInterval)
+	return p
+}
 
+func newPool(url, ezKey string) *Pool {
 	p := &Pool{
-		ezKey: ezKey,
-		url:   url + "?ezkey=" + ezKey,
-
-		client: &http.Client{},
-		log:    log.New(os.Stderr, "statpool: ", log.LstdFlags),
-
-		flush:    make(chan struct{}),
+		ezKey:     ezKey,
+		endpoints: []*endpoint{newEndpoint(url)},
+		interval:  int64(DefaultFlushInterval),
+		chunkSize: DefaultChunkSize,
+
+		client:         &http.Client{},
+		requestTimeout: int64(DefaultRequestTimeout),
+		holdLimit:      DefaultHoldLimit,
+		sendSlots:      make(chan struct{}, DefaultMaxSends),
+		log:            log.New(os.Stderr, "statpool: ", log.LstdFlags),
+
+		flush:    make(chan flushRequest),
+		retick:   make(chan struct{}, 1),
+		backlog:  make(chan struct{}, 1),
 		flushing: sync.WaitGroup{},
-		stop:     make(chan struct{}),
+		stop:     make(chan flushRequest),
+
+		count: make(chan *CountStat, DefaultBufferSize),
+		value: make(chan *ValueStat, DefaultBufferSize),
+		batch: make(chan []interface{}, batchBuffer),
 
-		count: make(chan *CountStat, 512),
-		value: make(chan *ValueStat, 512),
+		keyopts: map[string]KeyOptions{},
+		tagsep:  DefaultTagSeparator,
+		clock:   realClock{},
 	}
+	p.agg = newAggregator(p)
+	p.sender = httpSender{p}
+	p.starter = p.startTicker
+	return p
+}
 
-	go func() {
+// run aggregates stats and flushes them on each tick until stopped
+func (p *Pool) run(tick <-chan time.Time, stopTick func()) {
 
-		var (
-			values = []interface{}{}
-			counts = map[string]*CountStat{}
-			tick   = time.NewTicker(flushInterval)
+	var (
+		agg  = p.agg
+		idle = 0
+		done = make(chan struct{})
+	)
 
-			rotate_values = func() []interface{} {
-				stats := values
-				values = []interface{}{}
-				counts = map[string]*CountStat{}
-				return stats
-			}
+	p.runShards(done)
+	defer close(done)
 
-			doflush = func(stats []interface{}) {
-				if err := p.doflush(stats); err != nil {
-					p.log.Println(err)
-				}
-				p.flushing.Done()
-			}
-		)
-
-		for {
-			select {
-			case v := <-p.count:
-				if stat, exists := counts[v.Key]; exists {
-					stat.Count += v.Count
-				} else {
-					counts[v.Key] = v
-					values = append(values, v)
-				}
-
-			case v := <-p.value:
-				values = append(values, v)
-
-			case <-tick.C:
-				p.flushing.Add(1) // add one so ending done call doesn't panic
-				go doflush(rotate_values())
-
-			case <-p.stop:
-				tick.Stop()
-				doflush(rotate_values())
+	p.resendOnStart()
+
+	for {
+		select {
+		case v := <-p.count:
+			agg.addCount(v)
+			p.flushHighWater()
+
+		case v := <-p.value:
+			agg.addValue(v)
+			p.flushHighWater()
+
+		case b := <-p.batch:
+			agg.addBatch(b)
+			p.flushHighWater()
+
+		case <-tick:
+			if p.endInterval(&idle) {
+				stopTick()
 				return
+			}
+
+		case <-p.backlog:
+			p.flushHighWater()
 
-			case <-p.flush:
-				doflush(rotate_values())
+		case <-p.retick:
+			if p.sched == nil {
+				stopTick()
+				tick, stopTick = p.ticker()
 			}
+
+		case req := <-p.stop:
+			stopTick()
+			err := p.countedFlush(req.ctx, p.rotate())
+			req.err <- stopError(err, p.dropHeld())
+			return
+
+		case req := <-p.flush:
+			req.err <- p.countedFlush(req.ctx, p.rotate())
 		}
-	}()
+	}
+}
 
-	return p
+// rotate takes the queued stats and returns those of the interval
+func (p *Pool) rotate() []interface{} {
+	p.agg.drain(p.count, p.value, p.batch)
+	stats := append(p.agg.rotate(), p.rotateShards()...)
+	// poll registered gauge functions
+	return p.pollGauges(stats, p.now().Unix())
 }
 
-func (p *Pool) SendCount(stat *CountStat) {
-	select {
-	case p.count <- stat:
-	default:
-		p.log.Printf("channels backed up, dropping stat: %+v", stat)
+// countedFlush flushes stats for a flush counted in p.flushing
+func (p *Pool) countedFlush(ctx context.Context, stats []interface{}) error {
+	err := p.doflush(ctx, stats)
+	p.flushing.Done()
+	return err
+}
+
+// resendOnStart resends what an earlier run left without waiting
+// for the tick
+func (p *Pool) resendOnStart() {
+	if p.waiting() {
+		p.flushing.Add(1)
+		go p.countedFlush(context.Background(), nil)
+	}
+}
+
+// flushHighWater flushes early when enough stats are waiting
+func (p *Pool) flushHighWater() {
+	pending := p.pending()
+	if n := atomic.LoadInt64(&p.highWater); n > 0 && int64(pending) >= n {
+		p.flushing.Add(1)
+		go p.countedFlush(context.Background(), p.rotate())
+		return
 	}
+	p.adaptBacklog(pending)
+}
+
+// endInterval flushes the stats of an interval in the background and
+// reports whether the pool has been idle long enough to park
+func (p *Pool) endInterval(idle *int) bool {
+	stats := p.rotate()
+	p.flushing.Add(1) // add one so ending done call doesn't panic
+	go p.countedFlush(context.Background(), stats)
+	p.adaptFlushed(len(stats))
+
+	if len(stats) > 0 {
+		*idle = 0
+		return false
+	}
+	*idle++
+	return p.park(*idle)
+}
+
+func (p *Pool) SendCount(stat *CountStat) {
+	p.sendCount(stat, false)
 }
 
 func (p *Pool) SendValue(stat *ValueStat) {
-	select {
-	case p.value <- stat:
-	default:
-		p.log.Printf("channels backed up, dropping stat: %+v", stat)
+	p.sendValue(stat, false)
+}
+
+// sendCount queues the stat for aggregation.  When block is false the
+// drop policy applies if the channel is backed up.
+func (p *Pool) sendCount(stat *CountStat, block bool) {
+	if !p.Enabled() {
+		return
+	}
+	if !p.admitCount(stat) {
+		return
+	}
+	published := countStat(stat)
+	if !p.queueCount(stat, block) {
+		p.record(CountType, published.Key, published.Value, "dropped: channel full")
+		return
+	}
+	p.ensureRunning()
+	p.publish(published)
+	p.record(CountType, published.Key, published.Value, "accepted")
+}
+
+func (p *Pool) sendValue(stat *ValueStat, block bool) {
+	if !p.Enabled() {
+		return
+	}
+	if !p.admitValue(stat) {
+		return
 	}
+	published := valueStat(stat)
+	if !p.queueValue(stat, block) {
+		p.record(ValueType, published.Key, published.Value, "dropped: channel full")
+		return
+	}
+	p.ensureRunning()
+	p.publish(published)
+	p.record(ValueType, published.Key, published.Value, "accepted")
+}
+
+// admitCount reports whether the stat passes the pool's checks,
+// recording why if it doesn't
+func (p *Pool) admitCount(stat *CountStat) bool {
+	if !p.filterNonFinite(&stat.Count) {
+		p.record(CountType, stat.Key, stat.Count, "dropped: not finite")
+		return false
+	}
+	if !p.sanitized(&stat.Key) {
+		p.record(CountType, stat.Key, stat.Count, "dropped: invalid key")
+		return false
+	}
+	if !p.filtered(stat.Key) {
+		p.record(CountType, stat.Key, stat.Count, "dropped: filtered")
+		return false
+	}
+	if !p.declared(&stat.Key) {
+		p.record(CountType, stat.Key, stat.Count, "dropped: undeclared key")
+		return false
+	}
+	if !p.withinCardinality(stat.ezKey, &stat.Key) {
+		p.record(CountType, stat.Key, stat.Count, "dropped: too many keys")
+		return false
+	}
+	return true
+}
+
+func (p *Pool) admitValue(stat *ValueStat) bool {
+	if !p.filterNonFinite(&stat.Value) {
+		p.record(ValueType, stat.Key, stat.Value, "dropped: not finite")
+		return false
+	}
+	if !p.sanitized(&stat.Key) {
+		p.record(ValueType, stat.Key, stat.Value, "dropped: invalid key")
+		return false
+	}
+	if !p.filtered(stat.Key) {
+		p.record(ValueType, stat.Key, stat.Value, "dropped: filtered")
+		return false
+	}
+	if !p.declared(&stat.Key) {
+		p.record(ValueType, stat.Key, stat.Value, "dropped: undeclared key")
+		return false
+	}
+	if !p.withinCardinality(stat.ezKey, &stat.Key) {
+		p.record(ValueType, stat.Key, stat.Value, "dropped: too many keys")
+		return false
+	}
+	if !p.keyOptions(stat.Key).Validate.validate(stat) {
+		p.SendCount(&CountStat{Key: p.prefix + rejectedValuesKey, Count: 1})
+		p.record(ValueType, stat.Key, stat.Value, "dropped: failed validation")
+		return false
+	}
+	return true
 }
 
 func (p *Pool) Count(key string, val float64) {
+	if !p.Enabled() {
+		return
+	}
 	if p.devlogger != nil {
 		p.devlogger.Printf("%s%s:%g", p.prefix, key, val)
 	}
-	p.SendCount(&CountStat{Key: p.prefix + key, Count: val})
+	p.SendCount(newCountStat(p.prefix+key, val))
+}
+
+// Inc counts one for key
+func (p *Pool) Inc(key string) {
+	p.Count(key, 1)
+}
+
+// Dec counts minus one for key, so Inc and Dec pairs can track
+// things in flight
+func (p *Pool) Dec(key string) {
+	p.Count(key, -1)
+}
+
+// Add counts delta for key, it's the same as Count
+func (p *Pool) Add(key string, delta float64) {
+	p.Count(key, delta)
 }
 
 func (p *Pool) Value(key string, val float64, timestamp time.Time) {
+	if !p.Enabled() {
+		return
+	}
 	if p.devlogger != nil {
 		p.devlogger.Printf("%s%s:%g", p.prefix, key, val)
 	}
-	p.SendValue(&ValueStat{Key: p.prefix + key, Value: val, Timestamp: timestamp.Unix()})
+	p.SendValue(newValueStat(ValueStat{Key: p.prefix + key, Value: val, Timestamp: timestamp.Unix()}))
 }
 
 func (p *Pool) Duration(key string, val time.Duration) {
+	if !p.Enabled() {
+		return
+	}
 	if p.devlogger != nil {
 		p.devlogger.Printf("%s%s:%s", p.prefix, key, val)
 	}
-	p.SendValue(&ValueStat{Key: p.prefix + key, Value: float64(val) / float64(time.Millisecond)})
+	p.SendValue(newValueStat(ValueStat{Key: p.prefix + key, Value: float64(val) / float64(time.Millisecond)}))
+}
+
+// Gauge records the current value of key.  Only the most recent
+// value in each flush interval is reported.
+func (p *Pool) Gauge(key string, val float64) {
+	if !p.Enabled() {
+		return
+	}
+	if p.devlogger != nil {
+		p.devlogger.Printf("%s%s:%g", p.prefix, key, val)
+	}
+	p.SendValue(newValueStat(ValueStat{Key: p.prefix + key, Value: val, Timestamp: p.now().Unix(), gauge: true}))
+}
+
+// Histogram records an observation of key.  At each flush the
+// observations are reported as key.count, key.max, key.p50, key.p95
+// and key.p99 (along with key.sum and key.min) computed from a fixed
+// size sample, so memory stays bounded however many are recorded.
+func (p *Pool) Histogram(key string, val float64) {
+	if !p.Enabled() {
+		return
+	}
+	if p.devlogger != nil {
+		p.devlogger.Printf("%s%s:%g", p.prefix, key, val)
+	}
+	p.SendValue(&ValueStat{Key: p.prefix + key, Value: val, histogram: true})
 }
 
+// Unique records member as seen for key.  At each flush the number of
+// distinct members seen during the interval is reported as a value,
+// estimated with a HyperLogLog so memory stays bounded.
+func (p *Pool) Unique(key string, member string) {
+	if !p.Enabled() {
+		return
+	}
+	if p.devlogger != nil {
+		p.devlogger.Printf("%s%s:%s", p.prefix, key, member)
+	}
+	p.SendValue(newValueStat(ValueStat{Key: p.prefix + key, unique: true, member: member}))
+}
+
+func (p *Pool) DurationSince(key string, start time.Time) {
+	p.Duration(key, p.now().Sub(start))
+}
+
+// SampledDuration records the duration for the calls kept by the
+// sampler, with probability rate by default
 func (p *Pool) SampledDuration(key string, val time.Duration, rate float64) {
+	if !p.Enabled() {
+		return
+	}
 	if p.devlogger != nil {
 		p.devlogger.Printf("%s%s:%s", p.prefix, key, val)
 	}
-	if rate < rand.Float64() {
-		p.SendValue(&ValueStat{Key: p.prefix + key, Value: float64(val) / float64(time.Millisecond)})
+	if _, ok := p.sample(p.prefix+key, rate); ok {
+		p.SendValue(newValueStat(ValueStat{Key: p.prefix + key, Value: float64(val) / float64(time.Millisecond)}))
+	}
+}
+
+// SampledCount counts val for the calls kept by the sampler, scaling
+// it by 1/rate so totals stay correct.  By default calls are kept
+// with probability rate, rates of one or more keeping every call.
+func (p *Pool) SampledCount(key string, val float64, rate float64) {
+	if !p.Enabled() {
+		return
+	}
+	if r, ok := p.sample(p.prefix+key, rate); ok {
+		p.Count(key, scale(val, r))
 	}
 }
 
+// SetHighWaterMark flushes as soon as n stats are waiting to be sent
+// rather than waiting for the next flush interval, so bursts don't
+// back up.  Counts and aggregated values of a key already waiting
+// don't add to it.  Zero only flushes on the interval.
+func (p *Pool) SetHighWaterMark(n int) {
+	atomic.StoreInt64(&p.highWater, int64(n))
+}
+
+// SetPrefix sets the prefix of all keys.  It must be called before the
+// pool is used, use WithPrefix for prefixes that differ by subsystem.
 func (p *Pool) SetPrefix(prefix string) {
 	p.prefix = prefix
 }
@@ -192,19 +628,78 @@ func (p *Pool) SetDevLogger(l *log.Logger) {
 	p.devlogger = l
 }
 
-func (p *Pool) Stop() {
-	p.flushing.Add(1)
-	p.stop <- struct{}{}
-	p.flushing.Wait()
+// Stop flushes and stops the pool, returning any error sending the
+// final flush.  Stats that failed are spooled, re-queued or logged
+// as usual.  Stats still held, e.g. while paused or throttled, are
+// saved or dropped and returned as chunks of a FlushError wrapping
+// ErrStoppedUnsent.
+func (p *Pool) Stop() error {
+	return p.StopContext(context.Background())
+}
+
+// Flush sends everything aggregated so far, returning any error
+// sending it.
+func (p *Pool) Flush() error {
+	return p.FlushContext(context.Background())
 }
 
-func (p *Pool) Flush() {
+// StopContext flushes and stops the pool like Stop.  If ctx is done
+// first, requests still in flight are aborted and ctx.Err() returned;
+// the stats they carried are spooled, re-queued or logged as for any
+// other failed send.
+func (p *Pool) StopContext(ctx context.Context) error {
+	return p.signal(ctx, p.stop)
+}
+
+// FlushContext flushes like Flush, giving up when ctx is done the way
+// StopContext does.
+func (p *Pool) FlushContext(ctx context.Context) error {
+	return p.signal(ctx, p.flush)
+}
+
+// flushRequest asks the running goroutine to flush
+type flushRequest struct {
+	ctx context.Context
+	err chan error
+}
+
+// signal sends a flush request on c and waits for it, and any
+// flushes already in progress, to complete
+func (p *Pool) signal(ctx context.Context, c chan flushRequest) error {
+	p.ensureRunning()
 	p.flushing.Add(1)
-	p.flush <- struct{}{}
-	p.flushing.Wait()
+	req := flushRequest{ctx: ctx, err: make(chan error, 1)}
+	if p.sched != nil {
+		if err := p.sched.signal(ctx, p, c == p.stop, req); err != nil {
+			p.flushing.Done()
+			return err
+		}
+	} else {
+		select {
+		case c <- req:
+		case <-ctx.Done():
+			p.flushing.Done()
+			return ctx.Err()
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		p.flushing.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return <-req.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-func (p *Pool) doflush(values []interface{}) error {
+func (p *Pool) doflush(ctx context.Context, values []interface{}) error {
 
 	var start time.Time
 	if p.devlogger != nil {
@@ -213,82 +708,182 @@ func (p *Pool) doflush(values []interface{}) error {
 		defer func() { p.devlogger.Printf("flush completed in %s", time.Since(start)) }()
 	}
 
-	// if no work just return
-	if len(values) == 0 {
-		return nil
-	}
+	// start counting keys for the next interval
+	p.resetCardinality()
 
 	// set the flush time as the aggregated count time
-	now := time.Now().Unix()
+	now := p.now().Unix()
 	for _, val := range values {
-		if count, ok := val.(*CountStat); ok {
+		if count, ok := val.(*CountStat); ok && count.Timestamp == 0 {
 			count.Timestamp = now
 		}
 	}
 
-	// chunk the sends to ensure data size is not excessive
-	var chunks [][]interface{}
-	for len(values) > chunkSize {
-		chunks = append(chunks, values[:chunkSize])
-		values = values[chunkSize:]
+	// rename and drop keys by the relabel rules
+	values = p.relabel(values)
+	values = p.addInstance(values)
+
+	// aggregates of finite samples can still overflow
+	values = p.finite(values)
+
+	// read back the spool, and a budget of what spilled over the
+	// memory limit, only when it can be sent so it stays on disk
+	// meanwhile
+	held := p.takeHeld()
+	if !p.Paused() && p.throttled() <= 0 {
+		held = append(p.takeOverflow(), held...)
+		if p.breaker.closed(p.now()) {
+			held = append(held, p.takeSpooled()...)
+		}
 	}
-	chunks = append(chunks, values)
 
-	errs := make(chan error, len(chunks))
+	// drop anything past its max age before sending
+	values, held = p.dropStale(values, held, p.now())
+
+	// chunk the sends to ensure data size is not excessive, with
+	// separate payloads for stats sent under other ez keys
+	var batches []*batch
+	for _, group := range groupByEZKey(values) {
+		for _, chunk := range p.chunk(group.stats, p.ezKeyOr(group.ezKey)) {
+			batches = append(batches, &batch{id: newIdempotencyKey(), ezKey: group.ezKey, stats: chunk, created: p.now()})
+		}
+	}
 
-	for _, chunk := range chunks {
-		go p.send(chunk, errs)
+	// hold everything while paused
+	batches = append(held, batches...)
+	if p.Paused() {
+		p.hold(batches...)
+		return nil
 	}
 
-	// toss back the first error for now... :/
-	for i := 0; i < len(chunks); i++ {
-		if err := <-errs; err != nil {
-			return err
+	// or while the endpoint is throttling us
+	if wait := p.throttled(); wait > 0 {
+		p.hold(batches...)
+		if p.devlogger != nil {
+			p.devlogger.Printf("throttled, holding stats for %s", wait)
 		}
+		return nil
 	}
 
-	return nil
+	// if no work just return
+	if len(batches) == 0 {
+		return nil
+	}
 
-}
+	// don't pile up requests to an endpoint that's down
+	if !p.breaker.allow(p.now()) {
+		for _, b := range batches {
+			if !p.spoolBatch(b) {
+				p.hold(b)
+			}
+		}
+		return ErrCircuitOpen
+	}
 
-func (p *Pool) send(chunk []interface{}, errs chan error) {
+	sending := time.Now()
+	defer func() { p.flushed(time.Since(sending)) }()
 
-	buf := &bytes.Buffer{}
-	if err := json.NewEncoder(buf).Encode(&statPayload{
-		EZKey: p.ezKey,
-		Data:  chunk,
-	}); err != nil {
-		errs <- err
+	errs := make(chan *ChunkError, len(batches))
+
+	for _, b := range batches {
+		go p.send(ctx, b, errs)
 	}
 
-	req, err := http.NewRequest("POST", p.url, buf)
-	if err != nil {
-		errs <- err
+	// wait for every chunk, collecting those that failed
+	var failed []*ChunkError
+	for i := 0; i < len(batches); i++ {
+		if err := <-errs; err != nil {
+			failed = append(failed, err)
+		}
 	}
 
-	req.Header.Set("Content-Type", "application/json")
+	p.breaker.result(len(failed) == len(batches), p.now())
 
-	resp, err := p.client.Do(req)
-	if err != nil {
-		p.log.Println("unprocessed aggregate:", buf.String())
-		errs <- err
+	if len(failed) > 0 {
+		return &FlushError{Chunks: failed, Total: len(batches)}
 	}
-	defer resp.Body.Close()
+	return nil
 
-	if resp.StatusCode != http.StatusOK {
-		p.log.Println("unprocessed aggregate:", buf.String())
-		errs <- fmt.Errorf("Received http status code: %d", resp.StatusCode)
+}
+
+func (p *Pool) send(ctx context.Context, b *batch, errs chan *ChunkError) {
+	if err := p.acquireSend(ctx); err != nil {
+		p.unsent(err, b, 0)
+		errs <- &ChunkError{ID: b.id, Stats: len(b.stats), Err: err}
+		return
+	}
+	defer p.releaseSend()
+	if p.sched != nil {
+		if err := p.sched.acquire(ctx); err != nil {
+			p.unsent(err, b, 0)
+			errs <- &ChunkError{ID: b.id, Stats: len(b.stats), Err: err}
+			return
+		}
+		defer p.sched.release()
 	}
+	if err := p.postWithRetry(ctx, b); err != nil {
+		errs <- &ChunkError{ID: b.id, Stats: len(b.stats), Err: err}
+		return
+	}
+	p.recoveredSent(b.id)
+	errs <- nil
+}
 
-	var sresp statResponse
-	if err := json.NewDecoder(resp.Body).Decode(&sresp); err != nil {
-		errs <- err
+// OnError routes errors to fn instead of the logger.  droppedStats is
+// how many stats were lost to the error, zero if they'll be resent.
+// It must be set before the pool is used.
+func (p *Pool) OnError(fn func(err error, droppedStats int)) {
+	p.onError = fn
+}
+
+// report passes err to the error callback, or logs it
+func (p *Pool) report(err error, dropped int) {
+	if p.onError != nil {
+		p.onError(err, dropped)
+		return
 	}
+	p.log.Println(err)
+}
 
-	if sresp.Status != http.StatusOK {
-		errs <- fmt.Errorf("%d : %s", sresp.Status, sresp.Message)
+// logUnprocessed logs stats that couldn't be sent, unless errors
+// are going to a callback
+func (p *Pool) logUnprocessed(b *batch) {
+	if p.onError != nil {
+		return
 	}
+	buf := &bytes.Buffer{}
+	encodePayload(buf, p.ezKeyOr(b.ezKey), b.stats, false)
+	p.log.Println("unprocessed aggregate:", buf.String())
+}
 
-	errs <- nil
+// SetBinaryEncoding sends payloads in the compact binary encoding
+// understood by relays.  Endpoints that respond with 415 Unsupported
+// Media Type are sent json instead.
+func (p *Pool) SetBinaryEncoding(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&p.binary, v)
+}
+
+// SetStreaming encodes json payloads as they are sent rather than
+// building each in memory first, lowering peak memory when flushing
+// large chunks.  The binary encoding isn't streamed.
+func (p *Pool) SetStreaming(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&p.streaming, v)
+}
 
+// SetGzip compresses payloads with gzip.  Endpoints that respond with
+// 415 Unsupported Media Type are sent uncompressed payloads instead.
+func (p *Pool) SetGzip(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&p.gzip, v)
 }
@@ -1,12 +1,28 @@
 package statpool
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"io"
 	"io/ioutil"
 	"log"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
 
 	"testing"
 	"time"
@@ -48,6 +64,7 @@ func TestStatPool(t *testing.T) {
 	stats.Count("darts", 4)
 	stats.Value("players", 2, time.Now())
 	stats.Duration("quickest time", time.Millisecond)
+	stats.DurationSince("since time", time.Now())
 	stats.SampledDuration("sampled time", time.Millisecond, 1)
 
 	time.Sleep(200 * time.Millisecond)
@@ -63,22 +80,22 @@ func TestStatPool(t *testing.T) {
 		t.Errorf("Expected: %q, got: %q", EZKey, p.EZKey)
 	}
 
-	if len(p.Data) != 3 {
-		t.Errorf("Expected: 3 stats, got: %d", len(p.Data))
+	if len(p.Data) != 5 {
+		t.Errorf("Expected: 5 stats, got: %d", len(p.Data))
 	}
 
 	for _, stat := range p.Data {
 		switch stat.Key {
 		case "prefix:darts":
 			if stat.Count != 7 {
-				t.Errorf("Expected: 7, got: %d", stat.Count)
+				t.Errorf("Expected: 7, got: %g", stat.Count)
 			}
 			if stat.Timestamp == 0 {
 				t.Errorf("Did not get a valid timestamp")
 			}
 		case "prefix:players":
 			if stat.Value != 2 {
-				t.Errorf("Expected: 2, got: %d", stat.Value)
+				t.Errorf("Expected: 2, got: %g", stat.Value)
 			}
 			if stat.Timestamp == 0 {
 				t.Errorf("Did not get a valid timestamp")
@@ -86,7 +103,7 @@ func TestStatPool(t *testing.T) {
 		case "prefix:quickest time",
 			"prefix:sampled time":
 			if stat.Value != 1 {
-				t.Errorf("Expected: 1, got: %d", stat.Value)
+				t.Errorf("Expected: 1, got: %g", stat.Value)
 			}
 		}
 	}
@@ -97,8 +114,3315 @@ func TestNilPool(t *testing.T) {
 
 	stat := NewNilPool()
 	stat.Count("key", 1)
+	stat.Inc("key")
+	stat.Dec("key")
+	stat.Add("key", 2)
 	stat.Value("key", 1, time.Now())
 	stat.Duration("key", time.Second)
+	stat.DurationSince("key", time.Now())
+	stat.CountWithTags("key", 1, T("tag", "value"))
+	stat.Gauge("key", 1)
+	stat.Histogram("key", 1)
+	stat.Time("key")()
+	stat.Timer("key").Stop()
 	stat.SampledDuration("key", time.Second, 1)
 
 }
+
+func TestCumulativeCounts(t *testing.T) {
+
+	stats := NewPool(ts.URL, EZKey, time.Hour)
+	stats.SetKeyOptions("total", KeyOptions{Cumulative: true})
+	defer stats.Stop()
+
+	for _, expected := range []float64{3, 6} {
+		stats.Count("total", 1)
+		stats.Count("total", 2)
+		time.Sleep(10 * time.Millisecond)
+		stats.Flush()
+
+		var p Payload
+		if err := json.Unmarshal(<-reqs, &p); err != nil {
+			t.Fatal(err)
+		}
+		if len(p.Data) != 1 || p.Data[0].Count != expected {
+			t.Errorf("Expected: %g, got: %+v", expected, p.Data)
+		}
+	}
+
+}
+
+func TestValuePolicy(t *testing.T) {
+
+	stats := NewPool(ts.URL, EZKey, time.Hour)
+	stats.SetKeyOptions("clamped", KeyOptions{Validate: ClampNegative})
+	stats.SetKeyOptions("rejected", KeyOptions{Validate: RejectNegative})
+	defer stats.Stop()
+
+	stats.Value("clamped", -1, time.Now())
+	stats.Value("rejected", -1, time.Now())
+	time.Sleep(10 * time.Millisecond)
+	stats.Flush()
+
+	var p Payload
+	if err := json.Unmarshal(<-reqs, &p); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Data) != 2 {
+		t.Fatalf("Expected: 2 stats, got: %+v", p.Data)
+	}
+	for _, stat := range p.Data {
+		switch stat.Key {
+		case "clamped":
+			if stat.Value != 0 {
+				t.Errorf("Expected: 0, got: %g", stat.Value)
+			}
+		case rejectedValuesKey:
+			if stat.Count != 1 {
+				t.Errorf("Expected: 1, got: %g", stat.Count)
+			}
+		default:
+			t.Errorf("Unexpected stat: %+v", stat)
+		}
+	}
+
+}
+
+func TestNonFinitePolicy(t *testing.T) {
+
+	stats := NewPool(ts.URL, EZKey, time.Hour)
+	stats.SetNonFinitePolicy(ReplaceNonFinite)
+	defer stats.Stop()
+
+	stats.Value("nan", math.NaN(), time.Now())
+	stats.Value("inf", math.Inf(1), time.Now())
+	stats.SetNonFinitePolicy(DropNonFinite)
+	stats.Count("dropped", math.Inf(-1))
+	time.Sleep(10 * time.Millisecond)
+	stats.Flush()
+
+	var p Payload
+	if err := json.Unmarshal(<-reqs, &p); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Data) != 3 {
+		t.Fatalf("Expected: 3 stats, got: %+v", p.Data)
+	}
+	for _, stat := range p.Data {
+		switch stat.Key {
+		case "nan":
+			if stat.Value != 0 {
+				t.Errorf("Expected: 0, got: %g", stat.Value)
+			}
+		case "inf":
+			if stat.Value != math.MaxFloat64 {
+				t.Errorf("Expected: %g, got: %g", math.MaxFloat64, stat.Value)
+			}
+		case nonFiniteKey:
+			if stat.Count != 3 {
+				t.Errorf("Expected: 3, got: %g", stat.Count)
+			}
+		default:
+			t.Errorf("Unexpected stat: %+v", stat)
+		}
+	}
+
+}
+
+func TestNonFiniteAggregates(t *testing.T) {
+
+	stats := NewPool(ts.URL, EZKey, time.Hour)
+	stats.SetNonFinitePolicy(ReplaceNonFinite)
+
+	// the replaced samples sum back to +Inf
+	stats.Count("inf", math.Inf(1))
+	stats.Count("inf", math.Inf(1))
+	stats.Count("darts", 1)
+	time.Sleep(10 * time.Millisecond)
+	stats.Flush()
+
+	var p Payload
+	if err := json.Unmarshal(<-reqs, &p); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]float64{}
+	for _, stat := range p.Data {
+		got[stat.Key] = stat.Count
+	}
+	if got["inf"] != math.MaxFloat64 || got["darts"] != 1 || got[nonFiniteKey] != 2 {
+		t.Errorf("Expected inf: %g, darts: 1 and 2 filtered, got: %v", math.MaxFloat64, got)
+	}
+
+	// the overflow is counted with the next flush
+	go stats.Stop()
+	if err := json.Unmarshal(<-reqs, &p); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Data) != 1 || p.Data[0].Key != nonFiniteKey || p.Data[0].Count != 1 {
+		t.Errorf("Expected %s: 1, got: %+v", nonFiniteKey, p.Data)
+	}
+
+}
+
+func TestMaxSamples(t *testing.T) {
+
+	stats := NewPool(ts.URL, EZKey, time.Hour)
+	stats.SetKeyOptions("capped", KeyOptions{MaxSamples: 10})
+	defer stats.Stop()
+
+	for i := 0; i < 100; i++ {
+		stats.Value("capped", float64(i), time.Now())
+	}
+	time.Sleep(10 * time.Millisecond)
+	stats.Flush()
+
+	var p Payload
+	if err := json.Unmarshal(<-reqs, &p); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Data) != 10 {
+		t.Errorf("Expected: 10 stats, got: %d", len(p.Data))
+	}
+
+}
+
+func TestSummarize(t *testing.T) {
+
+	stats := NewPool(ts.URL, EZKey, time.Hour)
+	stats.SetKeyOptions("latency", KeyOptions{Summarize: true, Quantiles: []float64{0.5, 0.999}})
+	defer stats.Stop()
+
+	for i := 1; i <= 100; i++ {
+		stats.Value("latency", float64(i), time.Now())
+	}
+	time.Sleep(10 * time.Millisecond)
+	stats.Flush()
+
+	var p Payload
+	if err := json.Unmarshal(<-reqs, &p); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]float64{
+		"latency.count": 100,
+		"latency.sum":   5050,
+		"latency.min":   1,
+		"latency.max":   100,
+		"latency.p50":   50,
+		"latency.p99.9": 100,
+	}
+	if len(p.Data) != len(expected) {
+		t.Errorf("Expected: %d stats, got: %d", len(expected), len(p.Data))
+	}
+	for _, stat := range p.Data {
+		if stat.Value != expected[stat.Key] {
+			t.Errorf("%s expected: %g, got: %g", stat.Key, expected[stat.Key], stat.Value)
+		}
+	}
+
+}
+
+func TestSummarizeQuantileBounds(t *testing.T) {
+
+	stats := NewPool(ts.URL, EZKey, time.Hour)
+	for _, q := range []float64{-0.1, 1.5, math.NaN()} {
+		if err := stats.SetKeyOptions("latency", KeyOptions{Summarize: true, Quantiles: []float64{0.5, q}}); err == nil {
+			t.Errorf("Expected an error for quantile: %g", q)
+		}
+	}
+	if opts := stats.keyOptions("latency"); opts.Summarize {
+		t.Errorf("Expected options left unset, got: %+v", opts)
+	}
+
+	// the index is clamped for quantiles set without validation
+	r := newKeyReservoir(KeyOptions{Summarize: true, Quantiles: []float64{1.5}}, false)
+	r.add(&ValueStat{Value: 7})
+	stat := r.summary("latency", 0)[4].(*ValueStat)
+	if stat.Key != "latency.p150" || stat.Value != 7 {
+		t.Errorf("Expected latency.p150: 7, got: %+v", stat)
+	}
+
+}
+
+func TestBinaryEncoding(t *testing.T) {
+
+	var (
+		reject  int32
+		types   = make(chan string, 3)
+		decoded = make(chan []interface{}, 2)
+		relay   = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			ct := req.Header.Get("Content-Type")
+			types <- ct
+			if ct == ContentTypeBinary && atomic.LoadInt32(&reject) == 1 {
+				w.WriteHeader(http.StatusUnsupportedMediaType)
+				return
+			}
+			_, stats, err := DecodePayload(ct, req.Body)
+			if err != nil {
+				t.Error(err)
+			}
+			decoded <- stats
+			json.NewEncoder(w).Encode(&statResponse{Status: http.StatusOK})
+		}))
+	)
+	defer relay.Close()
+
+	stats := NewPool(relay.URL, EZKey, time.Hour)
+	stats.SetBinaryEncoding(true)
+	defer stats.Stop()
+
+	stats.Count("darts", 2)
+	stats.Value("players", 3, time.Now())
+	time.Sleep(10 * time.Millisecond)
+	stats.Flush()
+
+	if ct := <-types; ct != ContentTypeBinary {
+		t.Errorf("Expected: %q, got: %q", ContentTypeBinary, ct)
+	}
+	if got := <-decoded; len(got) != 2 || got[0].(*CountStat).Count != 2 || got[1].(*ValueStat).Value != 3 {
+		t.Errorf("Unexpected stats: %+v", got)
+	}
+
+	// rejected binary requests are retried as json
+	atomic.StoreInt32(&reject, 1)
+	stats.Count("darts", 1)
+	time.Sleep(10 * time.Millisecond)
+	stats.Flush()
+
+	if ct := <-types; ct != ContentTypeBinary {
+		t.Errorf("Expected: %q, got: %q", ContentTypeBinary, ct)
+	}
+	if ct := <-types; ct != ContentTypeJSON {
+		t.Errorf("Expected: %q, got: %q", ContentTypeJSON, ct)
+	}
+	if got := <-decoded; len(got) != 1 || got[0].(*CountStat).Count != 1 {
+		t.Errorf("Unexpected stats: %+v", got)
+	}
+
+}
+
+func TestSubscribe(t *testing.T) {
+
+	stats := NewPool(ts.URL, EZKey, time.Hour)
+	stats.SetPrefix("prefix:")
+
+	sub, cancel := stats.Subscribe()
+	stats.Count("darts", 2)
+	stats.Duration("quickest time", time.Second)
+	cancel()
+	cancel()
+
+	var got []Stat
+	for stat := range sub {
+		got = append(got, stat)
+	}
+	if len(got) != 2 {
+		t.Fatalf("Expected: 2 stats, got: %+v", got)
+	}
+	if got[0].Key != "prefix:darts" || got[0].Type != CountType || got[0].Value != 2 {
+		t.Errorf("Unexpected stat: %+v", got[0])
+	}
+	if got[1].Key != "prefix:quickest time" || got[1].Type != ValueType || got[1].Value != 1000 {
+		t.Errorf("Unexpected stat: %+v", got[1])
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	stats.Stop()
+	<-reqs
+
+}
+
+func TestRepeatLast(t *testing.T) {
+
+	stats := NewPool(ts.URL, EZKey, time.Hour)
+	stats.SetKeyOptions("depth", KeyOptions{RepeatLast: true})
+
+	stats.Value("depth", 5, time.Now())
+	time.Sleep(10 * time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		stats.Flush()
+
+		var p Payload
+		if err := json.Unmarshal(<-reqs, &p); err != nil {
+			t.Fatal(err)
+		}
+		if len(p.Data) != 1 || p.Data[0].Value != 5 || p.Data[0].Timestamp == 0 {
+			t.Errorf("Unexpected stats: %+v", p.Data)
+		}
+	}
+
+	// repeated on the final flush too
+	stats.Stop()
+	<-reqs
+
+}
+
+func TestRetryAfter(t *testing.T) {
+
+	var (
+		requests int32
+		bodies   = make(chan []byte, 2)
+		endpoint = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			data, _ := ioutil.ReadAll(req.Body)
+			if atomic.AddInt32(&requests, 1) == 1 {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			bodies <- data
+			json.NewEncoder(w).Encode(&statResponse{Status: http.StatusOK})
+		}))
+	)
+	defer endpoint.Close()
+
+	stats := NewPool(endpoint.URL, EZKey, time.Hour)
+	defer stats.Stop()
+
+	stats.Count("darts", 1)
+	time.Sleep(10 * time.Millisecond)
+	stats.Flush()
+
+	// held while throttled
+	stats.Count("darts", 2)
+	time.Sleep(10 * time.Millisecond)
+	stats.Flush()
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("Expected: 1 request while throttled, got: %d", n)
+	}
+
+	time.Sleep(time.Second)
+	stats.Flush()
+
+	// held and new batches are sent concurrently
+	got := map[float64]bool{}
+	for i := 0; i < 2; i++ {
+		var p Payload
+		if err := json.Unmarshal(<-bodies, &p); err != nil {
+			t.Fatal(err)
+		}
+		for _, stat := range p.Data {
+			got[stat.Count] = true
+		}
+	}
+	if len(got) != 2 || !got[1] || !got[2] {
+		t.Errorf("Expected counts 1 and 2, got: %v", got)
+	}
+
+}
+
+func TestRetryAfterUnavailable(t *testing.T) {
+
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer endpoint.Close()
+
+	stats := NewPoolWithOptions(WithEndpoint(endpoint.URL), WithEZKey(EZKey), WithFlushInterval(time.Hour), WithHoldLimit(2), WithOnError(func(error, int) {}))
+	defer stats.Stop()
+
+	stats.Count("darts", 1)
+	time.Sleep(10 * time.Millisecond)
+	stats.Flush()
+
+	s := stats.Stats()
+	if !s.Paused || time.Until(s.PausedUntil) < 50*time.Second || s.Held != 1 {
+		t.Errorf("Expected a 60s pause holding 1 stat, got: %+v", s)
+	}
+
+	// held up to the limit while paused
+	stats.Count("darts", 1)
+	stats.Count("misses", 1)
+	time.Sleep(10 * time.Millisecond)
+	stats.Flush()
+	if s := stats.Stats(); s.Held != 2 || s.Errors != 1 {
+		t.Errorf("Expected 2 stats held after 1 request, got: %+v", s)
+	}
+
+}
+
+func TestScheduler(t *testing.T) {
+
+	sched := NewScheduler(50*time.Millisecond, 1)
+	defer sched.Stop()
+
+	a := sched.NewPool(ts.URL, EZKey)
+	b := sched.NewPool(ts.URL, EZKey)
+	a.Count("a", 1)
+	b.Count("b", 1)
+
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		var p Payload
+		if err := json.Unmarshal(<-reqs, &p); err != nil {
+			t.Fatal(err)
+		}
+		for _, stat := range p.Data {
+			got[stat.Key] = true
+		}
+	}
+	if !got["a"] || !got["b"] {
+		t.Errorf("Expected stats from both pools, got: %v", got)
+	}
+
+	a.Stop()
+	b.Stop()
+	if n := len(sched.pools); n != 0 {
+		t.Errorf("Expected: 0 scheduled pools, got: %d", n)
+	}
+
+}
+
+func TestSchedulerGoroutines(t *testing.T) {
+
+	sched := NewScheduler(time.Hour, 1)
+	defer sched.Stop()
+
+	// pools don't start goroutines of their own
+	before := runtime.NumGoroutine()
+	pools := make([]*Pool, 20)
+	for i := range pools {
+		pools[i] = sched.NewPool(ts.URL, EZKey)
+		pools[i].Count("darts", 1)
+	}
+	if n := runtime.NumGoroutine() - before; n >= len(pools) {
+		t.Errorf("Expected fewer goroutines than pools, got: %d more", n)
+	}
+
+	// the scheduler flushes each pool
+	go func() {
+		for _, p := range pools {
+			p.Stop()
+		}
+	}()
+	for range pools {
+		var p Payload
+		if err := json.Unmarshal(<-reqs, &p); err != nil {
+			t.Fatal(err)
+		}
+		if len(p.Data) != 1 || p.Data[0].Key != "darts" || p.Data[0].Count != 1 {
+			t.Errorf("Unexpected payload: %+v", p)
+		}
+	}
+
+}
+
+func TestSchedulerWorkersContext(t *testing.T) {
+
+	var (
+		sched   = NewScheduler(time.Hour, 1)
+		stats   = sched.NewPool(ts.URL, EZKey)
+		dropped int64
+	)
+	defer sched.Stop()
+	stats.OnError(func(err error, n int) { atomic.AddInt64(&dropped, int64(n)) })
+
+	// a send waiting for a worker gives up with its context
+	sched.workers <- struct{}{}
+	defer sched.release()
+	stats.Count("darts", 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := stats.StopContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected: %v, got: %v", context.DeadlineExceeded, err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Expected the stop to give up with its context, took: %s", d)
+	}
+
+	for i := 0; atomic.LoadInt64(&dropped) != 1 && i < 100; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if n := atomic.LoadInt64(&dropped); n != 1 {
+		t.Errorf("Expected: 1 dropped stat, got: %d", n)
+	}
+
+}
+
+func TestTimeCaller(t *testing.T) {
+
+	stats := NewPool(ts.URL, EZKey, time.Hour)
+	sub, cancel := stats.Subscribe()
+
+	func() {
+		defer stats.TimeCaller(time.Now())
+	}()
+	stats.TimeCaller(time.Now())
+	cancel()
+
+	for _, expected := range []string{"statpool.TestTimeCaller.func1", "statpool.TestTimeCaller"} {
+		if stat := <-sub; stat.Key != expected {
+			t.Errorf("Expected: %q, got: %q", expected, stat.Key)
+		}
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	stats.Stop()
+	<-reqs
+
+}
+
+func TestEnforceSchema(t *testing.T) {
+
+	schema := NewSchema()
+	schema.Describe(Description{Key: "darts", Type: CountMetric})
+
+	stats := NewPool(ts.URL, EZKey, time.Hour)
+	stats.SetPrefix("prefix:")
+	stats.EnforceSchema(schema, FoldUndeclared)
+
+	stats.Count("darts", 1)
+	stats.Count("drats", 1)
+	stats.Count("dartz", 1)
+	time.Sleep(10 * time.Millisecond)
+	stats.Stop()
+
+	var p Payload
+	if err := json.Unmarshal(<-reqs, &p); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]float64{
+		"prefix:darts":                1,
+		"prefix:" + UndeclaredKey:     2,
+		"prefix:" + undeclaredKeysKey: 2,
+	}
+	if len(p.Data) != len(expected) {
+		t.Errorf("Expected: %d stats, got: %+v", len(expected), p.Data)
+	}
+	for _, stat := range p.Data {
+		if stat.Count != expected[stat.Key] {
+			t.Errorf("%s expected: %g, got: %g", stat.Key, expected[stat.Key], stat.Count)
+		}
+	}
+
+}
+
+func TestRecentStats(t *testing.T) {
+
+	stats := NewPool(ts.URL, EZKey, time.Hour)
+	stats.SetRecentStats(2)
+	stats.SetKeyOptions("depth", KeyOptions{Validate: RejectNegative})
+
+	stats.Count("darts", 1)
+	stats.Value("depth", -1, time.Now())
+	stats.Duration("quickest time", time.Millisecond)
+
+	recent := stats.RecentStats()
+	if len(recent) != 2 {
+		t.Fatalf("Expected: 2 stats, got: %+v", recent)
+	}
+	if recent[0].Key != "depth" || recent[0].Outcome != "dropped: failed validation" {
+		t.Errorf("Unexpected stat: %+v", recent[0])
+	}
+	if recent[1].Key != "quickest time" || recent[1].Outcome != "accepted" {
+		t.Errorf("Unexpected stat: %+v", recent[1])
+	}
+	if !strings.Contains(recent[1].Source, "statpool_test.go:") {
+		t.Errorf("Expected source in statpool_test.go, got: %q", recent[1].Source)
+	}
+
+	w := httptest.NewRecorder()
+	stats.DebugHandler().ServeHTTP(w, httptest.NewRequest("GET", "/?key=depth", nil))
+	var dumped []RecentStat
+	if err := json.Unmarshal(w.Body.Bytes(), &dumped); err != nil {
+		t.Fatal(err)
+	}
+	if len(dumped) != 1 || dumped[0].Key != "depth" {
+		t.Errorf("Unexpected dump: %+v", dumped)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	stats.Stop()
+	<-reqs
+
+}
+
+func TestGenerateLoad(t *testing.T) {
+
+	recorder := &countingStater{}
+	result := GenerateLoad(recorder, LoadConfig{Keys: 10, Rate: 2000, Duration: 100 * time.Millisecond, Workers: 2})
+
+	if total := result.Total(); total != 200 {
+		t.Errorf("Expected: 200 stats, got: %d", total)
+	}
+	if n := atomic.LoadInt64(&recorder.n); n != result.Total() {
+		t.Errorf("Expected: %d stats emitted, got: %d", result.Total(), n)
+	}
+
+}
+
+type countingStater struct{ n int64 }
+
+func (c *countingStater) Count(string, float64)            { atomic.AddInt64(&c.n, 1) }
+func (c *countingStater) Value(string, float64, time.Time) { atomic.AddInt64(&c.n, 1) }
+func (c *countingStater) Duration(string, time.Duration)   { atomic.AddInt64(&c.n, 1) }
+
+func TestEndpointFailover(t *testing.T) {
+
+	var (
+		primaryHits int32
+		primary     = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			atomic.AddInt32(&primaryHits, 1)
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+	)
+	defer primary.Close()
+
+	stats := NewPool(primary.URL, EZKey, time.Hour)
+	stats.SetEndpoints(primary.URL, ts.URL)
+
+	for i := 0; i < 2; i++ {
+		stats.Count("darts", 1)
+		time.Sleep(10 * time.Millisecond)
+		stats.Flush()
+
+		var p Payload
+		if err := json.Unmarshal(<-reqs, &p); err != nil {
+			t.Fatal(err)
+		}
+		if len(p.Data) != 1 || p.Data[0].Count != 1 {
+			t.Errorf("Unexpected stats: %+v", p.Data)
+		}
+	}
+
+	// the primary is skipped once it is marked down
+	if n := atomic.LoadInt32(&primaryHits); n != 1 {
+		t.Errorf("Expected: 1 request to the primary, got: %d", n)
+	}
+
+	stats.Stop()
+
+}
+
+func TestEmptyEndpoints(t *testing.T) {
+
+	// an empty list keeps the current endpoints rather than leaving
+	// nowhere to send
+	for _, stats := range []*Pool{
+		NewPoolWithOptions(WithEndpoint(ts.URL), WithEndpoint(), WithEZKey(EZKey), WithFlushInterval(time.Hour)),
+		NewPool(ts.URL, EZKey, time.Hour),
+	} {
+		stats.SetEndpoints()
+		stats.Count("darts", 1)
+		time.Sleep(10 * time.Millisecond)
+		go stats.Flush()
+
+		var p Payload
+		if err := json.Unmarshal(<-reqs, &p); err != nil {
+			t.Fatal(err)
+		}
+		if len(p.Data) != 1 || p.Data[0].Count != 1 {
+			t.Errorf("Unexpected stats: %+v", p.Data)
+		}
+		if err := stats.Stop(); err != nil {
+			t.Error(err)
+		}
+	}
+
+}
+
+func TestHealthCheck(t *testing.T) {
+
+	var (
+		recovered   int32
+		primaryHits int32
+		primary     = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if atomic.LoadInt32(&recovered) == 0 {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			if req.URL.Path == "/health" {
+				return
+			}
+			atomic.AddInt32(&primaryHits, 1)
+			ts.Config.Handler.ServeHTTP(w, req)
+		}))
+	)
+	defer primary.Close()
+
+	stats := NewPoolWithOptions(
+		WithEZKey(EZKey),
+		WithFlushInterval(time.Hour),
+		WithEndpoint(primary.URL, ts.URL),
+		WithHealthCheck("/health", time.Millisecond),
+	)
+
+	flush := func() {
+		stats.Count("darts", 1)
+		stats.Flush()
+		var p Payload
+		if err := json.Unmarshal(<-reqs, &p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// fails over to the second endpoint, and the primary stays down
+	// while its health check fails
+	flush()
+	time.Sleep(10 * time.Millisecond)
+	flush()
+	time.Sleep(10 * time.Millisecond)
+	if n := atomic.LoadInt32(&primaryHits); n != 0 {
+		t.Errorf("Expected: no stats sent to the primary, got: %d", n)
+	}
+
+	// a passing health check returns sends to the primary
+	atomic.StoreInt32(&recovered, 1)
+	stats.endpointOrder()
+	time.Sleep(10 * time.Millisecond)
+	flush()
+	if n := atomic.LoadInt32(&primaryHits); n != 1 {
+		t.Errorf("Expected: 1 flush sent to the primary, got: %d", n)
+	}
+
+	stats.Stop()
+
+}
+
+func TestMaxAge(t *testing.T) {
+
+	stats := NewPool(ts.URL, EZKey, time.Hour)
+	stats.SetMaxAge(time.Minute)
+
+	stats.Value("players", 1, time.Now().Add(-time.Hour))
+	stats.Value("players", 2, time.Now())
+	time.Sleep(10 * time.Millisecond)
+	stats.Flush()
+
+	var p Payload
+	if err := json.Unmarshal(<-reqs, &p); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Data) != 1 || p.Data[0].Value != 2 {
+		t.Errorf("Unexpected stats: %+v", p.Data)
+	}
+
+	// the drop is reported in the next flush
+	time.Sleep(10 * time.Millisecond)
+	stats.Stop()
+	if err := json.Unmarshal(<-reqs, &p); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Data) != 1 || p.Data[0].Key != staleKey || p.Data[0].Count != 1 {
+		t.Errorf("Unexpected stats: %+v", p.Data)
+	}
+
+}
+
+func TestIdleShutdown(t *testing.T) {
+
+	stats := NewPool(ts.URL, EZKey, 10*time.Millisecond)
+	stats.SetIdleShutdown(2)
+
+	if atomic.LoadInt32(&stats.running) != 0 {
+		t.Fatal("Expected the pool not to start before the first stat")
+	}
+
+	stats.Count("darts", 1)
+	<-reqs
+
+	time.Sleep(50 * time.Millisecond)
+	if atomic.LoadInt32(&stats.running) != 0 {
+		t.Fatal("Expected the pool to park after idle intervals")
+	}
+
+	stats.Count("darts", 2)
+	var p Payload
+	if err := json.Unmarshal(<-reqs, &p); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Data) != 1 || p.Data[0].Count != 2 {
+		t.Errorf("Unexpected stats: %+v", p.Data)
+	}
+
+	stats.Stop()
+
+}
+
+func TestTags(t *testing.T) {
+
+	stats := NewPool(ts.URL, EZKey, time.Hour)
+	stats.SetPrefix("prefix:")
+
+	stats.CountWithTags("requests", 1, T("status", "200"), T("host", "web1"))
+	stats.CountWithTags("requests", 2, T("host", "web1"), T("status", "200"))
+	stats.CountWithTags("requests", 4, T("host", "web1"), T("status", "500"))
+	stats.SetTagSeparator(";")
+	stats.DurationWithTags("latency", time.Millisecond, T("host", "web1"))
+	time.Sleep(10 * time.Millisecond)
+	stats.Stop()
+
+	var p Payload
+	if err := json.Unmarshal(<-reqs, &p); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]float64{
+		"prefix:requests.host=web1.status=200": 3,
+		"prefix:requests.host=web1.status=500": 4,
+		"prefix:latency;host=web1":             1,
+	}
+	if len(p.Data) != len(expected) {
+		t.Errorf("Expected: %d stats, got: %+v", len(expected), p.Data)
+	}
+	for _, stat := range p.Data {
+		if v, ok := expected[stat.Key]; !ok || stat.Count+stat.Value != v {
+			t.Errorf("Unexpected stat: %+v", stat)
+		}
+	}
+
+}
+
+func TestNewPoolWithOptions(t *testing.T) {
+
+	stats := NewPoolWithOptions(
+		WithEndpoint(ts.URL),
+		WithEZKey(EZKey),
+		WithFlushInterval(time.Hour),
+		WithChunkSize(2),
+		WithBufferSize(8),
+		WithPrefix("prefix:"),
+		WithHTTPClient(&http.Client{Timeout: time.Second}),
+	)
+
+	if cap(stats.count) != 8 || cap(stats.value) != 8 {
+		t.Errorf("Expected buffers of 8, got: %d, %d", cap(stats.count), cap(stats.value))
+	}
+
+	for i := 0; i < 3; i++ {
+		stats.Value("players", float64(i), time.Now())
+	}
+	time.Sleep(10 * time.Millisecond)
+	flushed := make(chan struct{})
+	go func() {
+		stats.Flush()
+		close(flushed)
+	}()
+
+	sizes := map[int]bool{}
+	for i := 0; i < 2; i++ {
+		var p Payload
+		if err := json.Unmarshal(<-reqs, &p); err != nil {
+			t.Fatal(err)
+		}
+		if p.EZKey != EZKey {
+			t.Errorf("Expected: %q, got: %q", EZKey, p.EZKey)
+		}
+		sizes[len(p.Data)] = true
+		for _, stat := range p.Data {
+			if stat.Key != "prefix:players" {
+				t.Errorf("Unexpected stat: %+v", stat)
+			}
+		}
+	}
+	if !sizes[1] || !sizes[2] {
+		t.Errorf("Expected chunks of 2 and 1 stats, got: %v", sizes)
+	}
+
+	<-flushed
+	stats.Stop()
+
+}
+
+func TestGauge(t *testing.T) {
+
+	stats := NewPool(ts.URL, EZKey, time.Hour)
+
+	stats.Gauge("depth", 1)
+	stats.Gauge("depth", 5)
+	stats.Gauge("depth", 3)
+	stats.GaugeWithTags("depth", 7, T("queue", "jobs"))
+	time.Sleep(10 * time.Millisecond)
+	stats.Stop()
+
+	var p Payload
+	if err := json.Unmarshal(<-reqs, &p); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]float64{"depth": 3, "depth.queue=jobs": 7}
+	if len(p.Data) != len(expected) {
+		t.Errorf("Expected: %d stats, got: %+v", len(expected), p.Data)
+	}
+	for _, stat := range p.Data {
+		if v, ok := expected[stat.Key]; !ok || stat.Value != v || stat.Timestamp == 0 {
+			t.Errorf("Unexpected stat: %+v", stat)
+		}
+	}
+
+}
+
+func TestHistogram(t *testing.T) {
+
+	stats := NewPool(ts.URL, EZKey, time.Hour)
+
+	for i := 1; i <= 100; i++ {
+		stats.Histogram("latency", float64(i))
+	}
+	time.Sleep(10 * time.Millisecond)
+	stats.Stop()
+
+	var p Payload
+	if err := json.Unmarshal(<-reqs, &p); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]float64{
+		"latency.count": 100,
+		"latency.sum":   5050,
+		"latency.min":   1,
+		"latency.max":   100,
+		"latency.p50":   50,
+		"latency.p95":   95,
+		"latency.p99":   99,
+	}
+	if len(p.Data) != len(expected) {
+		t.Errorf("Expected: %d stats, got: %d", len(expected), len(p.Data))
+	}
+	for _, stat := range p.Data {
+		if stat.Value != expected[stat.Key] {
+			t.Errorf("%s expected: %g, got: %g", stat.Key, expected[stat.Key], stat.Value)
+		}
+	}
+
+}
+
+func TestRetryPolicy(t *testing.T) {
+
+	var (
+		failures int32 = 3
+		endpoint       = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if atomic.AddInt32(&failures, -1) >= 0 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			ts.Config.Handler.ServeHTTP(w, req)
+		}))
+	)
+	defer endpoint.Close()
+
+	stats := NewPool(endpoint.URL, EZKey, time.Hour)
+	stats.SetRetryPolicy(RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond})
+
+	// both attempts fail so the batch is re-queued
+	stats.Count("darts", 1)
+	time.Sleep(10 * time.Millisecond)
+	stats.Flush()
+
+	if n := atomic.LoadInt32(&failures); n != 1 {
+		t.Errorf("Expected: 2 attempts, got: %d", 3-n)
+	}
+
+	// the re-queued batch is retried and sent with the next flush
+	stats.Count("darts", 2)
+	time.Sleep(10 * time.Millisecond)
+	stopped := make(chan struct{})
+	go func() {
+		stats.Stop()
+		close(stopped)
+	}()
+
+	seen := map[float64]bool{}
+	for i := 0; i < 2; i++ {
+		var p Payload
+		if err := json.Unmarshal(<-reqs, &p); err != nil {
+			t.Fatal(err)
+		}
+		for _, stat := range p.Data {
+			seen[stat.Count] = true
+		}
+	}
+	if !seen[1] || !seen[2] {
+		t.Errorf("Expected both flushes to be sent, got: %v", seen)
+	}
+	<-stopped
+
+}
+
+func TestRequeueFailedChunks(t *testing.T) {
+
+	var (
+		failures int32 = 1
+		sent           = map[string]int{}
+		mu       sync.Mutex
+		endpoint = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			data, _ := ioutil.ReadAll(req.Body)
+			if strings.Contains(string(data), "misses") && atomic.AddInt32(&failures, -1) >= 0 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			var p Payload
+			json.Unmarshal(data, &p)
+			mu.Lock()
+			for _, stat := range p.Data {
+				sent[stat.Key]++
+			}
+			mu.Unlock()
+			json.NewEncoder(w).Encode(&statResponse{Status: http.StatusOK})
+		}))
+	)
+	defer endpoint.Close()
+
+	stats := NewPoolWithOptions(WithEndpoint(endpoint.URL), WithEZKey(EZKey), WithFlushInterval(time.Hour), WithChunkSize(1), WithOnError(func(error, int) {}))
+
+	// only the failed chunk is re-queued and sent with the next flush
+	stats.Count("darts", 1)
+	stats.Count("misses", 1)
+	time.Sleep(10 * time.Millisecond)
+	if err := stats.Flush(); err == nil {
+		t.Error("Expected the failed chunk to be reported")
+	}
+	if err := stats.Stop(); err != nil {
+		t.Error(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if sent["darts"] != 1 || sent["misses"] != 1 {
+		t.Errorf("Expected each stat sent once, got: %v", sent)
+	}
+
+}
+
+func TestRetryBackoff(t *testing.T) {
+
+	policy := RetryPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+	for retry, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if d := policy.backoff(retry + 1); d != expected {
+			t.Errorf("Retry %d expected: %s, got: %s", retry+1, expected, d)
+		}
+	}
+
+}
+
+func TestSpool(t *testing.T) {
+
+	var (
+		dir  = t.TempDir()
+		down = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+	)
+	defer down.Close()
+
+	stats := NewPool(down.URL, EZKey, time.Hour)
+	if err := stats.SetSpool(dir, 0); err != nil {
+		t.Fatal(err)
+	}
+	stats.Count("darts", 1)
+	time.Sleep(10 * time.Millisecond)
+	stats.Stop()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 1 {
+		t.Fatalf("Expected: 1 spooled batch, got: %d", len(files))
+	}
+
+	// a new pool sends what was spooled with its first flush
+	stats = NewPool(ts.URL, EZKey, time.Hour)
+	stats.SetSpool(dir, 0)
+	stats.Count("darts", 2)
+	time.Sleep(10 * time.Millisecond)
+	stopped := make(chan struct{})
+	go func() {
+		stats.Stop()
+		close(stopped)
+	}()
+
+	seen := map[float64]bool{}
+	for i := 0; i < 2; i++ {
+		var p Payload
+		if err := json.Unmarshal(<-reqs, &p); err != nil {
+			t.Fatal(err)
+		}
+		for _, stat := range p.Data {
+			seen[stat.Count] = true
+		}
+	}
+	if !seen[1] || !seen[2] {
+		t.Errorf("Expected spooled and new stats, got: %v", seen)
+	}
+	<-stopped
+
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.json")); len(files) != 0 {
+		t.Errorf("Expected: empty spool, got: %d files", len(files))
+	}
+
+}
+
+func TestSpoolWhileThrottled(t *testing.T) {
+
+	var (
+		dir      = t.TempDir()
+		dropped  int64
+		spooled  = &spool{dir: dir}
+		endpoint = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		spoolCount = func(count float64) {
+			b := &batch{id: newIdempotencyKey(), stats: []interface{}{&CountStat{Key: "darts", Count: count}}, created: time.Now()}
+			if err := spooled.write(EZKey, b); err != nil {
+				t.Fatal(err)
+			}
+		}
+	)
+	defer endpoint.Close()
+
+	stats := NewPoolWithOptions(WithEndpoint(endpoint.URL), WithEZKey(EZKey), WithFlushInterval(time.Hour), WithSpool(dir, 0),
+		WithHoldLimit(1), WithOnError(func(err error, n int) { atomic.AddInt64(&dropped, int64(n)) }))
+
+	// the first flush is throttled, holding what it read back
+	spoolCount(1)
+	stats.Count("darts", 2)
+	stats.Flush()
+
+	// the spool isn't read while throttled, and what's over the hold
+	// limit is spooled
+	spoolCount(3)
+	stats.Count("misses", 1)
+	stats.Flush()
+	stats.Stop()
+
+	if n := atomic.LoadInt64(&dropped); n != 0 {
+		t.Errorf("Expected nothing dropped, got: %d", n)
+	}
+	batches, err := spooled.take(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total float64
+	for _, b := range batches {
+		for _, stat := range b.stats {
+			total += stat.(*CountStat).Count
+		}
+	}
+	if total != 7 {
+		t.Errorf("Expected all 4 stats spooled, got: %d batches totalling %g", len(batches), total)
+	}
+
+}
+
+func TestSpoolMaxBytes(t *testing.T) {
+
+	s := &spool{dir: t.TempDir()}
+	for i := 0; i < 3; i++ {
+		b := &batch{id: newIdempotencyKey(), stats: []interface{}{&CountStat{Key: "darts", Count: float64(i)}}, created: time.Now()}
+		if err := s.write(EZKey, b); err != nil {
+			t.Fatal(err)
+		}
+		// cap the spool at two files
+		if i == 0 {
+			files, _ := s.files()
+			s.max = 2 * files[0].Size()
+		}
+	}
+
+	// the oldest batch is removed
+	batches, err := s.take(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 2 {
+		t.Fatalf("Expected: 2 batches, got: %d", len(batches))
+	}
+	for i, b := range batches {
+		if count := b.stats[0].(*CountStat).Count; count != float64(i+1) {
+			t.Errorf("Expected: %d, got: %g", i+1, count)
+		}
+	}
+
+}
+
+func TestSpoolReplay(t *testing.T) {
+
+	var (
+		dir     = t.TempDir()
+		spooled = &spool{dir: dir}
+		b       = &batch{id: newIdempotencyKey(), stats: []interface{}{&CountStat{Key: "darts", Count: 3, Tags: []Tag{{"board", "east"}}}}, created: time.Now()}
+	)
+	if err := spooled.write(EZKey, b); err != nil {
+		t.Fatal(err)
+	}
+
+	// tags are kept on disk
+	batches, err := spooled.take(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 1 || !reflect.DeepEqual(batches[0].stats, b.stats) {
+		t.Fatalf("Expected: %v, got: %v", b.stats, batches)
+	}
+	if err := spooled.write(EZKey, b); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new pool sends what was spooled as it starts
+	stats := NewPoolWithOptions(WithEndpoint(ts.URL), WithEZKey(EZKey), WithFlushInterval(time.Hour), WithSpool(dir, 0))
+	defer stats.Stop()
+
+	select {
+	case body := <-reqs:
+		var p Payload
+		if err := json.Unmarshal(body, &p); err != nil {
+			t.Fatal(err)
+		}
+		if len(p.Data) != 1 || p.Data[0].Key != "darts" || p.Data[0].Count != 3 {
+			t.Errorf("Unexpected payload: %+v", p)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the spool to be sent without a flush")
+	}
+
+}
+
+func TestSpoolWhileBreakerOpen(t *testing.T) {
+
+	var (
+		dir      = t.TempDir()
+		spooled  = &spool{dir: dir}
+		endpoint = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+	)
+	defer endpoint.Close()
+
+	stats := NewPoolWithOptions(WithEndpoint(endpoint.URL), WithEZKey(EZKey), WithFlushInterval(time.Hour), WithSpool(dir, 0),
+		WithCircuitBreaker(1, time.Hour), WithOnError(func(error, int) {}))
+	defer stats.Stop()
+
+	stats.Count("darts", 1)
+	stats.Flush()
+	if s := stats.Stats(); s.Breaker != BreakerOpen {
+		t.Fatalf("Expected an open breaker, got: %s", s.Breaker)
+	}
+	before, _ := spooled.files()
+
+	// the spool isn't read back while the breaker is open
+	stats.Count("darts", 2)
+	if err := stats.Flush(); err != ErrCircuitOpen {
+		t.Errorf("Expected: %v, got: %v", ErrCircuitOpen, err)
+	}
+	after, _ := spooled.files()
+	names := map[string]bool{}
+	for _, f := range after {
+		names[f.Name()] = true
+	}
+	for _, f := range before {
+		if !names[f.Name()] {
+			t.Errorf("Expected %s to be left in the spool", f.Name())
+		}
+	}
+	if len(after) != len(before)+1 {
+		t.Errorf("Expected: %d spooled batches, got: %d", len(before)+1, len(after))
+	}
+
+}
+
+func TestMemoryLimit(t *testing.T) {
+
+	var (
+		dir   = t.TempDir()
+		stats = NewPoolWithOptions(WithEndpoint(ts.URL), WithEZKey(EZKey), WithFlushInterval(time.Hour), WithPaused(), WithMemoryLimit(150, dir))
+	)
+
+	// room in memory for one batch, the older spill to disk
+	for i := 1; i <= 3; i++ {
+		stats.Count("darts", float64(i))
+		if err := stats.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if held := stats.Stats().Held; held != 1 {
+		t.Errorf("Expected: 1 stat held in memory, got: %d", held)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 2 {
+		t.Fatalf("Expected: 2 spilled batches, got: %d", len(files))
+	}
+
+	// read back with the flushes once sending resumes
+	stats.Resume()
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				stats.Flush()
+			}
+		}
+	}()
+
+	var counts []float64
+	for len(counts) < 3 {
+		select {
+		case body := <-reqs:
+			var p Payload
+			if err := json.Unmarshal(body, &p); err != nil {
+				t.Fatal(err)
+			}
+			for _, stat := range p.Data {
+				counts = append(counts, stat.Count)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected: 3 counts sent, got: %v", counts)
+		}
+	}
+	close(done)
+	stats.Stop()
+
+	sort.Float64s(counts)
+	if !reflect.DeepEqual(counts, []float64{1, 2, 3}) {
+		t.Errorf("Expected darts: 1, 2 and 3, got: %v", counts)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("Expected: empty overflow, got: %d files", len(files))
+	}
+
+	// spilled batches need a directory that outlives the pool
+	if err := stats.SetMemoryLimit(150, ""); err == nil {
+		t.Error("Expected an error without a directory")
+	}
+
+	// and keep the pool from parking until they're sent
+	stats = NewPoolWithOptions(WithEZKey(EZKey), WithMemoryLimit(150, dir))
+	stats.SetIdleShutdown(1)
+	b := &batch{id: newIdempotencyKey(), stats: []interface{}{&CountStat{Key: "darts", Count: 1}}, created: time.Now()}
+	if err := stats.getOverflow().write(EZKey, b); err != nil {
+		t.Fatal(err)
+	}
+	if stats.park(1) {
+		t.Error("Expected the pool not to park with spilled batches")
+	}
+
+}
+
+func TestRecoveryFile(t *testing.T) {
+
+	var (
+		path = filepath.Join(t.TempDir(), "recovery.jsonl")
+		down = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+	)
+	defer down.Close()
+
+	// the failed final flush is saved
+	stats := NewPoolWithOptions(WithEndpoint(down.URL), WithEZKey(EZKey), WithFlushInterval(time.Hour), WithRecoveryFile(path))
+	stats.Count("darts", 1)
+	stats.Value("score", 180, time.Now())
+	if err := stats.Stop(); err == nil {
+		t.Error("Expected the final flush to fail")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// kept by a pool that stops before sending them
+	stats = NewPoolWithOptions(WithEndpoint(ts.URL), WithEZKey(EZKey), WithFlushInterval(time.Hour), WithPaused(), WithRecoveryFile(path))
+	stats.Stop()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// and sent by the next as it starts, without a new stat
+	stats = NewPoolWithOptions(WithEndpoint(ts.URL), WithEZKey(EZKey), WithFlushInterval(time.Hour), WithRecoveryFile(path))
+	defer stats.Stop()
+
+	var p Payload
+	if err := json.Unmarshal(<-reqs, &p); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Data) != 2 {
+		t.Errorf("Expected: 2 recovered stats, got: %+v", p.Data)
+	}
+
+	// then removed
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Error("Expected the recovery file removed once sent")
+
+}
+
+func TestRecoveryFileOverflow(t *testing.T) {
+
+	var (
+		dir   = t.TempDir()
+		path  = filepath.Join(dir, "recovery.jsonl")
+		spill = filepath.Join(dir, "overflow")
+		stats = NewPoolWithOptions(WithEndpoint(ts.URL), WithEZKey(EZKey), WithFlushInterval(time.Hour), WithPaused(),
+			WithMemoryLimit(150, spill), WithRecoveryFile(path))
+	)
+
+	// one batch held in memory and two spilled are all saved
+	for i := 1; i <= 3; i++ {
+		stats.Count("darts", float64(i))
+		stats.Flush()
+	}
+	stats.Stop()
+	if files, _ := ioutil.ReadDir(spill); len(files) != 0 {
+		t.Errorf("Expected: empty overflow, got: %d files", len(files))
+	}
+
+	stats = NewPoolWithOptions(WithEndpoint(ts.URL), WithEZKey(EZKey), WithFlushInterval(time.Hour), WithRecoveryFile(path))
+	stopped := make(chan error)
+	go func() { stopped <- stats.Stop() }()
+
+	var counts []float64
+	for i := 0; i < 3; i++ {
+		var p Payload
+		if err := json.Unmarshal(<-reqs, &p); err != nil {
+			t.Fatal(err)
+		}
+		for _, stat := range p.Data {
+			counts = append(counts, stat.Count)
+		}
+	}
+	if err := <-stopped; err != nil {
+		t.Error(err)
+	}
+	sort.Float64s(counts)
+	if !reflect.DeepEqual(counts, []float64{1, 2, 3}) {
+		t.Errorf("Expected darts: 1, 2 and 3, got: %v", counts)
+	}
+
+}
+
+func TestSender(t *testing.T) {
+
+	var (
+		sent   = make(chan []interface{}, 1)
+		stats  = NewPool(ts.URL, EZKey, time.Hour)
+		sender = SenderFunc(func(ctx context.Context, stats []interface{}) error {
+			if IdempotencyKey(ctx) == "" {
+				t.Error("Expected an idempotency key")
+			}
+			sent <- stats
+			return nil
+		})
+	)
+	stats.SetSender(sender)
+
+	stats.Count("darts", 3)
+	time.Sleep(10 * time.Millisecond)
+	stats.Stop()
+
+	batch := <-sent
+	if len(batch) != 1 {
+		t.Fatalf("Expected: 1 stat, got: %d", len(batch))
+	}
+	if stat, ok := batch[0].(*CountStat); !ok || stat.Key != "darts" || stat.Count != 3 {
+		t.Errorf("Unexpected stat: %+v", batch[0])
+	}
+
+}
+
+type blockingStater struct {
+	countingStater
+	release chan struct{}
+}
+
+func (b *blockingStater) Count(key string, val float64) {
+	<-b.release
+	b.countingStater.Count(key, val)
+}
+
+type panickingStater struct{ NilPool }
+
+func (panickingStater) Count(string, float64) { panic("unreachable") }
+
+func TestMultiPool(t *testing.T) {
+
+	var (
+		counting = &countingStater{}
+		blocking = &blockingStater{release: make(chan struct{})}
+		stats    = NewMultiPool(counting, blocking, panickingStater{})
+	)
+	stats.SetLogger(log.New(ioutil.Discard, "", 0))
+
+	// a sink that is stuck or failing doesn't hold up the others
+	for i := 0; i < 10; i++ {
+		stats.Count("darts", 1)
+	}
+	stats.Gauge("players", 2)
+	close(blocking.release)
+	stats.Close()
+
+	if n := atomic.LoadInt64(&counting.n); n != 11 {
+		t.Errorf("Expected: 11 stats, got: %d", n)
+	}
+	if n := atomic.LoadInt64(&blocking.n); n != 11 {
+		t.Errorf("Expected: 11 stats, got: %d", n)
+	}
+
+}
+
+func TestTeePool(t *testing.T) {
+
+	var (
+		counting = &countingStater{}
+		blocking = &blockingStater{release: make(chan struct{})}
+		stats    = NewTeePool(counting, blocking)
+	)
+	stats.SetLogger(log.New(ioutil.Discard, "", 0))
+
+	// the primary gets every stat right away, however stuck the secondary
+	for i := 0; i < 10; i++ {
+		stats.Count("darts", 1)
+	}
+	stats.Gauge("players", 2)
+	if n := atomic.LoadInt64(&counting.n); n != 11 {
+		t.Errorf("Expected: 11 stats, got: %d", n)
+	}
+	close(blocking.release)
+	stats.Close()
+	if n := atomic.LoadInt64(&blocking.n); n != 11 {
+		t.Errorf("Expected: 11 stats, got: %d", n)
+	}
+
+	// nor does a panicking secondary break it
+	stats = NewTeePool(counting, panickingStater{})
+	stats.SetLogger(log.New(ioutil.Discard, "", 0))
+	stats.Count("darts", 1)
+	stats.Close()
+	if n := atomic.LoadInt64(&counting.n); n != 12 {
+		t.Errorf("Expected: 12 stats, got: %d", n)
+	}
+
+}
+
+func TestStopContext(t *testing.T) {
+
+	var (
+		release = make(chan struct{})
+		hung    = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			<-release
+		}))
+	)
+	defer hung.Close()
+	defer close(release)
+
+	stats := NewPool(hung.URL, EZKey, time.Hour)
+	stats.log = log.New(ioutil.Discard, "", 0)
+	stats.Count("darts", 1)
+	time.Sleep(10 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := stats.StopContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected: %v, got: %v", context.DeadlineExceeded, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected stop to give up at the deadline, took: %s", elapsed)
+	}
+
+}
+
+func TestOnError(t *testing.T) {
+
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer rejecting.Close()
+
+	var (
+		errs    []error
+		dropped int
+		stats   = NewPool(rejecting.URL, EZKey, time.Hour)
+	)
+	stats.OnError(func(err error, n int) {
+		errs = append(errs, err)
+		dropped += n
+	})
+
+	stats.Count("darts", 1)
+	stats.Count("misses", 1)
+	time.Sleep(10 * time.Millisecond)
+	if err := stats.Stop(); err == nil {
+		t.Error("Expected the failed flush to be returned")
+	}
+
+	if len(errs) != 1 || dropped != 2 {
+		t.Errorf("Expected: 1 error dropping 2 stats, got: %v dropping %d", errs, dropped)
+	}
+
+}
+
+func TestErrorTypes(t *testing.T) {
+
+	var (
+		apiError = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte(`{"status":500,"msg":"no ezkey"}`))
+		}))
+		statusError = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+	)
+	defer apiError.Close()
+	defer statusError.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	flush := func(url string) error {
+		stats := NewPool(url, EZKey, time.Hour)
+		stats.OnError(func(error, int) {})
+		stats.Count("darts", 1)
+		return stats.Stop()
+	}
+
+	err := flush(apiError.URL)
+	var ae *APIError
+	if !errors.As(err, &ae) || ae.Message != "no ezkey" || !errors.Is(err, &APIError{Status: 500}) || IsRetryable(err) {
+		t.Errorf("Expected a permanent *APIError, got: %v", err)
+	}
+
+	err = flush(statusError.URL)
+	if !errors.Is(err, &HTTPStatusError{Code: http.StatusBadRequest}) || IsRetryable(err) {
+		t.Errorf("Expected a permanent *HTTPStatusError, got: %v", err)
+	}
+
+	err = flush(down.URL)
+	var te *TransportError
+	if !errors.As(err, &te) || !IsRetryable(err) {
+		t.Errorf("Expected a retryable *TransportError, got: %v", err)
+	}
+
+	if err := (&EncodeError{errors.New("bad")}); IsRetryable(err) {
+		t.Error("Expected encoding errors not to be retryable")
+	}
+
+}
+
+func TestUnsupportedMediaType(t *testing.T) {
+
+	for _, tc := range []struct {
+		gzip     bool
+		expected []string
+	}{
+		// binary is dropped first, then gzip
+		{false, []string{ContentTypeBinary + " gzip", ContentTypeJSON + " gzip", ContentTypeJSON + " "}},
+		// a receiver that only lacks binary keeps getting gzip
+		{true, []string{ContentTypeBinary + " gzip", ContentTypeJSON + " gzip"}},
+	} {
+		var (
+			sent  []string
+			relay = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				var (
+					ct       = req.Header.Get("Content-Type")
+					encoding = req.Header.Get("Content-Encoding")
+				)
+				sent = append(sent, ct+" "+encoding)
+				if ct != ContentTypeJSON || encoding == "gzip" && !tc.gzip {
+					w.WriteHeader(http.StatusUnsupportedMediaType)
+					return
+				}
+				json.NewEncoder(w).Encode(&statResponse{Status: http.StatusOK})
+			}))
+			stats = NewPoolWithOptions(WithEndpoint(relay.URL), WithEZKey(EZKey), WithFlushInterval(time.Hour), WithGzip())
+		)
+		stats.SetBinaryEncoding(true)
+
+		stats.Count("darts", 1)
+		if err := stats.Stop(); err != nil {
+			t.Error(err)
+		}
+		relay.Close()
+
+		if !reflect.DeepEqual(sent, tc.expected) {
+			t.Errorf("Expected: %q, got: %q", tc.expected, sent)
+		}
+	}
+
+}
+
+func TestGzip(t *testing.T) {
+
+	var (
+		reject    int32
+		encodings = make(chan string, 2)
+		relay     = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			encoding := req.Header.Get("Content-Encoding")
+			encodings <- encoding
+			if encoding == "gzip" && atomic.LoadInt32(&reject) == 1 {
+				w.WriteHeader(http.StatusUnsupportedMediaType)
+				return
+			}
+			var body io.Reader = req.Body
+			if encoding == "gzip" {
+				zr, err := gzip.NewReader(req.Body)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				body = zr
+			}
+			data, _ := ioutil.ReadAll(body)
+			reqs <- data
+			json.NewEncoder(w).Encode(&statResponse{Status: http.StatusOK})
+		}))
+	)
+	defer relay.Close()
+
+	stats := NewPoolWithOptions(WithEndpoint(relay.URL), WithEZKey(EZKey), WithGzip())
+	defer stats.Stop()
+
+	for _, rejected := range []bool{false, true} {
+		if rejected {
+			atomic.StoreInt32(&reject, 1)
+		}
+
+		stats.Count("darts", 1)
+		time.Sleep(10 * time.Millisecond)
+		stats.Flush()
+
+		if encoding := <-encodings; encoding != "gzip" {
+			t.Errorf("Expected: gzip, got: %q", encoding)
+		}
+		// rejected compressed requests are retried uncompressed
+		if rejected {
+			if encoding := <-encodings; encoding != "" {
+				t.Errorf("Expected no encoding, got: %q", encoding)
+			}
+		}
+
+		var p Payload
+		if err := json.Unmarshal(<-reqs, &p); err != nil {
+			t.Fatal(err)
+		}
+		if len(p.Data) != 1 || p.Data[0].Count != 1 {
+			t.Errorf("Unexpected stats: %+v", p.Data)
+		}
+	}
+
+}
+
+func TestPoolStats(t *testing.T) {
+
+	stats := NewPool(ts.URL, EZKey, time.Hour)
+	stats.SetReportStats(true)
+
+	stats.Count("darts", 1)
+	stats.Value("players", 2, time.Now())
+	time.Sleep(10 * time.Millisecond)
+	stats.Flush()
+	<-reqs
+
+	s := stats.Stats()
+	if s.Flushes != 1 || s.Sent != 2 || s.Bytes == 0 || s.Errors != 0 {
+		t.Errorf("Unexpected stats: %+v", s)
+	}
+
+	// the next flush reports the changes
+	time.Sleep(10 * time.Millisecond)
+	stats.Stop()
+
+	var p Payload
+	if err := json.Unmarshal(<-reqs, &p); err != nil {
+		t.Fatal(err)
+	}
+	reported := map[string]float64{}
+	for _, stat := range p.Data {
+		reported[stat.Key] = stat.Count + stat.Value
+	}
+	if reported[sentKey] != 2 || reported[payloadKey] != float64(s.Bytes) {
+		t.Errorf("Unexpected stats: %+v", reported)
+	}
+	if _, ok := reported[flushTimeKey]; !ok {
+		t.Errorf("Expected the flush time to be reported: %+v", reported)
+	}
+	if _, ok := reported[sendQueueKey]; !ok {
+		t.Errorf("Expected the send queue to be reported: %+v", reported)
+	}
+
+}
+
+func TestDropPolicy(t *testing.T) {
+
+	for _, policy := range []DropPolicy{DropNewest, DropOldest, Block} {
+
+		// not running, so nothing drains the channels
+		stats := NewPoolWithOptions(WithEndpoint(ts.URL), WithBufferSize(2))
+		stats.starter = func() {}
+		stats.SetDropPolicy(policy, time.Millisecond)
+
+		var dropped []float64
+		stats.OnDrop(func(s Stat) { dropped = append(dropped, s.Value) })
+		stats.log = log.New(ioutil.Discard, "", 0)
+
+		for i := 1; i <= 3; i++ {
+			stats.Count("darts", float64(i))
+		}
+
+		expected := map[DropPolicy]float64{DropNewest: 3, DropOldest: 1, Block: 3}[policy]
+		if len(dropped) != 1 || dropped[0] != expected {
+			t.Errorf("Policy %d expected: [%g] dropped, got: %v", policy, expected, dropped)
+		}
+		if n := stats.Stats().Dropped; n != 1 {
+			t.Errorf("Policy %d expected: 1 dropped, got: %d", policy, n)
+		}
+	}
+
+}
+
+type recordingStater struct {
+	NilPool
+	counts    map[string]float64
+	durations map[string]time.Duration
+	sync.Mutex
+}
+
+func newRecordingStater() *recordingStater {
+	return &recordingStater{counts: map[string]float64{}, durations: map[string]time.Duration{}}
+}
+
+func (r *recordingStater) Count(key string, val float64) {
+	r.Lock()
+	r.counts[key] += val
+	r.Unlock()
+}
+
+func (r *recordingStater) Duration(key string, val time.Duration) {
+	r.Lock()
+	r.durations[key] += val
+	r.Unlock()
+}
+
+func TestTimer(t *testing.T) {
+
+	stats := newRecordingStater()
+
+	timer := NewTimer(stats, "query")
+	time.Sleep(5 * time.Millisecond)
+	elapsed := timer.Stop()
+	timer.Stop()
+
+	if elapsed < 5*time.Millisecond || stats.durations["query"] != elapsed {
+		t.Errorf("Expected: %s recorded once, got: %s", elapsed, stats.durations["query"])
+	}
+
+}
+
+func TestHTTPMiddleware(t *testing.T) {
+
+	var (
+		stats   = newRecordingStater()
+		handler = HTTPMiddleware(stats)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.URL.Path == "/missing" {
+				http.NotFound(w, req)
+				return
+			}
+			w.Write([]byte("ok"))
+		}))
+	)
+
+	for _, path := range []string{"/darts", "/darts", "/missing"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	for key, expected := range map[string]float64{
+		"http.GET /darts.requests":     2,
+		"http.GET /darts.status.2xx":   2,
+		"http.GET /missing.requests":   1,
+		"http.GET /missing.status.4xx": 1,
+	} {
+		if stats.counts[key] != expected {
+			t.Errorf("%s expected: %g, got: %g", key, expected, stats.counts[key])
+		}
+	}
+	if _, ok := stats.durations["http.GET /darts.duration"]; !ok {
+		t.Error("Expected a duration to be recorded")
+	}
+
+}
+
+func TestRuntimeCollector(t *testing.T) {
+
+	var (
+		last  runtime.MemStats
+		m     runtime.MemStats
+		stats = newRecordingStater()
+	)
+	runtime.ReadMemStats(&last)
+	runtime.GC()
+	runtime.ReadMemStats(&m)
+
+	reportRuntime(stats, &m, &last)
+	if stats.counts["runtime.gc_cycles"] < 1 {
+		t.Errorf("Expected gc cycles to be counted, got: %v", stats.counts)
+	}
+	if _, ok := stats.durations["runtime.gc_pause"]; !ok {
+		t.Error("Expected the gc pause to be recorded")
+	}
+
+	stop := RuntimeCollector(NewNilPool(), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	stop()
+
+}
+
+func TestRegisterGauge(t *testing.T) {
+
+	stats := NewPool(ts.URL, EZKey, time.Hour)
+	queue := []int{1, 2, 3}
+	stats.RegisterGauge("queue", func() float64 { return float64(len(queue)) })
+
+	stats.Flush()
+	var p Payload
+	if err := json.Unmarshal(<-reqs, &p); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Data) != 1 || p.Data[0].Key != "queue" || p.Data[0].Value != 3 {
+		t.Errorf("Unexpected stats: %+v", p.Data)
+	}
+
+	// nothing is left to report once unregistered
+	stats.UnregisterGauge("queue")
+	stats.Stop()
+	select {
+	case data := <-reqs:
+		t.Errorf("Unexpected request: %s", data)
+	default:
+	}
+
+}
+
+func TestRecorderPool(t *testing.T) {
+
+	stats := NewRecorderPool()
+	stats.Count("darts", 1)
+	stats.Count("darts", 2)
+	stats.CountWithTags("darts", 1, T("hand", "left"))
+	stats.Value("score", 60, time.Now())
+	stats.Gauge("score", 20)
+	stats.Duration("throw", time.Second)
+
+	if n := stats.CountFor("darts"); n != 3 {
+		t.Errorf("Expected: 3, got: %g", n)
+	}
+	if n := stats.CountFor("darts.hand=left"); n != 1 {
+		t.Errorf("Expected: 1, got: %g", n)
+	}
+	if vals := stats.ValuesFor("score"); len(vals) != 2 || vals[0] != 60 || vals[1] != 20 {
+		t.Errorf("Unexpected values: %v", vals)
+	}
+	if ds := stats.DurationsFor("throw"); len(ds) != 1 || ds[0] != time.Second {
+		t.Errorf("Unexpected durations: %v", ds)
+	}
+	if keys := stats.Keys(); strings.Join(keys, ",") != "darts,darts.hand=left,score,throw" {
+		t.Errorf("Unexpected keys: %v", keys)
+	}
+
+	stats.Reset()
+	if keys := stats.Keys(); len(keys) != 0 {
+		t.Errorf("Expected no keys after reset, got: %v", keys)
+	}
+
+}
+
+func TestWithPrefix(t *testing.T) {
+
+	stats := NewPool(ts.URL, EZKey, time.Hour)
+	stats.SetPrefix("app.")
+
+	db := stats.WithPrefix("db.")
+	db.Count("queries", 1)
+	db.WithPrefix("replica.").Count("queries", 2)
+	time.Sleep(10 * time.Millisecond)
+	stats.Stop()
+
+	var p Payload
+	if err := json.Unmarshal(<-reqs, &p); err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]float64{}
+	for _, stat := range p.Data {
+		counts[stat.Key] = stat.Count
+	}
+	if len(counts) != 2 || counts["app.db.queries"] != 1 || counts["app.db.replica.queries"] != 2 {
+		t.Errorf("Unexpected stats: %+v", counts)
+	}
+
+}
+
+func TestFlushError(t *testing.T) {
+
+	partial := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, _ := ioutil.ReadAll(req.Body)
+		if strings.Contains(string(data), "misses") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		reqs <- data
+		json.NewEncoder(w).Encode(&statResponse{Status: http.StatusOK})
+	}))
+	defer partial.Close()
+
+	stats := NewPoolWithOptions(WithEndpoint(partial.URL), WithEZKey(EZKey), WithChunkSize(1), WithOnError(func(error, int) {}))
+	stats.Count("darts", 1)
+	stats.Count("misses", 1)
+	time.Sleep(10 * time.Millisecond)
+	err := stats.Stop()
+	<-reqs
+
+	var flushErr *FlushError
+	if !errors.As(err, &flushErr) {
+		t.Fatalf("Expected a FlushError, got: %v", err)
+	}
+	if len(flushErr.Chunks) != 1 || flushErr.Total != 2 || flushErr.FailedStats() != 1 {
+		t.Errorf("Unexpected error: %v", flushErr)
+	}
+
+}
+
+func TestAggregation(t *testing.T) {
+
+	stats := NewPoolWithOptions(WithEndpoint(ts.URL), WithEZKey(EZKey), WithFlushInterval(time.Hour), WithAggregation(AggregateAvg))
+	stats.SetKeyOptions("peak", KeyOptions{Aggregate: AggregateMax})
+	stats.SetKeyOptions("raw", KeyOptions{Aggregate: AggregateAll})
+
+	for _, v := range []float64{2, 6, 4} {
+		stats.Value("load", v, time.Now())
+		stats.Value("peak", v, time.Now())
+		stats.Value("raw", v, time.Now())
+	}
+	time.Sleep(10 * time.Millisecond)
+	stats.Stop()
+
+	var p Payload
+	if err := json.Unmarshal(<-reqs, &p); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string][]float64{}
+	for _, stat := range p.Data {
+		got[stat.Key] = append(got[stat.Key], stat.Value)
+	}
+	if v := got["load"]; len(v) != 1 || v[0] != 4 {
+		t.Errorf("Expected an average of 4, got: %v", v)
+	}
+	if v := got["peak"]; len(v) != 1 || v[0] != 6 {
+		t.Errorf("Expected a max of 6, got: %v", v)
+	}
+	if v := got["raw"]; len(v) != 3 {
+		t.Errorf("Expected every value, got: %v", v)
+	}
+
+}
+
+func TestSampledCount(t *testing.T) {
+
+	stats := NewPool(ts.URL, EZKey, time.Hour)
+
+	stats.SampledCount("always", 2, 1)
+	stats.SampledCount("never", 1, 0)
+	for i := 0; i < 1000; i++ {
+		stats.SampledCount("quarter", 1, 0.25)
+	}
+	time.Sleep(10 * time.Millisecond)
+	stats.Stop()
+
+	var p Payload
+	if err := json.Unmarshal(<-reqs, &p); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]float64{}
+	for _, stat := range p.Data {
+		got[stat.Key] = stat.Count
+	}
+	if got["always"] != 2 {
+		t.Errorf("Expected: 2, got: %g", got["always"])
+	}
+	if _, ok := got["never"]; ok {
+		t.Errorf("Expected no count at rate 0, got: %g", got["never"])
+	}
+	// scaled back up to about the number of calls
+	if n := got["quarter"]; n < 700 || n > 1300 {
+		t.Errorf("Expected about 1000, got: %g", n)
+	}
+
+}
+
+func TestSampler(t *testing.T) {
+
+	stats := NewPoolWithOptions(WithEndpoint(ts.URL), WithEZKey(EZKey), WithFlushInterval(time.Hour), WithSampler(NeverSample))
+	stats.SetKeyOptions("kept", KeyOptions{Sampler: AlwaysSample})
+	stats.SetKeyOptions("limited", KeyOptions{Sampler: RateLimitSampler(2, time.Hour)})
+
+	stats.SampledCount("dropped", 1, 1)
+	stats.SampledDuration("dropped", time.Millisecond, 1)
+	stats.SampledCount("kept", 1, 0.5)
+	for i := 0; i < 5; i++ {
+		stats.SampledCount("limited", 1, 0.5)
+	}
+	time.Sleep(10 * time.Millisecond)
+	stats.Stop()
+
+	var p Payload
+	if err := json.Unmarshal(<-reqs, &p); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]float64{}
+	for _, stat := range p.Data {
+		got[stat.Key] = stat.Count
+	}
+	expected := map[string]float64{"kept": 1, "limited": 2}
+	if len(got) != len(expected) {
+		t.Errorf("Expected: %v, got: %v", expected, got)
+	}
+	for key, n := range expected {
+		if got[key] != n {
+			t.Errorf("Expected %s: %g, got: %g", key, n, got[key])
+		}
+	}
+
+	for _, c := range []struct {
+		s    ProbabilitySampler
+		rate float64
+		kept bool
+	}{
+		{0, 1, true},
+		{0, 0, false},
+		{1, 0, true},
+		{0, 1.5, true},
+	} {
+		if _, ok := c.s.Sample("key", c.rate); ok != c.kept {
+			t.Errorf("ProbabilitySampler(%g) at rate %g expected: %t", float64(c.s), c.rate, c.kept)
+		}
+	}
+
+}
+
+func TestIncDec(t *testing.T) {
+
+	stats := NewPool(ts.URL, EZKey, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		stats.Inc("inflight")
+	}
+	stats.Dec("inflight")
+	stats.Add("inflight", 5)
+	time.Sleep(10 * time.Millisecond)
+	stats.Stop()
+
+	var p Payload
+	if err := json.Unmarshal(<-reqs, &p); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Data) != 1 || p.Data[0].Count != 7 {
+		t.Errorf("Expected a count of 7, got: %+v", p.Data)
+	}
+
+}
+
+func TestUnique(t *testing.T) {
+
+	stats := NewPool(ts.URL, EZKey, time.Hour)
+	stats.SetDropPolicy(Block, 0)
+
+	for i := 0; i < 3; i++ {
+		for user := 0; user < 5000; user++ {
+			stats.Unique("users", strconv.Itoa(user))
+		}
+	}
+	stats.Unique("one", "a")
+	stats.Unique("one", "a")
+	time.Sleep(10 * time.Millisecond)
+	stats.Stop()
+
+	var p Payload
+	if err := json.Unmarshal(<-reqs, &p); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]float64{}
+	for _, stat := range p.Data {
+		got[stat.Key] = stat.Value
+	}
+	if n := got["users"]; math.Abs(n-5000) > 250 {
+		t.Errorf("Expected about 5000 unique users, got: %g", n)
+	}
+	if got["one"] != 1 {
+		t.Errorf("Expected: 1, got: %g", got["one"])
+	}
+
+}
+
+func TestDefault(t *testing.T) {
+
+	if _, ok := Default().(NilPool); !ok {
+		t.Errorf("Expected a NilPool by default, got: %T", Default())
+	}
+
+	r := NewRecorderPool()
+	SetDefault(r)
+	defer SetDefault(nil)
+
+	Count("requests", 1)
+	Value("players", 2, time.Now())
+	Duration("latency", time.Millisecond)
+
+	if r.CountFor("requests") != 1 || len(r.ValuesFor("players")) != 1 || len(r.DurationsFor("latency")) != 1 {
+		t.Errorf("Expected stats recorded with the default, got: %v", r.Keys())
+	}
+
+}
+
+func TestContext(t *testing.T) {
+
+	if _, ok := FromContext(context.Background()).(NilPool); !ok {
+		t.Errorf("Expected a NilPool without a Stater, got: %T", FromContext(context.Background()))
+	}
+
+	r := NewRecorderPool()
+	handler := HTTPMiddleware(r)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		FromContext(req.Context()).Count("handled", 1)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	if r.CountFor("handled") != 1 {
+		t.Errorf("Expected the handler to count with the context's Stater, got: %v", r.Keys())
+	}
+
+}
+
+func TestRequestTimeout(t *testing.T) {
+
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-req.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer hung.Close()
+
+	stats := NewPoolWithOptions(WithEndpoint(hung.URL), WithEZKey(EZKey), WithFlushInterval(time.Hour), WithRequestTimeout(20*time.Millisecond), WithOnError(func(error, int) {}))
+	stats.Count("darts", 1)
+	time.Sleep(10 * time.Millisecond)
+
+	start := time.Now()
+	if err := stats.Flush(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected a deadline exceeded error, got: %v", err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("Expected the request to time out, took: %s", d)
+	}
+	stats.SetRequestTimeout(time.Millisecond)
+	stats.Stop()
+
+}
+
+func TestTransportOptions(t *testing.T) {
+
+	client := &http.Client{Transport: &http.Transport{}}
+	stats := NewPoolWithOptions(WithHTTPClient(client), WithMaxIdleConns(3), WithTLSConfig(&tls.Config{ServerName: "stathat"}))
+
+	tr, ok := stats.client.Transport.(*http.Transport)
+	if !ok || tr.MaxIdleConns != 3 || tr.TLSClientConfig.ServerName != "stathat" {
+		t.Errorf("Expected the transport to be configured, got: %+v", stats.client.Transport)
+	}
+	if client.Transport.(*http.Transport).MaxIdleConns != 0 {
+		t.Error("Expected the given client to be left alone")
+	}
+
+}
+
+func TestCircuitBreaker(t *testing.T) {
+
+	var (
+		requests int32
+		down     int32 = 1
+		endpoint       = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			if atomic.LoadInt32(&down) == 1 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			json.NewEncoder(w).Encode(&statResponse{Status: http.StatusOK})
+		}))
+		changes []string
+		mu      sync.Mutex
+	)
+	defer endpoint.Close()
+
+	stats := NewPoolWithOptions(
+		WithEndpoint(endpoint.URL),
+		WithEZKey(EZKey),
+		WithFlushInterval(time.Hour),
+		WithCircuitBreaker(2, 50*time.Millisecond),
+		WithOnStateChange(func(from, to BreakerState) {
+			mu.Lock()
+			changes = append(changes, from.String()+">"+to.String())
+			mu.Unlock()
+		}),
+		WithOnError(func(error, int) {}),
+	)
+	defer stats.Stop()
+
+	flush := func() error {
+		stats.Count("darts", 1)
+		time.Sleep(10 * time.Millisecond)
+		return stats.Flush()
+	}
+
+	// opens after two failed flushes, then holds without sending
+	flush()
+	flush()
+	if err := flush(); err != ErrCircuitOpen {
+		t.Errorf("Expected: %v, got: %v", ErrCircuitOpen, err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Errorf("Expected: 3 requests, got: %d", n)
+	}
+	if s := stats.Stats(); s.Breaker != BreakerOpen || s.Held != 3 {
+		t.Errorf("Expected an open breaker holding 3 stats, got: %+v", s)
+	}
+
+	// a probe after the interval sends everything held and closes it
+	atomic.StoreInt32(&down, 0)
+	time.Sleep(50 * time.Millisecond)
+	if err := flush(); err != nil {
+		t.Error(err)
+	}
+	if s := stats.Stats(); s.Breaker != BreakerClosed || s.Held != 0 || s.Sent != 4 {
+		t.Errorf("Expected a closed breaker with everything sent, got: %+v", s)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(changes, ",") != "closed>open,open>half-open,half-open>closed" {
+		t.Errorf("Unexpected state changes: %v", changes)
+	}
+
+}
+
+func TestStaterV2(t *testing.T) {
+
+	filterPool, _ := NewFilterPool(NewNilPool(), nil, nil)
+	for _, s := range []StaterV2{
+		NewNilPool(),
+		NewLoggerPool(log.New(ioutil.Discard, "", 0)),
+		NewRecorderPool(),
+		NewPrometheusPool(),
+		NewMultiPool(NewNilPool()),
+		NewTeePool(NewNilPool(), NewNilPool()),
+		filterPool,
+	} {
+		s.Gauge("key", 1)
+		s.SampledDuration("key", time.Millisecond, 0)
+		s.Timer("key").Stop()
+	}
+
+	var (
+		_ StaterV2 = (*Pool)(nil)
+		_ StaterV2 = (*PrefixedPool)(nil)
+		_ StaterV2 = (*StatsdPool)(nil)
+		_ StaterV2 = (*EZKeyPool)(nil)
+	)
+
+}
+
+func TestMaxSends(t *testing.T) {
+
+	var (
+		inflight, most, sent int32
+		endpoint             = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			n := atomic.AddInt32(&inflight, 1)
+			defer atomic.AddInt32(&inflight, -1)
+			for {
+				m := atomic.LoadInt32(&most)
+				if n <= m || atomic.CompareAndSwapInt32(&most, m, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			atomic.AddInt32(&sent, 1)
+			json.NewEncoder(w).Encode(&statResponse{Status: http.StatusOK})
+		}))
+	)
+	defer endpoint.Close()
+
+	stats := NewPoolWithOptions(WithEndpoint(endpoint.URL), WithEZKey(EZKey), WithFlushInterval(time.Hour), WithChunkSize(1), WithMaxSends(2))
+	for i := 0; i < 6; i++ {
+		stats.Value("players", float64(i), time.Now())
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := stats.Stop(); err != nil {
+		t.Error(err)
+	}
+
+	if n := atomic.LoadInt32(&most); n != 2 {
+		t.Errorf("Expected at most 2 sends at once, got: %d", n)
+	}
+	if n := atomic.LoadInt32(&sent); n != 6 {
+		t.Errorf("Expected: 6 chunks sent, got: %d", n)
+	}
+
+}
+
+func TestStreaming(t *testing.T) {
+
+	chunk := []interface{}{
+		&CountStat{Key: "darts", Count: 7, Timestamp: 1},
+		&ValueStat{Key: `"quoted"`, Value: 0.5},
+	}
+	buf := &bytes.Buffer{}
+	encodePayload(buf, EZKey, chunk, false)
+	streamed := &bytes.Buffer{}
+	if err := encodeStream(streamed, EZKey, chunk); err != nil {
+		t.Fatal(err)
+	}
+	if streamed.String() != buf.String() {
+		t.Errorf("Expected: %s, got: %s", buf, streamed)
+	}
+
+	stats := NewPoolWithOptions(WithEndpoint(ts.URL), WithEZKey(EZKey), WithFlushInterval(time.Hour), WithStreaming())
+	stats.Count("darts", 7)
+	time.Sleep(10 * time.Millisecond)
+	stats.Stop()
+
+	var p Payload
+	if err := json.Unmarshal(<-reqs, &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.EZKey != EZKey || len(p.Data) != 1 || p.Data[0].Count != 7 {
+		t.Errorf("Unexpected payload: %+v", p)
+	}
+	if s := stats.Stats(); s.Bytes == 0 {
+		t.Errorf("Expected the streamed bytes to be counted, got: %+v", s)
+	}
+
+}
+
+func TestMaxPayloadBytes(t *testing.T) {
+
+	stats := NewPool(ts.URL, EZKey, time.Hour)
+	stats.SetMaxPayloadBytes(200)
+
+	var values []interface{}
+	for i := 0; i < 10; i++ {
+		values = append(values, &CountStat{Key: strings.Repeat("k", 30) + strconv.Itoa(i), Count: 1, Timestamp: 1})
+	}
+	values = append(values, &CountStat{Key: strings.Repeat("k", 300), Count: 1})
+
+	total := 0
+	chunks := stats.chunk(values, EZKey)
+	for i, chunk := range chunks {
+		total += len(chunk)
+		buf := &bytes.Buffer{}
+		encodePayload(buf, EZKey, chunk, false)
+		if buf.Len() > 200 && len(chunk) > 1 {
+			t.Errorf("Chunk %d is %d bytes", i, buf.Len())
+		}
+	}
+	if total != len(values) || len(chunks) < 4 {
+		t.Errorf("Expected %d stats in several chunks, got %d in %d", len(values), total, len(chunks))
+	}
+	if last := chunks[len(chunks)-1]; len(last) != 1 {
+		t.Errorf("Expected the oversized stat alone, got: %d stats", len(last))
+	}
+
+}
+
+func TestHighWaterMark(t *testing.T) {
+
+	stats := NewPoolWithOptions(WithEndpoint(ts.URL), WithEZKey(EZKey), WithFlushInterval(time.Hour), WithHighWaterMark(3))
+	defer stats.Stop()
+
+	for i := 0; i < 3; i++ {
+		stats.Value("players", float64(i), time.Now())
+	}
+
+	// flushed without waiting for the interval
+	select {
+	case data := <-reqs:
+		var p Payload
+		if err := json.Unmarshal(data, &p); err != nil {
+			t.Fatal(err)
+		}
+		if len(p.Data) != 3 {
+			t.Errorf("Expected: 3 stats, got: %+v", p.Data)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected a flush at the high water mark")
+	}
+
+}
+
+func TestHighWaterMarkShards(t *testing.T) {
+
+	stats := NewPoolWithOptions(WithEndpoint(ts.URL), WithEZKey(EZKey), WithFlushInterval(time.Hour), WithShards(4), WithHighWaterMark(8))
+	defer stats.Stop()
+
+	// the mark counts the stats of every shard
+	for i := 0; i < 8; i++ {
+		stats.Count("key"+strconv.Itoa(i), 1)
+	}
+
+	select {
+	case data := <-reqs:
+		var p Payload
+		if err := json.Unmarshal(data, &p); err != nil {
+			t.Fatal(err)
+		}
+		if len(p.Data) != 8 {
+			t.Errorf("Expected: 8 stats, got: %+v", p.Data)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected a flush at the high water mark")
+	}
+
+}
+
+func TestFlushTicker(t *testing.T) {
+
+	interval := 50 * time.Millisecond
+
+	tick, stop := newFlushTicker(interval, 0, true)
+	for i := 0; i < 2; i++ {
+		if at := <-tick; at.Sub(at.Truncate(interval)) > 20*time.Millisecond {
+			t.Errorf("Expected a tick on a %s boundary, got: %s", interval, at.Format(time.StampMicro))
+		}
+	}
+	stop()
+
+	// jitter doesn't accumulate
+	start := time.Now()
+	tick, stop = newFlushTicker(interval, 20*time.Millisecond, false)
+	for i := 0; i < 4; i++ {
+		<-tick
+	}
+	stop()
+	if d := time.Since(start); d < 4*interval || d > 4*interval+100*time.Millisecond {
+		t.Errorf("Expected 4 ticks in about %s, took: %s", 4*interval, d)
+	}
+
+}
+
+func benchmarkPool() *Pool {
+	stats := NewPoolWithOptions(
+		WithEZKey(EZKey),
+		WithFlushInterval(time.Hour),
+		WithSender(SenderFunc(func(context.Context, []interface{}) error { return nil })),
+		WithDropPolicy(Block, 0),
+	)
+	return stats
+}
+
+func BenchmarkCount(b *testing.B) {
+	stats := benchmarkPool()
+	defer stats.Stop()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			stats.Count("darts", 1)
+		}
+	})
+}
+
+func BenchmarkGauge(b *testing.B) {
+	stats := benchmarkPool()
+	defer stats.Stop()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			stats.Gauge("depth", 1)
+		}
+	})
+}
+
+func TestShards(t *testing.T) {
+
+	stats := NewPoolWithOptions(WithEndpoint(ts.URL), WithEZKey(EZKey), WithFlushInterval(time.Hour), WithShards(4), WithDropPolicy(Block, 0))
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				stats.Count("key"+strconv.Itoa(i%20), 1)
+			}
+		}()
+	}
+	wg.Wait()
+	stats.Stop()
+
+	var p Payload
+	if err := json.Unmarshal(<-reqs, &p); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Data) != 20 {
+		t.Errorf("Expected: 20 keys, got: %d", len(p.Data))
+	}
+	for _, stat := range p.Data {
+		if stat.Count != 400 {
+			t.Errorf("Expected %s: 400, got: %g", stat.Key, stat.Count)
+		}
+	}
+
+}
+
+func TestBatch(t *testing.T) {
+
+	stats := NewPoolWithOptions(WithEndpoint(ts.URL), WithEZKey(EZKey), WithFlushInterval(time.Hour), WithShards(4))
+
+	b := stats.Batch()
+	for i := 0; i < 100; i++ {
+		b.Count("key"+strconv.Itoa(i%10), 1)
+	}
+	b.Gauge("depth", 3)
+	b.Release()
+	b.Count("key0", 1)
+	b.Release()
+	stats.Stop()
+
+	var p Payload
+	if err := json.Unmarshal(<-reqs, &p); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Data) != 11 {
+		t.Errorf("Expected: 11 stats, got: %d", len(p.Data))
+	}
+	for _, stat := range p.Data {
+		switch {
+		case stat.Key == "depth":
+			if stat.Value != 3 {
+				t.Errorf("Expected depth: 3, got: %g", stat.Value)
+			}
+		case stat.Key == "key0":
+			if stat.Count != 11 {
+				t.Errorf("Expected key0: 11, got: %g", stat.Count)
+			}
+		case stat.Count != 10:
+			t.Errorf("Expected %s: 10, got: %g", stat.Key, stat.Count)
+		}
+	}
+
+}
+
+func BenchmarkBatch(b *testing.B) {
+	stats := benchmarkPool()
+	defer stats.Stop()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		batch := stats.Batch()
+		for i := 0; pb.Next(); i++ {
+			batch.Count("darts", 1)
+			if i%100 == 99 {
+				batch.Release()
+			}
+		}
+		batch.Release()
+	})
+}
+
+func TestForEZKey(t *testing.T) {
+
+	var (
+		mu       sync.Mutex
+		received = map[string]Payload{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var p Payload
+		json.NewDecoder(req.Body).Decode(&p)
+		mu.Lock()
+		received[req.URL.Query().Get("ezkey")] = p
+		mu.Unlock()
+		w.Write([]byte(`{"status":200,"msg":"ok"}`))
+	}))
+	defer server.Close()
+
+	stats := NewPool(server.URL, EZKey, time.Hour)
+	stats.Count("requests", 1)
+	stats.ForEZKey("tenant-a").Count("requests", 2)
+	stats.ForEZKey("tenant-a").Count("requests", 3)
+	stats.ForEZKey("tenant-b").Gauge("users", 7)
+	if err := stats.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	stats.Stop()
+
+	if len(received) != 3 {
+		t.Fatalf("Expected: 3 payloads, got: %v", received)
+	}
+	expected := map[string]float64{EZKey: 1, "tenant-a": 5, "tenant-b": 7}
+	for ezKey, val := range expected {
+		p := received[ezKey]
+		if p.EZKey != ezKey || len(p.Data) != 1 || p.Data[0].Count+p.Data[0].Value != val {
+			t.Errorf("Unexpected payload for %s: %+v", ezKey, p)
+		}
+	}
+
+}
+
+func TestDisable(t *testing.T) {
+
+	stats := NewPoolWithOptions(WithEndpoint(ts.URL), WithEZKey(EZKey), WithFlushInterval(time.Hour), WithDisabled())
+	if stats.Enabled() {
+		t.Error("Expected the pool to start disabled")
+	}
+
+	stats.Count("darts", 1)
+	stats.Gauge("players", 2)
+	stats.CountWithTags("darts", 1, Tag{"board", "1"})
+	stats.TimeCaller(time.Now())
+	b := stats.Batch()
+	b.Count("darts", 1)
+	b.Release()
+
+	stats.Enable()
+	stats.Count("darts", 3)
+	stats.Stop()
+
+	var p Payload
+	if err := json.Unmarshal(<-reqs, &p); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Data) != 1 || p.Data[0].Count != 3 {
+		t.Errorf("Expected only darts: 3, got: %+v", p.Data)
+	}
+
+}
+
+func TestPause(t *testing.T) {
+
+	var (
+		dropped int
+		stats   = NewPoolWithOptions(WithEndpoint(ts.URL), WithEZKey(EZKey), WithFlushInterval(time.Hour), WithPaused(), WithHoldLimit(2),
+			WithOnError(func(err error, n int) { dropped += n }))
+	)
+	if !stats.Paused() {
+		t.Error("Expected the pool to start paused")
+	}
+
+	// aggregates but holds each flush
+	for i := 1; i <= 3; i++ {
+		stats.Count("darts", 1)
+		stats.Count("darts", float64(i))
+		if err := stats.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case <-reqs:
+		t.Fatal("Expected nothing sent while paused")
+	default:
+	}
+	if dropped != 1 {
+		t.Errorf("Expected: 1 stat dropped over the hold limit, got: %d", dropped)
+	}
+
+	stats.Resume()
+	stopped := make(chan error)
+	go func() { stopped <- stats.Stop() }()
+
+	var counts []float64
+	for i := 0; i < 2; i++ {
+		var p Payload
+		if err := json.Unmarshal(<-reqs, &p); err != nil {
+			t.Fatal(err)
+		}
+		for _, stat := range p.Data {
+			counts = append(counts, stat.Count)
+		}
+	}
+	sort.Float64s(counts)
+	if !reflect.DeepEqual(counts, []float64{3, 4}) {
+		t.Errorf("Expected darts: 3 and 4, got: %v", counts)
+	}
+	if err := <-stopped; err != nil {
+		t.Error(err)
+	}
+
+}
+
+func TestStopUnsent(t *testing.T) {
+
+	throttling := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer throttling.Close()
+
+	for name, stats := range map[string]*Pool{
+		"paused":    NewPoolWithOptions(WithEndpoint(ts.URL), WithEZKey(EZKey), WithFlushInterval(time.Hour), WithPaused()),
+		"throttled": NewPoolWithOptions(WithEndpoint(throttling.URL), WithEZKey(EZKey), WithFlushInterval(time.Hour)),
+	} {
+		var dropped int
+		stats.OnError(func(err error, n int) { dropped += n })
+		stats.Count("darts", 1)
+		time.Sleep(10 * time.Millisecond)
+
+		err := stats.Stop()
+		var fe *FlushError
+		if !errors.As(err, &fe) || fe.FailedStats() != 1 || !errors.Is(err, ErrStoppedUnsent) {
+			t.Errorf("%s: expected 1 stat unsent, got: %v", name, err)
+		}
+		if dropped != 1 {
+			t.Errorf("%s: expected 1 stat dropped, got: %d", name, dropped)
+		}
+	}
+
+}
+
+func TestDryRun(t *testing.T) {
+
+	var (
+		buf   = &bytes.Buffer{}
+		stats = NewPoolWithOptions(WithEndpoint("http://127.0.0.1:1"), WithEZKey(EZKey), WithFlushInterval(time.Hour), WithChunkSize(2), WithDryRun(buf))
+	)
+	stats.Count("darts", 1)
+	stats.Count("darts", 2)
+	stats.Count("players", 1)
+	stats.Value("score", 180, time.Now())
+	if err := stats.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		dec      = json.NewDecoder(buf)
+		payloads int
+		n        int
+	)
+	for dec.More() {
+		var p Payload
+		if err := dec.Decode(&p); err != nil {
+			t.Fatal(err)
+		}
+		if p.EZKey != EZKey {
+			t.Errorf("Expected: %s, got: %s", EZKey, p.EZKey)
+		}
+		for _, stat := range p.Data {
+			if stat.Key == "darts" && stat.Count != 3 {
+				t.Errorf("Expected darts: 3, got: %g", stat.Count)
+			}
+		}
+		payloads++
+		n += len(p.Data)
+	}
+	if payloads != 2 || n != 3 {
+		t.Errorf("Expected: 3 stats in 2 payloads, got: %d in %d", n, payloads)
+	}
+
+}
+
+type fakeClock struct {
+	now  time.Time
+	tick chan time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Ticker(time.Duration) (<-chan time.Time, func()) {
+	return c.tick, func() {}
+}
+
+func TestClock(t *testing.T) {
+
+	var (
+		clock = &fakeClock{now: time.Unix(1500000000, 0), tick: make(chan time.Time)}
+		stats = NewPoolWithOptions(WithEndpoint(ts.URL), WithEZKey(EZKey), WithFlushInterval(time.Hour), WithClock(clock))
+	)
+	defer stats.Stop()
+
+	stats.Gauge("players", 2)
+	stats.DurationSince("game", clock.now.Add(-time.Second))
+	clock.now = clock.now.Add(time.Minute)
+	stats.Count("darts", 1)
+
+	// flushes only when the clock ticks
+	clock.tick <- clock.now
+	var p Payload
+	if err := json.Unmarshal(<-reqs, &p); err != nil {
+		t.Fatal(err)
+	}
+	for _, stat := range p.Data {
+		switch stat.Key {
+		case "players":
+			if stat.Timestamp != 1500000000 {
+				t.Errorf("Expected players at: 1500000000, got: %d", stat.Timestamp)
+			}
+		case "game":
+			if stat.Value != 1000 {
+				t.Errorf("Expected game: 1000ms, got: %g", stat.Value)
+			}
+		case "darts":
+			if stat.Timestamp != 1500000060 {
+				t.Errorf("Expected darts at: 1500000060, got: %d", stat.Timestamp)
+			}
+		}
+	}
+	if len(p.Data) != 3 {
+		t.Errorf("Expected: 3 stats, got: %d", len(p.Data))
+	}
+
+}
+
+func TestSetFlushInterval(t *testing.T) {
+
+	stats := NewPoolWithOptions(WithEndpoint(ts.URL), WithEZKey(EZKey), WithFlushInterval(time.Hour))
+	defer stats.Stop()
+
+	stats.SetFlushInterval(0)
+	stats.Count("darts", 1)
+	stats.SetFlushInterval(10 * time.Millisecond)
+
+	select {
+	case body := <-reqs:
+		var p Payload
+		if err := json.Unmarshal(body, &p); err != nil {
+			t.Fatal(err)
+		}
+		if len(p.Data) != 1 || p.Data[0].Key != "darts" {
+			t.Errorf("Expected: darts, got: %v", p.Data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a flush after shortening the interval")
+	}
+
+	stats.SetFlushInterval(time.Hour)
+	if d := time.Duration(atomic.LoadInt64(&stats.interval)); d != time.Hour {
+		t.Errorf("Expected interval: 1h, got: %s", d)
+	}
+
+}
+
+func TestAdaptiveFlush(t *testing.T) {
+
+	stats := NewPoolWithOptions(WithEndpoint(ts.URL), WithEZKey(EZKey), WithFlushInterval(time.Hour), WithAdaptiveFlush(10*time.Millisecond, time.Hour, 2))
+	defer stats.Stop()
+
+	// a backlog drops the interval to the minimum
+	stats.Count("darts", 1)
+	stats.Count("pool", 1)
+	select {
+	case body := <-reqs:
+		var p Payload
+		if err := json.Unmarshal(body, &p); err != nil {
+			t.Fatal(err)
+		}
+		if len(p.Data) != 2 {
+			t.Errorf("Expected: 2 stats, got: %d", len(p.Data))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a flush once the backlog reached the threshold")
+	}
+
+	// the interval follows each flush, within the bounds
+	idle := NewPoolWithOptions(WithEZKey(EZKey), WithFlushInterval(10*time.Millisecond), WithAdaptiveFlush(10*time.Millisecond, 40*time.Millisecond, 2))
+	for _, c := range []struct {
+		flushed  int
+		interval time.Duration
+	}{
+		{0, 20 * time.Millisecond},
+		{1, 20 * time.Millisecond},
+		{0, 40 * time.Millisecond},
+		{0, 40 * time.Millisecond},
+		{5, 20 * time.Millisecond},
+		{5, 10 * time.Millisecond},
+		{5, 10 * time.Millisecond},
+	} {
+		idle.adaptFlushed(c.flushed)
+		if d := idle.FlushInterval(); d != c.interval {
+			t.Errorf("Expected interval: %s after flushing %d, got: %s", c.interval, c.flushed, d)
+		}
+	}
+
+	idle.SetAdaptiveFlush(0, 0, 0)
+	idle.adaptFlushed(0)
+	if d := idle.FlushInterval(); d != 10*time.Millisecond {
+		t.Errorf("Expected interval: 10ms when not adapting, got: %s", d)
+	}
+
+}
+
+func TestKeySanitizer(t *testing.T) {
+
+	long := strings.Repeat("é", 200)
+	for _, c := range []struct {
+		s        KeySanitizer
+		key, out string
+	}{
+		{KeySanitizer{}, "api.requests", "api.requests"},
+		{KeySanitizer{}, "  api  requests\t200 ", "api requests 200"},
+		{KeySanitizer{}, "api\x00requests\xff", "api_requests_"},
+		{KeySanitizer{Replacement: "-"}, "api\nrequests\x07", "api requests-"},
+		{KeySanitizer{Lowercase: true}, "API.Requests", "api.requests"},
+		{KeySanitizer{MaxLength: 20}, "api.requests.by.customer.id", "api.request.0a9fcb11"},
+		{KeySanitizer{}, "\t\n", ""},
+	} {
+		if out := c.s.Sanitize(c.key); out != c.out {
+			t.Errorf("Expected %q: %q, got: %q", c.key, c.out, out)
+		}
+	}
+	if out := (KeySanitizer{}).Sanitize(long); len(out) > DefaultMaxKeyLength || !utf8.ValidString(out) {
+		t.Errorf("Expected a valid key of at most %d bytes, got: %q", DefaultMaxKeyLength, out)
+	}
+
+	var invalid []string
+	stats := NewPoolWithOptions(WithEndpoint(ts.URL), WithEZKey(EZKey), WithFlushInterval(time.Hour), WithKeySanitizer(KeySanitizer{Lowercase: true}))
+	stats.OnInvalidKey(func(key, sanitized string) {
+		invalid = append(invalid, key+" => "+sanitized)
+	})
+	stats.Count("Darts", 1)
+	stats.Count("darts", 1)
+	stats.Count(" ", 1)
+	stats.Stop()
+
+	var p Payload
+	if err := json.Unmarshal(<-reqs, &p); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Data) != 1 || p.Data[0].Key != "darts" || p.Data[0].Count != 2 {
+		t.Errorf("Expected darts: 2, got: %+v", p.Data)
+	}
+	if expected := []string{"Darts => darts", "  => "}; !reflect.DeepEqual(invalid, expected) {
+		t.Errorf("Expected: %q, got: %q", expected, invalid)
+	}
+
+}
+
+func TestCardinalityLimit(t *testing.T) {
+
+	stats := NewPoolWithOptions(WithEndpoint(ts.URL), WithEZKey(EZKey), WithFlushInterval(time.Hour), WithCardinalityLimit(2, 3, FoldNewKeys))
+
+	flush := func() map[string]float64 {
+		stats.Flush()
+		var p Payload
+		if err := json.Unmarshal(<-reqs, &p); err != nil {
+			t.Fatal(err)
+		}
+		got := map[string]float64{}
+		for _, stat := range p.Data {
+			got[stat.Key] = stat.Count
+		}
+		return got
+	}
+
+	for _, key := range []string{"user1", "user2", "user1", "user3", "user4"} {
+		stats.Count(key, 1)
+	}
+	if got, expected := flush(), map[string]float64{"user1": 2, "user2": 1, OtherKey: 2}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected: %v, got: %v", expected, got)
+	}
+
+	// a new interval allows new keys until the total is reached
+	for _, key := range []string{"user1", "user3", "user4"} {
+		stats.Count(key, 1)
+	}
+	if got, expected := flush(), map[string]float64{"user1": 1, "user3": 1, OtherKey: 1, suppressedKeysKey: 2}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected: %v, got: %v", expected, got)
+	}
+
+	// suppressed keys are counted with the next flush
+	stats.SetCardinalityLimit(1, 0, DropNewKeys)
+	stats.Count("user1", 1)
+	stats.Count("user2", 1)
+	if got, expected := flush(), map[string]float64{"user1": 1, suppressedKeysKey: 1}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected: %v, got: %v", expected, got)
+	}
+	stats.Stop()
+	<-reqs
+
+}
+
+func TestRelabelRules(t *testing.T) {
+
+	stats := NewPoolWithOptions(WithEndpoint(ts.URL), WithEZKey(EZKey), WithFlushInterval(time.Hour), WithRelabelRules(
+		RelabelRule{Match: ExactMatch, Pattern: "darts.thrown", Replacement: "darts"},
+		RelabelRule{Match: PrefixMatch, Pattern: "debug.", Drop: true},
+		RelabelRule{Match: PrefixMatch, Pattern: "old.", Replacement: "new."},
+		RelabelRule{Match: RegexMatch, Pattern: `^player\.\d+\.(\w+)$`, Replacement: "player.$1"},
+	))
+	if err := stats.SetRelabelRules(RelabelRule{Match: RegexMatch, Pattern: "("}); err == nil {
+		t.Error("Expected an invalid regex to be rejected")
+	}
+
+	stats.Count("darts", 1)
+	stats.Count("darts.thrown", 2)
+	stats.Count("debug.cache", 1)
+	stats.Value("old.score", 180, time.Now())
+	stats.Count("player.1.wins", 1)
+	stats.Count("player.2.wins", 1)
+	stats.Stop()
+
+	var p Payload
+	if err := json.Unmarshal(<-reqs, &p); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]float64{}
+	for _, stat := range p.Data {
+		got[stat.Key] = stat.Count + stat.Value
+	}
+	if expected := map[string]float64{"darts": 3, "new.score": 180, "player.wins": 2}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected: %v, got: %v", expected, got)
+	}
+
+}
+
+func TestFilterPool(t *testing.T) {
+
+	var (
+		rec        = NewRecorderPool()
+		stats, err = NewFilterPool(rec, []string{"api.*", `/^db\.(reads|writes)$/`}, []string{"api.debug.*", "api.?.trace"})
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"api.requests", "api.debug.cache", "api.1.trace", "api.12.trace", "db.reads", "db.deletes", "queue.depth"} {
+		stats.Count(key, 1)
+	}
+	stats.Gauge("api.conns", 3)
+
+	if keys, expected := rec.Keys(), []string{"api.12.trace", "api.conns", "api.requests", "db.reads"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected: %v, got: %v", expected, keys)
+	}
+
+	if _, err := NewFilterPool(rec, nil, []string{"/(/"}); err == nil {
+		t.Error("Expected an invalid regex to be rejected")
+	}
+
+}
+
+func TestInstance(t *testing.T) {
+
+	for _, c := range []struct {
+		opts     []Option
+		expected map[string]float64
+	}{
+		{[]Option{WithInstanceID("web1")}, map[string]float64{"darts.web1": 2, "score.web1": 180}},
+		{[]Option{WithInstanceID("web1"), WithInstanceTag("host")}, map[string]float64{"darts.host=web1": 2, "score.host=web1": 180}},
+		{[]Option{WithInstanceID("web1"), WithInstanceAggregate()}, map[string]float64{"darts.web1": 2, "score.web1": 180, "darts": 2, "score": 180}},
+		{[]Option{WithHostSuffix()}, map[string]float64{"darts." + shortHostname(): 2, "score." + shortHostname(): 180}},
+	} {
+		stats := NewPoolWithOptions(append(c.opts, WithEndpoint(ts.URL), WithEZKey(EZKey), WithFlushInterval(time.Hour))...)
+		stats.Count("darts", 2)
+		stats.Value("score", 180, time.Now())
+		stats.Stop()
+
+		var p Payload
+		if err := json.Unmarshal(<-reqs, &p); err != nil {
+			t.Fatal(err)
+		}
+		got := map[string]float64{}
+		for _, stat := range p.Data {
+			got[stat.Key] = stat.Count + stat.Value
+		}
+		if !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("Expected: %v, got: %v", c.expected, got)
+		}
+	}
+
+}
+
+func TestNewPoolFromEnv(t *testing.T) {
+
+	env := map[string]string{
+		"STATPOOL_ENDPOINT":       "http://127.0.0.1:1, " + ts.URL,
+		"STATPOOL_EZKEY":          EZKey,
+		"STATPOOL_PREFIX":         "api.",
+		"STATPOOL_FLUSH_INTERVAL": "1h",
+		"STATPOOL_CHUNK_SIZE":     "10",
+		"STATPOOL_BUFFER_SIZE":    "16",
+		"STATPOOL_ENABLED":        "false",
+	}
+	for k, v := range env {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	stats, err := NewPoolFromEnv(WithLogger(log.New(ioutil.Discard, "", 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.ezKey != EZKey || stats.prefix != "api." || stats.interval != int64(time.Hour) || stats.chunkSize != 10 || cap(stats.count) != 16 || len(stats.endpoints) != 2 {
+		t.Errorf("Unexpected configuration: %+v", stats)
+	}
+	if stats.Enabled() {
+		t.Error("Expected the pool to be disabled")
+	}
+
+	os.Setenv("STATPOOL_FLUSH_INTERVAL", "soon")
+	if _, err := NewPoolFromEnv(); err == nil || !strings.Contains(err.Error(), "STATPOOL_FLUSH_INTERVAL") {
+		t.Errorf("Expected an invalid flush interval error, got: %v", err)
+	}
+
+}
+
+func TestWatchConfig(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "statpool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "stats.json")
+	write := func(config string) {
+		// a new size so the change is seen however coarse the mtime
+		if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"prefix": "api.", "flush_interval": "1m", "deny": ["api.debug.*"], "sample_rates": {"api.queries": 0}}`)
+
+	var (
+		errs  = make(chan error, 10)
+		stats = NewPoolWithOptions(WithOnError(func(err error, _ int) { errs <- err }))
+	)
+	stop, err := stats.WatchConfig(path, 5*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	if stats.prefix != "api." || stats.interval != int64(time.Minute) {
+		t.Errorf("Expected prefix: api. and interval: 1m, got: %s and %s", stats.prefix, time.Duration(stats.interval))
+	}
+	if stats.filtered("api.debug.cache") || !stats.filtered("api.requests") {
+		t.Error("Expected api.debug.* to be denied")
+	}
+	if _, ok := stats.sample("api.queries", 1); ok {
+		t.Error("Expected api.queries to be sampled out")
+	}
+
+	write(`{"prefix": "api.", "flush_interval": "1m", "deny": ["api.requests"]}`)
+	deadline := time.Now().Add(time.Second)
+	for !stats.filtered("api.debug.cache") && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !stats.filtered("api.debug.cache") || stats.filtered("api.requests") {
+		t.Error("Expected the reloaded filter to deny only api.requests")
+	}
+	if _, ok := stats.sample("api.queries", 1); !ok {
+		t.Error("Expected api.queries to be sampled at the rate passed")
+	}
+
+	// a bad config leaves the last in place
+	write(`{"deny": ["/(/"]}`)
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "config not reloaded") {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected the bad config to be reported")
+	}
+	if stats.filtered("api.requests") {
+		t.Error("Expected the last good filter to stay in place")
+	}
+
+}
@@ -0,0 +1,136 @@
+// Package statpoolcloudwatch sends a statpool.Pool's aggregated stats
+// to Amazon CloudWatch with PutMetricData, so services on Lambda or
+// ECS can report without a sidecar.  It is kept apart from statpool
+// so only programs using the AWS SDK depend on it.
+//
+//	client := cloudwatch.NewFromConfig(cfg)
+//	pool := statpool.NewPoolWithOptions(
+//		statpool.WithFlushInterval(time.Minute),
+//		statpool.WithChunkSize(statpoolcloudwatch.MaxMetricsPerRequest),
+//		statpool.WithSender(statpoolcloudwatch.New(client, "MyService")),
+//	)
+//
+// Tags become dimensions, and are trimmed from the flattened key to
+// give the metric name.
+package statpoolcloudwatch
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/jasonmoo/statpool"
+)
+
+const (
+	// MaxMetricsPerRequest is the most metrics PutMetricData accepts
+	MaxMetricsPerRequest = 1000
+
+	// MaxDimensions is the most dimensions a metric can have, further
+	// tags are dropped
+	MaxDimensions = 30
+)
+
+// PutMetricDataAPI is the method of *cloudwatch.Client used
+type PutMetricDataAPI interface {
+	PutMetricData(ctx context.Context, params *cloudwatch.PutMetricDataInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.PutMetricDataOutput, error)
+}
+
+type Sender struct {
+	client    PutMetricDataAPI
+	namespace string
+
+	// StorageResolution is 1 to store metrics at one second
+	// resolution, zero leaves CloudWatch's default of 60
+	StorageResolution int32
+
+	// TagSeparator is the pool's tag separator, used to trim
+	// flattened tags from keys
+	TagSeparator string
+}
+
+var _ statpool.Sender = (*Sender)(nil)
+
+func New(client PutMetricDataAPI, namespace string) *Sender {
+	return &Sender{
+		client:       client,
+		namespace:    namespace,
+		TagSeparator: statpool.DefaultTagSeparator,
+	}
+}
+
+// Send puts the stats in as few requests as PutMetricData's limit
+// allows.  Requests already made aren't undone if a later one fails.
+func (s *Sender) Send(ctx context.Context, stats []interface{}) error {
+
+	data := make([]types.MetricDatum, 0, len(stats))
+	now := time.Now()
+	for _, stat := range stats {
+		switch st := stat.(type) {
+		case *statpool.CountStat:
+			data = append(data, s.datum(st.Key, st.Count, st.Timestamp, st.Tags, types.StandardUnitCount, now))
+		case *statpool.ValueStat:
+			data = append(data, s.datum(st.Key, st.Value, st.Timestamp, st.Tags, types.StandardUnitNone, now))
+		}
+	}
+
+	for len(data) > 0 {
+		n := len(data)
+		if n > MaxMetricsPerRequest {
+			n = MaxMetricsPerRequest
+		}
+		_, err := s.client.PutMetricData(ctx, &cloudwatch.PutMetricDataInput{
+			Namespace:  aws.String(s.namespace),
+			MetricData: data[:n],
+		})
+		if err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+
+}
+
+func (s *Sender) datum(key string, val float64, ts int64, tags []statpool.Tag, unit types.StandardUnit, now time.Time) types.MetricDatum {
+
+	t := now
+	if ts != 0 {
+		t = time.Unix(ts, 0)
+	}
+	d := types.MetricDatum{
+		MetricName: aws.String(s.metricName(key, tags)),
+		Value:      aws.Float64(val),
+		Timestamp:  aws.Time(t),
+		Unit:       unit,
+	}
+	if s.StorageResolution != 0 {
+		d.StorageResolution = aws.Int32(s.StorageResolution)
+	}
+	for i, tag := range tags {
+		if i == MaxDimensions {
+			break
+		}
+		d.Dimensions = append(d.Dimensions, types.Dimension{Name: aws.String(tag.Key), Value: aws.String(tag.Value)})
+	}
+	return d
+
+}
+
+// metricName trims the tags the pool flattened into key
+func (s *Sender) metricName(key string, tags []statpool.Tag) string {
+	if len(tags) == 0 {
+		return key
+	}
+	sorted := append([]statpool.Tag(nil), tags...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Key < sorted[j].Key })
+	var suffix strings.Builder
+	for _, t := range sorted {
+		suffix.WriteString(s.TagSeparator + t.Key + "=" + t.Value)
+	}
+	return strings.TrimSuffix(key, suffix.String())
+}
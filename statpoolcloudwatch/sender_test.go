@@ -0,0 +1,65 @@
+package statpoolcloudwatch
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/jasonmoo/statpool"
+)
+
+type fakeClient struct {
+	inputs []*cloudwatch.PutMetricDataInput
+}
+
+func (c *fakeClient) PutMetricData(_ context.Context, in *cloudwatch.PutMetricDataInput, _ ...func(*cloudwatch.Options)) (*cloudwatch.PutMetricDataOutput, error) {
+	c.inputs = append(c.inputs, in)
+	return &cloudwatch.PutMetricDataOutput{}, nil
+}
+
+func TestSender(t *testing.T) {
+
+	client := &fakeClient{}
+	s := New(client, "Darts")
+	s.StorageResolution = 1
+
+	stats := []interface{}{
+		&statpool.CountStat{Key: "throws.hand=left", Count: 3, Tags: []statpool.Tag{statpool.T("hand", "left")}},
+		&statpool.ValueStat{Key: "score", Value: 180, Timestamp: 1500000000},
+	}
+	for i := 0; i < MaxMetricsPerRequest; i++ {
+		stats = append(stats, &statpool.CountStat{Key: "key" + strconv.Itoa(i), Count: 1})
+	}
+	if err := s.Send(context.Background(), stats); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(client.inputs) != 2 {
+		t.Fatalf("Expected: 2 requests, got: %d", len(client.inputs))
+	}
+	if n := len(client.inputs[0].MetricData) + len(client.inputs[1].MetricData); n != MaxMetricsPerRequest+2 {
+		t.Errorf("Expected: %d metrics, got: %d", MaxMetricsPerRequest+2, n)
+	}
+
+	in := client.inputs[0]
+	if aws.ToString(in.Namespace) != "Darts" {
+		t.Errorf("Expected namespace: Darts, got: %s", aws.ToString(in.Namespace))
+	}
+	throws := in.MetricData[0]
+	if aws.ToString(throws.MetricName) != "throws" || aws.ToFloat64(throws.Value) != 3 {
+		t.Errorf("Unexpected datum: %s %g", aws.ToString(throws.MetricName), aws.ToFloat64(throws.Value))
+	}
+	if len(throws.Dimensions) != 1 || aws.ToString(throws.Dimensions[0].Name) != "hand" || aws.ToString(throws.Dimensions[0].Value) != "left" {
+		t.Errorf("Unexpected dimensions: %+v", throws.Dimensions)
+	}
+	if throws.StorageResolution == nil || *throws.StorageResolution != 1 {
+		t.Error("Expected a storage resolution of 1")
+	}
+	if score := in.MetricData[1]; !score.Timestamp.Equal(time.Unix(1500000000, 0)) {
+		t.Errorf("Unexpected timestamp: %s", score.Timestamp)
+	}
+
+}
@@ -0,0 +1,93 @@
+// Package statpoolexpvar reports expvar variables to a
+// statpool.Stater.  It is kept apart from statpool since importing
+// expvar registers /debug/vars on http.DefaultServeMux.
+//
+//	stop := statpoolexpvar.Collector(pool, time.Minute, statpoolexpvar.Names("conns", "cache"))
+//	defer stop()
+package statpoolexpvar
+
+import (
+	"expvar"
+	"strconv"
+	"time"
+
+	"github.com/jasonmoo/statpool"
+)
+
+// Collector reports the published expvar variables accepted by
+// match to s as values every interval, until the returned stop
+// function is called.  Ints, Floats and Funcs returning numbers are
+// reported under their names.  Members of Maps are reported as
+// name.member when the map's name or name.member matches, and other
+// variables are reported if their string form is a number.
+func Collector(s statpool.Stater, interval time.Duration, match func(name string) bool) (stop func()) {
+
+	done := make(chan struct{})
+
+	go func() {
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				report(s, match)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// Names matches the expvar variables named
+func Names(names ...string) func(name string) bool {
+	set := map[string]bool{}
+	for _, name := range names {
+		set[name] = true
+	}
+	return func(name string) bool { return set[name] }
+}
+
+func report(s statpool.Stater, match func(string) bool) {
+	now := time.Now()
+	expvar.Do(func(kv expvar.KeyValue) {
+		m, isMap := kv.Value.(*expvar.Map)
+		if !isMap {
+			if val, ok := expvarValue(kv.Value); ok && match(kv.Key) {
+				s.Value(kv.Key, val, now)
+			}
+			return
+		}
+		all := match(kv.Key)
+		m.Do(func(member expvar.KeyValue) {
+			key := kv.Key + "." + member.Key
+			if val, ok := expvarValue(member.Value); ok && (all || match(key)) {
+				s.Value(key, val, now)
+			}
+		})
+	})
+}
+
+func expvarValue(v expvar.Var) (float64, bool) {
+	switch v := v.(type) {
+	case *expvar.Int:
+		return float64(v.Value()), true
+	case *expvar.Float:
+		return v.Value(), true
+	case expvar.Func:
+		switch n := v.Value().(type) {
+		case int:
+			return float64(n), true
+		case int64:
+			return float64(n), true
+		case uint64:
+			return float64(n), true
+		case float64:
+			return n, true
+		}
+		return 0, false
+	}
+	val, err := strconv.ParseFloat(v.String(), 64)
+	return val, err == nil
+}
@@ -0,0 +1,37 @@
+package statpoolexpvar
+
+import (
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/jasonmoo/statpool"
+)
+
+func TestCollector(t *testing.T) {
+
+	expvar.NewInt("conns").Set(3)
+	expvar.NewFloat("ignored").Set(1)
+	m := expvar.NewMap("cache")
+	m.Add("hits", 10)
+	m.Add("misses", 2)
+	expvar.Publish("uptime", expvar.Func(func() interface{} { return 5.5 }))
+
+	stats := statpool.NewRecorderPool()
+	report(stats, Names("conns", "cache.hits", "uptime"))
+
+	expected := map[string]float64{"conns": 3, "cache.hits": 10, "uptime": 5.5}
+	for key, val := range expected {
+		if vals := stats.ValuesFor(key); len(vals) != 1 || vals[0] != val {
+			t.Errorf("Expected %s: [%g], got: %v", key, val, vals)
+		}
+	}
+	if keys := stats.Keys(); len(keys) != 3 {
+		t.Errorf("Expected: 3 keys, got: %v", keys)
+	}
+
+	stop := Collector(statpool.NewNilPool(), time.Millisecond, func(string) bool { return true })
+	time.Sleep(5 * time.Millisecond)
+	stop()
+
+}
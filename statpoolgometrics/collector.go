@@ -0,0 +1,120 @@
+// Package statpoolgometrics reports the metrics in a
+// github.com/rcrowley/go-metrics Registry to a statpool.Stater, so
+// code instrumented with go-metrics can report without a bridge of
+// its own.  It is kept apart from statpool so only programs using
+// go-metrics depend on it.
+//
+//	stop := statpoolgometrics.Collector(metrics.DefaultRegistry, pool, time.Minute)
+//	defer stop()
+package statpoolgometrics
+
+import (
+	"time"
+
+	"github.com/jasonmoo/statpool"
+	metrics "github.com/rcrowley/go-metrics"
+)
+
+// the percentiles reported for timers and histograms
+var percentiles = []float64{0.5, 0.75, 0.95, 0.99}
+
+var percentileKeys = []string{".p50", ".p75", ".p95", ".p99"}
+
+type gauger interface {
+	Gauge(key string, val float64)
+}
+
+// Collector reports every metric in r to s every interval until the
+// returned stop function is called:
+//
+//	counters      counted by how much they changed in the interval
+//	gauges        reported as gauges when s has them
+//	meters        name counting marks, and name.rate1, name.rate5,
+//	              name.rate15 and name.mean_rate gauges
+//	histograms    name counting updates, and name.min, name.max,
+//	              name.mean and name.p50 to name.p99 gauges
+//	timers        as histograms, with times in milliseconds
+func Collector(r metrics.Registry, s statpool.Stater, interval time.Duration) (stop func()) {
+
+	var (
+		done = make(chan struct{})
+		c    = &collector{s: s, last: map[string]int64{}}
+	)
+
+	go func() {
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				c.collect(r)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+type collector struct {
+	s statpool.Stater
+	// cumulative counts at the last collection, by name
+	last map[string]int64
+}
+
+func (c *collector) collect(r metrics.Registry) {
+	r.Each(func(name string, i interface{}) {
+		switch m := i.(type) {
+		case metrics.Counter:
+			c.count(name, m.Count())
+		case metrics.Gauge:
+			c.gauge(name, float64(m.Value()))
+		case metrics.GaugeFloat64:
+			c.gauge(name, m.Value())
+		case metrics.Timer:
+			t := m.Snapshot()
+			c.count(name, t.Count())
+			c.distribution(name, float64(t.Min()), float64(t.Max()), t.Mean(), t.Percentiles(percentiles), float64(time.Millisecond))
+		case metrics.Meter:
+			mt := m.Snapshot()
+			c.count(name, mt.Count())
+			c.gauge(name+".rate1", mt.Rate1())
+			c.gauge(name+".rate5", mt.Rate5())
+			c.gauge(name+".rate15", mt.Rate15())
+			c.gauge(name+".mean_rate", mt.RateMean())
+		case metrics.Histogram:
+			h := m.Snapshot()
+			c.count(name, h.Count())
+			c.distribution(name, float64(h.Min()), float64(h.Max()), h.Mean(), h.Percentiles(percentiles), 1)
+		}
+	})
+}
+
+// count reports the change in a cumulative count since the last
+// collection
+func (c *collector) count(name string, total int64) {
+	if delta := total - c.last[name]; delta != 0 {
+		c.s.Count(name, float64(delta))
+	}
+	c.last[name] = total
+}
+
+func (c *collector) gauge(name string, val float64) {
+	if g, ok := c.s.(gauger); ok {
+		g.Gauge(name, val)
+	} else {
+		c.s.Value(name, val, time.Now())
+	}
+}
+
+// distribution reports the summary of a timer or histogram, with
+// values divided by unit
+func (c *collector) distribution(name string, min, max, mean float64, ps []float64, unit float64) {
+	c.gauge(name+".min", min/unit)
+	c.gauge(name+".max", max/unit)
+	c.gauge(name+".mean", mean/unit)
+	for i, p := range ps {
+		c.gauge(name+percentileKeys[i], p/unit)
+	}
+}
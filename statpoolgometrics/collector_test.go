@@ -0,0 +1,74 @@
+package statpoolgometrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jasonmoo/statpool"
+	metrics "github.com/rcrowley/go-metrics"
+)
+
+type (
+	// fakes embed the go-metrics interfaces for the methods they
+	// don't use
+	fakeRegistry struct {
+		metrics.Registry
+		metrics map[string]interface{}
+	}
+	fakeCounter struct {
+		metrics.Counter
+		n int64
+	}
+	fakeGauge struct {
+		metrics.GaugeFloat64
+		v float64
+	}
+	fakeTimer struct {
+		metrics.Timer
+	}
+)
+
+func (r fakeRegistry) Each(fn func(string, interface{})) {
+	for name, m := range r.metrics {
+		fn(name, m)
+	}
+}
+
+func (c *fakeCounter) Count() int64                    { return c.n }
+func (g *fakeGauge) Value() float64                    { return g.v }
+func (t fakeTimer) Snapshot() metrics.Timer            { return t }
+func (t fakeTimer) Count() int64                       { return 4 }
+func (t fakeTimer) Min() int64                         { return int64(time.Millisecond) }
+func (t fakeTimer) Max() int64                         { return int64(4 * time.Millisecond) }
+func (t fakeTimer) Mean() float64                      { return float64(2500 * time.Microsecond) }
+func (t fakeTimer) Percentiles(ps []float64) []float64 { return make([]float64, len(ps)) }
+
+func TestCollector(t *testing.T) {
+
+	counter := &fakeCounter{n: 5}
+	r := fakeRegistry{metrics: map[string]interface{}{
+		"requests": counter,
+		"load":     &fakeGauge{v: 0.5},
+		"query":    fakeTimer{},
+	}}
+	s := statpool.NewRecorderPool()
+	c := &collector{s: s, last: map[string]int64{}}
+
+	c.collect(r)
+	counter.n = 8
+	c.collect(r)
+
+	if n := s.CountFor("requests"); n != 8 {
+		t.Errorf("Expected requests: 8, got: %g", n)
+	}
+	if n := s.CountFor("query"); n != 4 {
+		t.Errorf("Expected query: 4, got: %g", n)
+	}
+	if vals := s.ValuesFor("load"); len(vals) != 2 || vals[0] != 0.5 {
+		t.Errorf("Expected load: [0.5 0.5], got: %v", vals)
+	}
+	if vals := s.ValuesFor("query.mean"); len(vals) == 0 || vals[0] != 2.5 {
+		t.Errorf("Expected query.mean: 2.5, got: %v", vals)
+	}
+
+}
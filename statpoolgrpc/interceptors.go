@@ -0,0 +1,58 @@
+// Package statpoolgrpc reports gRPC server calls to a statpool.Stater.
+// It is kept apart from statpool so only programs using gRPC depend
+// on it.
+//
+//	server := grpc.NewServer(
+//		grpc.UnaryInterceptor(statpoolgrpc.UnaryServerInterceptor(pool)),
+//		grpc.StreamInterceptor(statpoolgrpc.StreamServerInterceptor(pool)),
+//	)
+//
+// Each method, e.g. /pkg.Service/Method, is recorded as
+//
+//	grpc.pkg.Service.Method.calls    count of calls
+//	grpc.pkg.Service.Method          duration of calls
+//	grpc.pkg.Service.Method.errors   count of calls returning an error
+package statpoolgrpc
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jasonmoo/statpool"
+	"google.golang.org/grpc"
+)
+
+func UnaryServerInterceptor(s statpool.Stater) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		record(s, info.FullMethod, time.Since(start), err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor records streams as calls lasting until
+// the handler returns
+func StreamServerInterceptor(s statpool.Stater) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		record(s, info.FullMethod, time.Since(start), err)
+		return err
+	}
+}
+
+func record(s statpool.Stater, fullMethod string, d time.Duration, err error) {
+	key := methodKey(fullMethod)
+	s.Count(key+".calls", 1)
+	s.Duration(key, d)
+	if err != nil {
+		s.Count(key+".errors", 1)
+	}
+}
+
+// methodKey turns /pkg.Service/Method into grpc.pkg.Service.Method
+func methodKey(fullMethod string) string {
+	return "grpc." + strings.Replace(strings.TrimPrefix(fullMethod, "/"), "/", ".", -1)
+}
@@ -0,0 +1,94 @@
+package statpoolgrpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jasonmoo/statpool"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMethodKey(t *testing.T) {
+	if key := methodKey("/darts.Board/Throw"); key != "grpc.darts.Board.Throw" {
+		t.Errorf("Expected: grpc.darts.Board.Throw, got: %s", key)
+	}
+}
+
+// fakeStream stands in for a stream the handlers never use
+type fakeStream struct {
+	grpc.ServerStream
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+
+	var (
+		rec         = statpool.NewRecorderPool()
+		interceptor = UnaryServerInterceptor(rec)
+		info        = &grpc.UnaryServerInfo{FullMethod: "/darts.Board/Throw"}
+		ok          = func(ctx context.Context, req interface{}) (interface{}, error) {
+			time.Sleep(time.Millisecond)
+			return "hit", nil
+		}
+		missed = func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, status.Error(codes.NotFound, "missed the board")
+		}
+	)
+
+	resp, err := interceptor(context.Background(), "throw", info, ok)
+	if resp != "hit" || err != nil {
+		t.Errorf("Expected the handler's response, got: %v, %v", resp, err)
+	}
+	if _, err := interceptor(context.Background(), "throw", info, missed); err == nil {
+		t.Error("Expected the handler's error")
+	}
+
+	if n := rec.CountFor("grpc.darts.Board.Throw.calls"); n != 2 {
+		t.Errorf("Expected: 2 calls, got: %g", n)
+	}
+	if n := rec.CountFor("grpc.darts.Board.Throw.errors"); n != 1 {
+		t.Errorf("Expected: 1 error, got: %g", n)
+	}
+	durations := rec.DurationsFor("grpc.darts.Board.Throw")
+	if len(durations) != 2 || durations[0] < time.Millisecond {
+		t.Errorf("Expected: 2 durations, the first at least 1ms, got: %v", durations)
+	}
+
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+
+	var (
+		rec         = statpool.NewRecorderPool()
+		interceptor = StreamServerInterceptor(rec)
+		info        = &grpc.StreamServerInfo{FullMethod: "/darts.Board/Watch", IsServerStream: true}
+		ok          = func(srv interface{}, ss grpc.ServerStream) error {
+			time.Sleep(time.Millisecond)
+			return nil
+		}
+		missed = func(srv interface{}, ss grpc.ServerStream) error {
+			return status.Error(codes.NotFound, "no such board")
+		}
+	)
+
+	if err := interceptor(nil, fakeStream{}, info, ok); err != nil {
+		t.Error(err)
+	}
+	if err := interceptor(nil, fakeStream{}, info, missed); err == nil {
+		t.Error("Expected the handler's error")
+	}
+
+	if n := rec.CountFor("grpc.darts.Board.Watch.calls"); n != 2 {
+		t.Errorf("Expected: 2 calls, got: %g", n)
+	}
+	if n := rec.CountFor("grpc.darts.Board.Watch.errors"); n != 1 {
+		t.Errorf("Expected: 1 error, got: %g", n)
+	}
+	durations := rec.DurationsFor("grpc.darts.Board.Watch")
+	if len(durations) != 2 || durations[0] < time.Millisecond {
+		t.Errorf("Expected: 2 durations, the first at least 1ms, got: %v", durations)
+	}
+
+}
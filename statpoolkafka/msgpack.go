@@ -0,0 +1,92 @@
+package statpoolkafka
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// appendMessage encodes a message as a msgpack map with the same
+// fields as its json
+func appendMessage(b []byte, m message) []byte {
+	n := 4
+	if len(m.Tags) > 0 {
+		n++
+	}
+	b = appendMapLen(b, n)
+	b = appendString(b, "key")
+	b = appendString(b, m.Key)
+	b = appendString(b, "type")
+	b = appendString(b, m.Type)
+	b = appendString(b, "value")
+	b = append(b, 0xcb)
+	b = appendUint64(b, math.Float64bits(m.Value))
+	b = appendString(b, "time")
+	b = append(b, 0xd3)
+	b = appendUint64(b, uint64(m.Time))
+	if len(m.Tags) > 0 {
+		b = appendString(b, "tags")
+		b = appendArrayLen(b, len(m.Tags))
+		for _, t := range m.Tags {
+			b = appendMapLen(b, 2)
+			b = appendString(b, "key")
+			b = appendString(b, t.Key)
+			b = appendString(b, "value")
+			b = appendString(b, t.Value)
+		}
+	}
+	return b
+}
+
+func appendString(b []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n < 1<<8:
+		b = append(b, 0xd9, byte(n))
+	case n < 1<<16:
+		b = append(b, 0xda)
+		b = appendUint16(b, uint16(n))
+	default:
+		b = append(b, 0xdb)
+		b = appendUint32(b, uint32(n))
+	}
+	return append(b, s...)
+}
+
+func appendArrayLen(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x90|byte(n))
+	case n < 1<<16:
+		return appendUint16(append(b, 0xdc), uint16(n))
+	}
+	return appendUint32(append(b, 0xdd), uint32(n))
+}
+
+func appendMapLen(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x80|byte(n))
+	case n < 1<<16:
+		return appendUint16(append(b, 0xde), uint16(n))
+	}
+	return appendUint32(append(b, 0xdf), uint32(n))
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	var tmp [2]byte
+	binary.BigEndian.PutUint16(tmp[:], v)
+	return append(b, tmp[:]...)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var tmp [4]byte
+	binary.BigEndian.PutUint32(tmp[:], v)
+	return append(b, tmp[:]...)
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var tmp [8]byte
+	binary.BigEndian.PutUint64(tmp[:], v)
+	return append(b, tmp[:]...)
+}
@@ -0,0 +1,122 @@
+// Package statpoolkafka publishes a statpool.Pool's aggregated stats
+// to a Kafka topic, so they can enter existing streaming pipelines.
+// It is kept apart from statpool so only programs using Kafka depend
+// on it.
+//
+//	w := &kafka.Writer{Addr: kafka.TCP("kafka:9092"), Topic: "stats"}
+//	pool := statpool.NewPoolWithOptions(
+//		statpool.WithFlushInterval(time.Minute),
+//		statpool.WithSender(statpoolkafka.New(w)),
+//	)
+//
+// Each stat is encoded as an object of key, type ("count" or
+// "value"), value, time (unix seconds) and tags when it has them.
+package statpoolkafka
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jasonmoo/statpool"
+	kafka "github.com/segmentio/kafka-go"
+)
+
+type Encoding int
+
+const (
+	JSON Encoding = iota
+	MsgPack
+)
+
+// MessageWriter is the method of *kafka.Writer used
+type MessageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+type Sender struct {
+	w MessageWriter
+
+	// Encoding is how stats are encoded, JSON by default
+	Encoding Encoding
+
+	// PerStat publishes a message for each stat, keyed by the stat
+	// key, rather than a message holding the flush's stats as an
+	// array
+	PerStat bool
+}
+
+type message struct {
+	Key   string         `json:"key"`
+	Type  string         `json:"type"`
+	Value float64        `json:"value"`
+	Time  int64          `json:"time"`
+	Tags  []statpool.Tag `json:"tags,omitempty"`
+}
+
+var _ statpool.Sender = (*Sender)(nil)
+
+func New(w MessageWriter) *Sender {
+	return &Sender{w: w}
+}
+
+func (s *Sender) Send(ctx context.Context, stats []interface{}) error {
+
+	now := time.Now()
+	msgs := make([]message, 0, len(stats))
+	for _, stat := range stats {
+		switch st := stat.(type) {
+		case *statpool.CountStat:
+			msgs = append(msgs, newMessage(st.Key, "count", st.Count, st.Timestamp, st.Tags, now))
+		case *statpool.ValueStat:
+			msgs = append(msgs, newMessage(st.Key, "value", st.Value, st.Timestamp, st.Tags, now))
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	if !s.PerStat {
+		value, err := s.encode(msgs)
+		if err != nil {
+			return err
+		}
+		return s.w.WriteMessages(ctx, kafka.Message{Value: value, Time: now})
+	}
+
+	out := make([]kafka.Message, len(msgs))
+	for i, m := range msgs {
+		value, err := s.encode(m)
+		if err != nil {
+			return err
+		}
+		out[i] = kafka.Message{Key: []byte(m.Key), Value: value, Time: now}
+	}
+	return s.w.WriteMessages(ctx, out...)
+
+}
+
+func newMessage(key, typ string, val float64, ts int64, tags []statpool.Tag, now time.Time) message {
+	if ts == 0 {
+		ts = now.Unix()
+	}
+	return message{Key: key, Type: typ, Value: val, Time: ts, Tags: tags}
+}
+
+// encode encodes a message or a slice of them
+func (s *Sender) encode(v interface{}) ([]byte, error) {
+	if s.Encoding != MsgPack {
+		return json.Marshal(v)
+	}
+	switch v := v.(type) {
+	case message:
+		return appendMessage(nil, v), nil
+	case []message:
+		b := appendArrayLen(nil, len(v))
+		for _, m := range v {
+			b = appendMessage(b, m)
+		}
+		return b, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,65 @@
+package statpoolkafka
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/jasonmoo/statpool"
+	kafka "github.com/segmentio/kafka-go"
+)
+
+type fakeWriter struct {
+	msgs []kafka.Message
+}
+
+func (w *fakeWriter) WriteMessages(_ context.Context, msgs ...kafka.Message) error {
+	w.msgs = append(w.msgs, msgs...)
+	return nil
+}
+
+func TestSender(t *testing.T) {
+
+	stats := []interface{}{
+		&statpool.CountStat{Key: "throws", Count: 3, Timestamp: 1500000000},
+		&statpool.ValueStat{Key: "score", Value: 180, Timestamp: 1500000000, Tags: []statpool.Tag{statpool.T("hand", "left")}},
+	}
+
+	w := &fakeWriter{}
+	if err := New(w).Send(context.Background(), stats); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.msgs) != 1 {
+		t.Fatalf("Expected: 1 message, got: %d", len(w.msgs))
+	}
+	var msgs []message
+	if err := json.Unmarshal(w.msgs[0].Value, &msgs); err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || msgs[0].Type != "count" || msgs[1].Value != 180 || msgs[1].Tags[0].Value != "left" {
+		t.Errorf("Unexpected messages: %+v", msgs)
+	}
+
+	w = &fakeWriter{}
+	s := New(w)
+	s.PerStat = true
+	s.Encoding = MsgPack
+	if err := s.Send(context.Background(), stats); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.msgs) != 2 || string(w.msgs[0].Key) != "throws" {
+		t.Fatalf("Expected a message keyed by each stat, got: %+v", w.msgs)
+	}
+	// {"key": "throws", "type": "count", "value": 3.0, "time": 1500000000}
+	expected := []byte{0x84,
+		0xa3, 'k', 'e', 'y', 0xa6, 't', 'h', 'r', 'o', 'w', 's',
+		0xa4, 't', 'y', 'p', 'e', 0xa5, 'c', 'o', 'u', 'n', 't',
+		0xa5, 'v', 'a', 'l', 'u', 'e', 0xcb, 0x40, 0x08, 0, 0, 0, 0, 0, 0,
+		0xa4, 't', 'i', 'm', 'e', 0xd3, 0, 0, 0, 0, 0x59, 0x68, 0x2f, 0x00,
+	}
+	if !bytes.Equal(w.msgs[0].Value, expected) {
+		t.Errorf("Unexpected msgpack:\n% x\nexpected:\n% x", w.msgs[0].Value, expected)
+	}
+
+}
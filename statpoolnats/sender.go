@@ -0,0 +1,109 @@
+// Package statpoolnats publishes a statpool.Pool's aggregated stats to
+// NATS, each on a subject made from its key, e.g. stats.api.requests,
+// for lightweight fan-out of metrics.  It is kept apart from statpool
+// so only programs using NATS depend on it.
+//
+//	nc, _ := nats.Connect(nats.DefaultURL)
+//	pool := statpool.NewPoolWithOptions(
+//		statpool.WithFlushInterval(time.Minute),
+//		statpool.WithSender(statpoolnats.New(nc, "stats")),
+//	)
+//
+// Each stat is published as a JSON object of key, type ("count" or
+// "value"), value, time (unix seconds) and tags when it has them.
+package statpoolnats
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/jasonmoo/statpool"
+	"github.com/nats-io/nats.go"
+)
+
+// Publisher is the method of *nats.Conn used
+type Publisher interface {
+	Publish(subject string, data []byte) error
+}
+
+type Sender struct {
+	publish func(ctx context.Context, subject string, data []byte) error
+	prefix  string
+}
+
+type message struct {
+	Key   string         `json:"key"`
+	Type  string         `json:"type"`
+	Value float64        `json:"value"`
+	Time  int64          `json:"time"`
+	Tags  []statpool.Tag `json:"tags,omitempty"`
+}
+
+var _ statpool.Sender = (*Sender)(nil)
+
+// New publishes on conn to subjects under prefix
+func New(conn Publisher, prefix string) *Sender {
+	return &Sender{
+		publish: func(_ context.Context, subject string, data []byte) error {
+			return conn.Publish(subject, data)
+		},
+		prefix: prefix,
+	}
+}
+
+// NewJetStream publishes with js, so each stat is acknowledged by
+// the stream holding its subject
+func NewJetStream(js nats.JetStreamContext, prefix string) *Sender {
+	return &Sender{
+		publish: func(ctx context.Context, subject string, data []byte) error {
+			_, err := js.Publish(subject, data, nats.Context(ctx))
+			return err
+		},
+		prefix: prefix,
+	}
+}
+
+// Send publishes the stats in turn, stopping at the first failure
+func (s *Sender) Send(ctx context.Context, stats []interface{}) error {
+	now := time.Now().Unix()
+	for _, stat := range stats {
+		var m message
+		switch st := stat.(type) {
+		case *statpool.CountStat:
+			m = message{Key: st.Key, Type: "count", Value: st.Count, Time: st.Timestamp, Tags: st.Tags}
+		case *statpool.ValueStat:
+			m = message{Key: st.Key, Type: "value", Value: st.Value, Time: st.Timestamp, Tags: st.Tags}
+		default:
+			continue
+		}
+		if m.Time == 0 {
+			m.Time = now
+		}
+		data, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		if err := s.publish(ctx, s.subject(m.Key), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// subject prefixes key, replacing the whitespace and wildcards not
+// allowed in subjects
+func (s *Sender) subject(key string) string {
+	key = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\n', '\r', '*', '>':
+			return '_'
+		}
+		return r
+	}, key)
+	if s.prefix == "" {
+		return key
+	}
+	return strings.TrimSuffix(s.prefix, ".") + "." + key
+}
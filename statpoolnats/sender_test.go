@@ -0,0 +1,63 @@
+package statpoolnats
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/jasonmoo/statpool"
+	"github.com/nats-io/nats.go"
+)
+
+type (
+	fakeConn struct {
+		subjects []string
+		data     [][]byte
+	}
+	fakeJetStream struct {
+		nats.JetStreamContext
+		fakeConn
+	}
+)
+
+func (c *fakeConn) Publish(subject string, data []byte) error {
+	c.subjects = append(c.subjects, subject)
+	c.data = append(c.data, data)
+	return nil
+}
+
+func (js *fakeJetStream) Publish(subject string, data []byte, _ ...nats.PubOpt) (*nats.PubAck, error) {
+	return &nats.PubAck{}, js.fakeConn.Publish(subject, data)
+}
+
+func TestSender(t *testing.T) {
+
+	stats := []interface{}{
+		&statpool.CountStat{Key: "api.requests", Count: 3},
+		&statpool.ValueStat{Key: "api.latency *", Value: 12.5},
+	}
+
+	conn := &fakeConn{}
+	if err := New(conn, "stats.").Send(context.Background(), stats); err != nil {
+		t.Fatal(err)
+	}
+	if len(conn.subjects) != 2 || conn.subjects[0] != "stats.api.requests" || conn.subjects[1] != "stats.api.latency__" {
+		t.Errorf("Unexpected subjects: %v", conn.subjects)
+	}
+	var m message
+	if err := json.Unmarshal(conn.data[0], &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Key != "api.requests" || m.Type != "count" || m.Value != 3 || m.Time == 0 {
+		t.Errorf("Unexpected message: %+v", m)
+	}
+
+	js := &fakeJetStream{}
+	if err := NewJetStream(js, "").Send(context.Background(), stats[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if len(js.subjects) != 1 || js.subjects[0] != "api.requests" {
+		t.Errorf("Unexpected subjects: %v", js.subjects)
+	}
+
+}
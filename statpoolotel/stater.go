@@ -0,0 +1,124 @@
+// Package statpoolotel implements statpool.StaterV2 on an
+// OpenTelemetry Meter, so code written against statpool can report
+// through an OpenTelemetry SDK while it's migrated.  It is kept apart
+// from statpool so only programs using OpenTelemetry depend on it.
+//
+//	stats := statpoolotel.New(otel.Meter("myapp"))
+//	stats.Count("api.requests", 1)
+//
+// Counts are recorded with Float64Counters, values and durations with
+// Float64Histograms (durations in milliseconds) and gauges with
+// Float64Gauges, one instrument per key.
+package statpoolotel
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jasonmoo/statpool"
+	"go.opentelemetry.io/otel/metric"
+)
+
+type Stater struct {
+	meter metric.Meter
+
+	// OnError is called when an instrument can't be created or a
+	// stat can't be recorded.  By default errors are ignored.
+	OnError func(error)
+
+	counters   sync.Map // key -> metric.Float64Counter
+	histograms sync.Map // key -> metric.Float64Histogram
+	gauges     sync.Map // key -> metric.Float64Gauge
+}
+
+var _ statpool.StaterV2 = (*Stater)(nil)
+
+func New(meter metric.Meter) *Stater {
+	return &Stater{meter: meter}
+}
+
+// Count adds val to the key's counter.  OpenTelemetry counters only
+// increase, so negative counts are reported to OnError and dropped.
+func (s *Stater) Count(key string, val float64) {
+	if val < 0 {
+		s.error(fmt.Errorf("statpoolotel: negative count %g for %s", val, key))
+		return
+	}
+	if c, ok := s.counters.Load(key); ok {
+		c.(metric.Float64Counter).Add(context.Background(), val)
+		return
+	}
+	c, err := s.meter.Float64Counter(key)
+	if err != nil {
+		s.error(err)
+		return
+	}
+	actual, _ := s.counters.LoadOrStore(key, c)
+	actual.(metric.Float64Counter).Add(context.Background(), val)
+}
+
+// Value records val in the key's histogram.  The timestamp is
+// ignored, OpenTelemetry records at the time of the call.
+func (s *Stater) Value(key string, val float64, _ time.Time) {
+	s.record(key, val, "")
+}
+
+func (s *Stater) Duration(key string, val time.Duration) {
+	s.record(key, float64(val)/float64(time.Millisecond), "ms")
+}
+
+func (s *Stater) DurationSince(key string, start time.Time) {
+	s.Duration(key, time.Since(start))
+}
+
+// SampledDuration records every duration, sampling is left to the
+// OpenTelemetry SDK's configuration
+func (s *Stater) SampledDuration(key string, val time.Duration, _ float64) {
+	s.Duration(key, val)
+}
+
+func (s *Stater) Gauge(key string, val float64) {
+	if g, ok := s.gauges.Load(key); ok {
+		g.(metric.Float64Gauge).Record(context.Background(), val)
+		return
+	}
+	g, err := s.meter.Float64Gauge(key)
+	if err != nil {
+		s.error(err)
+		return
+	}
+	actual, _ := s.gauges.LoadOrStore(key, g)
+	actual.(metric.Float64Gauge).Record(context.Background(), val)
+}
+
+func (s *Stater) Timer(key string) *statpool.Timer {
+	return statpool.NewTimer(s, key)
+}
+
+// record records val in the key's histogram, created with unit the
+// first time the key is seen
+func (s *Stater) record(key string, val float64, unit string) {
+	if h, ok := s.histograms.Load(key); ok {
+		h.(metric.Float64Histogram).Record(context.Background(), val)
+		return
+	}
+	var opts []metric.Float64HistogramOption
+	if unit != "" {
+		opts = append(opts, metric.WithUnit(unit))
+	}
+	h, err := s.meter.Float64Histogram(key, opts...)
+	if err != nil {
+		s.error(err)
+		return
+	}
+	actual, _ := s.histograms.LoadOrStore(key, h)
+	actual.(metric.Float64Histogram).Record(context.Background(), val)
+}
+
+func (s *Stater) error(err error) {
+	if s.OnError != nil {
+		s.OnError(err)
+	}
+}
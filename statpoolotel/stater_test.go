@@ -0,0 +1,99 @@
+package statpoolotel
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/metric"
+)
+
+type (
+	// fakeMeter records what is sent to its instruments, embedding
+	// the interfaces for the methods it doesn't use
+	fakeMeter struct {
+		metric.Meter
+		created  []string
+		recorded map[string]float64
+		mu       sync.Mutex
+	}
+	fakeCounter struct {
+		metric.Float64Counter
+		m   *fakeMeter
+		key string
+	}
+	fakeHistogram struct {
+		metric.Float64Histogram
+		m   *fakeMeter
+		key string
+	}
+	fakeGauge struct {
+		metric.Float64Gauge
+		m   *fakeMeter
+		key string
+	}
+)
+
+func (m *fakeMeter) Float64Counter(name string, _ ...metric.Float64CounterOption) (metric.Float64Counter, error) {
+	m.created = append(m.created, name)
+	return fakeCounter{m: m, key: name}, nil
+}
+
+func (m *fakeMeter) Float64Histogram(name string, _ ...metric.Float64HistogramOption) (metric.Float64Histogram, error) {
+	m.created = append(m.created, name)
+	return fakeHistogram{m: m, key: name}, nil
+}
+
+func (m *fakeMeter) Float64Gauge(name string, _ ...metric.Float64GaugeOption) (metric.Float64Gauge, error) {
+	m.created = append(m.created, name)
+	return fakeGauge{m: m, key: name}, nil
+}
+
+func (m *fakeMeter) add(key string, val float64) {
+	m.mu.Lock()
+	m.recorded[key] += val
+	m.mu.Unlock()
+}
+
+func (c fakeCounter) Add(_ context.Context, val float64, _ ...metric.AddOption) {
+	c.m.add(c.key, val)
+}
+
+func (h fakeHistogram) Record(_ context.Context, val float64, _ ...metric.RecordOption) {
+	h.m.add(h.key, val)
+}
+
+func (g fakeGauge) Record(_ context.Context, val float64, _ ...metric.RecordOption) {
+	g.m.add(g.key, val)
+}
+
+func TestStater(t *testing.T) {
+
+	m := &fakeMeter{recorded: map[string]float64{}}
+	s := New(m)
+
+	var errs int
+	s.OnError = func(error) { errs++ }
+
+	s.Count("darts", 1)
+	s.Count("darts", 2)
+	s.Count("darts", -1)
+	s.Value("score", 180, time.Now())
+	s.Duration("throw", 1500*time.Millisecond)
+	s.Gauge("players", 2)
+
+	if len(m.created) != 4 {
+		t.Errorf("Expected: 4 instruments, got: %v", m.created)
+	}
+	expected := map[string]float64{"darts": 3, "score": 180, "throw": 1500, "players": 2}
+	for key, val := range expected {
+		if m.recorded[key] != val {
+			t.Errorf("Expected %s: %g, got: %g", key, val, m.recorded[key])
+		}
+	}
+	if errs != 1 {
+		t.Errorf("Expected: 1 error for the negative count, got: %d", errs)
+	}
+
+}
@@ -0,0 +1,216 @@
+// Package statpooltest has helpers for testing code that reports
+// stats with statpool: a fake StatHat endpoint to point a Pool at,
+// matchers to find what it received, and a Stater double with
+// assertions.
+//
+//	ep := statpooltest.NewEndpoint()
+//	defer ep.Close()
+//	pool := statpool.NewPool(ep.URL, "key", time.Hour)
+//	handleRequest(pool)
+//	pool.Flush()
+//	ep.Expect(t, statpooltest.Count("requests", 1))
+package statpooltest
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jasonmoo/statpool"
+)
+
+// DefaultWait is how long Expect waits for a matching stat
+const DefaultWait = time.Second
+
+type (
+	// Endpoint is an http server accepting payloads as StatHat does
+	// and keeping them to inspect
+	Endpoint struct {
+		*httptest.Server
+
+		payloads []Payload
+		status   int
+		response statResponse
+		received chan struct{}
+		mu       sync.Mutex
+	}
+
+	// Payload is a request received by an Endpoint
+	Payload struct {
+		EZKey          string
+		IdempotencyKey string
+		Stats          []Stat
+	}
+
+	// Stat is a stat received by an Endpoint
+	Stat struct {
+		EZKey     string
+		Key       string
+		Type      statpool.StatType
+		Value     float64
+		Timestamp int64
+	}
+
+	statResponse struct {
+		Status  int    `json:"status"`
+		Message string `json:"msg"`
+	}
+)
+
+func NewEndpoint() *Endpoint {
+	e := &Endpoint{received: make(chan struct{})}
+	e.Reset()
+	e.Server = httptest.NewServer(e)
+	return e
+}
+
+// SetStatus responds to requests with the http status code, e.g.
+// http.StatusServiceUnavailable to test retries
+func (e *Endpoint) SetStatus(code int) {
+	e.mu.Lock()
+	e.status = code
+	e.mu.Unlock()
+}
+
+// SetAPIError accepts requests over http but rejects their payloads
+// with status and msg in the response
+func (e *Endpoint) SetAPIError(status int, msg string) {
+	e.mu.Lock()
+	e.response = statResponse{Status: status, Message: msg}
+	e.mu.Unlock()
+}
+
+// Reset forgets the payloads received and responds with success
+func (e *Endpoint) Reset() {
+	e.mu.Lock()
+	e.payloads = nil
+	e.status = http.StatusOK
+	e.response = statResponse{Status: http.StatusOK}
+	e.mu.Unlock()
+}
+
+func (e *Endpoint) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+
+	var body io.Reader = req.Body
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body = zr
+	}
+	ezKey, stats, err := statpool.DecodePayload(req.Header.Get("Content-Type"), body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if ezKey == "" {
+		ezKey = req.URL.Query().Get("ezkey")
+	}
+
+	e.mu.Lock()
+	status, response := e.status, e.response
+	if status == http.StatusOK && response.Status == http.StatusOK {
+		payload := Payload{EZKey: ezKey, IdempotencyKey: req.Header.Get(statpool.IdempotencyHeader)}
+		for _, stat := range stats {
+			switch s := stat.(type) {
+			case *statpool.CountStat:
+				payload.Stats = append(payload.Stats, Stat{ezKey, s.Key, statpool.CountType, s.Count, s.Timestamp})
+			case *statpool.ValueStat:
+				payload.Stats = append(payload.Stats, Stat{ezKey, s.Key, statpool.ValueType, s.Value, s.Timestamp})
+			}
+		}
+		e.payloads = append(e.payloads, payload)
+		close(e.received)
+		e.received = make(chan struct{})
+	}
+	e.mu.Unlock()
+
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+		return
+	}
+	json.NewEncoder(w).Encode(response)
+
+}
+
+// Payloads returns the payloads accepted in the order received
+func (e *Endpoint) Payloads() []Payload {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]Payload(nil), e.payloads...)
+}
+
+// Stats returns every stat accepted in the order received
+func (e *Endpoint) Stats() []Stat {
+	return e.Find(Func("any stat", func(Stat) bool { return true }))
+}
+
+// Find returns the stats accepted that match m
+func (e *Endpoint) Find(m Matcher) []Stat {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var found []Stat
+	for _, p := range e.payloads {
+		for _, s := range p.Stats {
+			if m.Match(s) {
+				found = append(found, s)
+			}
+		}
+	}
+	return found
+}
+
+// Total sums the counts accepted for key over every payload
+func (e *Endpoint) Total(key string) float64 {
+	var total float64
+	for _, s := range e.Find(Key(key)) {
+		if s.Type == statpool.CountType {
+			total += s.Value
+		}
+	}
+	return total
+}
+
+// Wait waits up to timeout for a stat matching m, reporting whether
+// one was received
+func (e *Endpoint) Wait(m Matcher, timeout time.Duration) bool {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		e.mu.Lock()
+		received := e.received
+		e.mu.Unlock()
+		if len(e.Find(m)) > 0 {
+			return true
+		}
+		select {
+		case <-received:
+		case <-deadline.C:
+			return false
+		}
+	}
+}
+
+// Expect fails t unless a stat matching m is received within
+// DefaultWait
+func (e *Endpoint) Expect(t testing.TB, m Matcher) {
+	t.Helper()
+	if !e.Wait(m, DefaultWait) {
+		t.Errorf("statpooltest: no stat received matching %s, got: %v", m, e.Stats())
+	}
+}
+
+// ExpectNone fails t if a stat matching m has been received
+func (e *Endpoint) ExpectNone(t testing.TB, m Matcher) {
+	t.Helper()
+	if found := e.Find(m); len(found) > 0 {
+		t.Errorf("statpooltest: expected no stat matching %s, got: %v", m, found)
+	}
+}
@@ -0,0 +1,102 @@
+package statpooltest
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jasonmoo/statpool"
+)
+
+func TestEndpoint(t *testing.T) {
+
+	ep := NewEndpoint()
+	defer ep.Close()
+
+	pool := statpool.NewPool(ep.URL, "finchbasket", time.Hour)
+	pool.OnError(func(error, int) {})
+	defer pool.Stop()
+
+	pool.Count("darts", 1)
+	pool.Count("darts", 2)
+	pool.Value("score", 180, time.Now())
+	pool.ForEZKey("tenant").Count("darts", 1)
+	pool.Flush()
+
+	ep.Expect(t, Count("darts", 3))
+	ep.Expect(t, All(Value("score", 180), EZKey("finchbasket")))
+	ep.Expect(t, All(Count("darts", 1), EZKey("tenant")))
+	ep.ExpectNone(t, Key("misses"))
+	if total := ep.Total("darts"); total != 4 {
+		t.Errorf("Expected: 4 darts, got: %g", total)
+	}
+	if n := len(ep.Payloads()); n != 2 {
+		t.Errorf("Expected: 2 payloads, got: %d", n)
+	}
+
+	// rejected payloads aren't kept
+	ep.Reset()
+	ep.SetAPIError(500, "no ezkey")
+	pool.Count("misses", 1)
+	if err := pool.Flush(); err == nil {
+		t.Error("Expected the rejected flush to fail")
+	}
+	ep.SetStatus(http.StatusBadRequest)
+	pool.Count("misses", 1)
+	pool.Flush()
+	if stats := ep.Stats(); len(stats) != 0 {
+		t.Errorf("Expected no stats, got: %v", stats)
+	}
+
+	// the flush runs in the background, Wait waits for it
+	ep.Reset()
+	pool.Count("darts", 5)
+	go pool.Flush()
+	if !ep.Wait(Count("darts", 5), time.Second) {
+		t.Error("Expected darts: 5")
+	}
+
+}
+
+type recordingTB struct {
+	testing.TB
+	errs []string
+}
+
+func (r *recordingTB) Helper() {}
+
+func (r *recordingTB) Errorf(format string, args ...interface{}) {
+	r.errs = append(r.errs, fmt.Sprintf(format, args...))
+}
+
+func TestStater(t *testing.T) {
+
+	var (
+		tb    = &recordingTB{TB: t}
+		stats = NewStater(tb)
+	)
+	stats.Count("darts", 2)
+	stats.Gauge("players", 2)
+	stats.Gauge("players", 3)
+	stats.Time("game")()
+
+	stats.ExpectCount("darts", 2)
+	stats.ExpectValues("players", 2, 3)
+	stats.ExpectDuration("game")
+	stats.ExpectNone("misses")
+	stats.ExpectKeys("darts", "game", "players")
+	if len(tb.errs) > 0 {
+		t.Errorf("Unexpected failures: %v", tb.errs)
+	}
+
+	stats.ExpectCount("darts", 1)
+	stats.ExpectValues("players", 3)
+	stats.ExpectDuration("darts")
+	stats.ExpectNone("darts")
+	stats.ExpectKeys("darts")
+	if len(tb.errs) != 5 {
+		t.Errorf("Expected: 5 failures, got: %v", tb.errs)
+	}
+
+}
@@ -0,0 +1,75 @@
+package statpooltest
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/jasonmoo/statpool"
+)
+
+// Matcher selects received stats, described for failure messages
+type Matcher struct {
+	desc  string
+	match func(Stat) bool
+}
+
+// Func matches the stats fn returns true for
+func Func(desc string, fn func(Stat) bool) Matcher {
+	return Matcher{desc, fn}
+}
+
+func (m Matcher) Match(s Stat) bool {
+	return m.match(s)
+}
+
+func (m Matcher) String() string {
+	return m.desc
+}
+
+// Key matches stats of any type and value with the key
+func Key(key string) Matcher {
+	return Func(strconv.Quote(key), func(s Stat) bool {
+		return s.Key == key
+	})
+}
+
+// Count matches a count of val for key
+func Count(key string, val float64) Matcher {
+	return Func(key+":"+formatFloat(val)+" count", func(s Stat) bool {
+		return s.Key == key && s.Type == statpool.CountType && s.Value == val
+	})
+}
+
+// Value matches a value of val for key
+func Value(key string, val float64) Matcher {
+	return Func(key+":"+formatFloat(val)+" value", func(s Stat) bool {
+		return s.Key == key && s.Type == statpool.ValueType && s.Value == val
+	})
+}
+
+// EZKey matches stats sent under ezKey
+func EZKey(ezKey string) Matcher {
+	return Func("ezkey "+ezKey, func(s Stat) bool {
+		return s.EZKey == ezKey
+	})
+}
+
+// All matches stats matching every one of ms
+func All(ms ...Matcher) Matcher {
+	descs := make([]string, len(ms))
+	for i, m := range ms {
+		descs[i] = m.desc
+	}
+	return Func(strings.Join(descs, " and "), func(s Stat) bool {
+		for _, m := range ms {
+			if !m.match(s) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
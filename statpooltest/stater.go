@@ -0,0 +1,77 @@
+package statpooltest
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jasonmoo/statpool"
+)
+
+// Stater records stats like a statpool.RecorderPool, with assertions
+// that fail the test it was made for
+//
+//	stats := statpooltest.NewStater(t)
+//	handleRequest(stats)
+//	stats.ExpectCount("requests", 1)
+type Stater struct {
+	*statpool.RecorderPool
+	t testing.TB
+}
+
+func NewStater(t testing.TB) *Stater {
+	return &Stater{RecorderPool: statpool.NewRecorderPool(), t: t}
+}
+
+// ExpectCount fails the test unless the total counted for key is want
+func (s *Stater) ExpectCount(key string, want float64) {
+	s.t.Helper()
+	if got := s.CountFor(key); got != want {
+		s.t.Errorf("statpooltest: expected %s count: %g, got: %g", key, want, got)
+	}
+}
+
+// ExpectValues fails the test unless the values recorded for key,
+// gauges and histograms included, are want in order
+func (s *Stater) ExpectValues(key string, want ...float64) {
+	s.t.Helper()
+	if got := s.ValuesFor(key); !reflect.DeepEqual(got, want) && (len(got) > 0 || len(want) > 0) {
+		s.t.Errorf("statpooltest: expected %s values: %v, got: %v", key, want, got)
+	}
+}
+
+// ExpectDuration fails the test unless a duration was recorded for key
+func (s *Stater) ExpectDuration(key string) {
+	s.t.Helper()
+	if len(s.DurationsFor(key)) == 0 {
+		s.t.Errorf("statpooltest: expected a %s duration, got none", key)
+	}
+}
+
+// ExpectNone fails the test if anything was recorded for key
+func (s *Stater) ExpectNone(key string) {
+	s.t.Helper()
+	for _, k := range s.Keys() {
+		if k == key {
+			s.t.Errorf("statpooltest: expected nothing recorded for %s", key)
+			return
+		}
+	}
+}
+
+// ExpectKeys fails the test unless exactly keys were recorded, in any
+// order
+func (s *Stater) ExpectKeys(keys ...string) {
+	s.t.Helper()
+	want := map[string]bool{}
+	for _, k := range keys {
+		want[k] = true
+	}
+	got := s.Keys()
+	match := len(got) == len(want)
+	for _, k := range got {
+		match = match && want[k]
+	}
+	if !match {
+		s.t.Errorf("statpooltest: expected keys: %v, got: %v", keys, got)
+	}
+}
@@ -0,0 +1,23 @@
+// Package statpoolyaml lets statpool read YAML config files.  It is
+// kept apart from statpool so only programs using YAML depend on a
+// YAML parser.  Import it for its side effect:
+//
+//	import _ "github.com/jasonmoo/statpool/statpoolyaml"
+//
+//	stop, err := pool.WatchConfig("/etc/app/stats.yaml", 10*time.Second)
+package statpoolyaml
+
+import (
+	"github.com/jasonmoo/statpool"
+	"gopkg.in/yaml.v3"
+)
+
+func init() {
+	statpool.RegisterConfigDecoder(".yaml", Decode)
+	statpool.RegisterConfigDecoder(".yml", Decode)
+}
+
+// Decode decodes a YAML config
+func Decode(data []byte, c *statpool.Config) error {
+	return yaml.Unmarshal(data, c)
+}
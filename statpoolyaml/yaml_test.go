@@ -0,0 +1,32 @@
+package statpoolyaml
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jasonmoo/statpool"
+)
+
+func TestReadConfig(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "statpoolyaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "stats.yml")
+	if err := ioutil.WriteFile(path, []byte(`{"prefix": "api.", "flush_interval": "30s", "deny": ["debug.*"]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := statpool.ReadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Prefix != "api." || c.FlushInterval != "30s" || len(c.Deny) != 1 {
+		t.Errorf("Unexpected config: %+v", c)
+	}
+
+}
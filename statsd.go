@@ -0,0 +1,253 @@
+package statpool
+
+import (
+	"math"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultStatsdPacketSize keeps packets within a typical ethernet MTU
+const DefaultStatsdPacketSize = 1432
+
+// StatsdPool sends stats to a statsd server over udp.  Lines are
+// batched into packets up to the packet size and sent when a packet
+// fills or every flush interval.  Counts are sent as c, gauges as g,
+// durations as ms in milliseconds and other values as h.  Tags are
+// sent in the DogStatsD |#key:value form.
+type StatsdPool struct {
+	conn net.Conn
+	size int32
+	rate uint64
+
+	buf []byte
+	mu  sync.Mutex
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewStatsdPool(addr string, flushInterval time.Duration) (*StatsdPool, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &StatsdPool{
+		conn: conn,
+		size: DefaultStatsdPacketSize,
+		rate: math.Float64bits(1),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go s.run(flushInterval)
+	return s, nil
+}
+
+// SetPacketSize sets the largest packet sent.  Lines longer than
+// this are sent in a packet of their own.
+func (s *StatsdPool) SetPacketSize(n int) {
+	if n > 0 {
+		atomic.StoreInt32(&s.size, int32(n))
+	}
+}
+
+// SetSampleRate sends only the given fraction of counts, values and
+// durations, marked with the rate so the server can scale them up.
+// Gauges are always sent.
+func (s *StatsdPool) SetSampleRate(rate float64) {
+	if rate > 0 && rate <= 1 {
+		atomic.StoreUint64(&s.rate, math.Float64bits(rate))
+	}
+}
+
+func (s *StatsdPool) Count(key string, val float64) {
+	s.sample(key, val, "c", nil)
+}
+
+func (s *StatsdPool) Inc(key string) {
+	s.Count(key, 1)
+}
+
+func (s *StatsdPool) Dec(key string) {
+	s.Count(key, -1)
+}
+
+func (s *StatsdPool) Add(key string, delta float64) {
+	s.Count(key, delta)
+}
+
+func (s *StatsdPool) Value(key string, val float64, _ time.Time) {
+	s.sample(key, val, "h", nil)
+}
+
+func (s *StatsdPool) Duration(key string, val time.Duration) {
+	s.sample(key, float64(val)/float64(time.Millisecond), "ms", nil)
+}
+
+func (s *StatsdPool) Gauge(key string, val float64) {
+	s.gauge(key, val, nil)
+}
+
+func (s *StatsdPool) Histogram(key string, val float64) {
+	s.sample(key, val, "h", nil)
+}
+
+func (s *StatsdPool) CountWithTags(key string, val float64, tags ...Tag) {
+	s.sample(key, val, "c", tags)
+}
+
+func (s *StatsdPool) ValueWithTags(key string, val float64, _ time.Time, tags ...Tag) {
+	s.sample(key, val, "h", tags)
+}
+
+func (s *StatsdPool) GaugeWithTags(key string, val float64, tags ...Tag) {
+	s.gauge(key, val, tags)
+}
+
+func (s *StatsdPool) DurationWithTags(key string, val time.Duration, tags ...Tag) {
+	s.sample(key, float64(val)/float64(time.Millisecond), "ms", tags)
+}
+
+func (s *StatsdPool) DurationSince(key string, start time.Time) {
+	s.Duration(key, time.Since(start))
+}
+
+func (s *StatsdPool) Timer(key string) *Timer {
+	return NewTimer(s, key)
+}
+
+func (s *StatsdPool) Time(key string) func() {
+	t := s.Timer(key)
+	return func() { t.Stop() }
+}
+
+func (s *StatsdPool) TimeCaller(start time.Time) {
+	s.Duration(callerKey(1), time.Since(start))
+}
+
+// SampledCount sends the count with probability rate, tagged with the
+// rate so the server scales it back up
+func (s *StatsdPool) SampledCount(key string, val float64, rate float64) {
+	if rate > 0 && rand.Float64() < rate {
+		s.write(key, val, "c", rate, nil)
+	}
+}
+
+// SampledDuration sends the duration with probability rate
+func (s *StatsdPool) SampledDuration(key string, val time.Duration, rate float64) {
+	if rate > 0 && rand.Float64() < rate {
+		s.write(key, float64(val)/float64(time.Millisecond), "ms", rate, nil)
+	}
+}
+
+// Flush sends any buffered lines
+func (s *StatsdPool) Flush() {
+	s.mu.Lock()
+	s.flush()
+	s.mu.Unlock()
+}
+
+// Close sends any buffered lines and closes the connection
+func (s *StatsdPool) Close() error {
+	close(s.stop)
+	<-s.done
+	s.Flush()
+	return s.conn.Close()
+}
+
+func (s *StatsdPool) run(interval time.Duration) {
+	defer close(s.done)
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			s.Flush()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *StatsdPool) sample(key string, val float64, typ string, tags []Tag) {
+	rate := math.Float64frombits(atomic.LoadUint64(&s.rate))
+	if rate < 1 && rand.Float64() >= rate {
+		return
+	}
+	s.write(key, val, typ, rate, tags)
+}
+
+// gauge sets a gauge.  A leading sign is a relative change to statsd,
+// so negative values are sent after a zero in the same packet.
+func (s *StatsdPool) gauge(key string, val float64, tags []Tag) {
+	if val >= 0 {
+		s.write(key, val, "g", 1, tags)
+		return
+	}
+	lines := append(formatStatsd(key, 0, "g", 1, tags), '\n')
+	s.buffer(append(lines, formatStatsd(key, val, "g", 1, tags)...))
+}
+
+// write buffers a line of the form key:value|type[|@rate][|#tags]
+func (s *StatsdPool) write(key string, val float64, typ string, rate float64, tags []Tag) {
+	s.buffer(formatStatsd(key, val, typ, rate, tags))
+}
+
+func formatStatsd(key string, val float64, typ string, rate float64, tags []Tag) []byte {
+
+	line := make([]byte, 0, len(key)+32)
+	line = append(line, key...)
+	line = append(line, ':')
+	line = strconv.AppendFloat(line, val, 'g', -1, 64)
+	line = append(line, '|')
+	line = append(line, typ...)
+	if rate < 1 {
+		line = append(line, "|@"...)
+		line = strconv.AppendFloat(line, rate, 'g', -1, 64)
+	}
+	for i, tag := range tags {
+		if i == 0 {
+			line = append(line, "|#"...)
+		} else {
+			line = append(line, ',')
+		}
+		line = append(line, tag.Key...)
+		line = append(line, ':')
+		line = append(line, tag.Value...)
+	}
+	return line
+
+}
+
+// buffer adds lines to the buffer, sending it first if they won't
+// fit, so lines buffered together go in one packet
+func (s *StatsdPool) buffer(line []byte) {
+
+	size := int(atomic.LoadInt32(&s.size))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.buf) > 0 && len(s.buf)+1+len(line) > size {
+		s.flush()
+	}
+	if len(s.buf) > 0 {
+		s.buf = append(s.buf, '\n')
+	}
+	s.buf = append(s.buf, line...)
+	if len(s.buf) >= size {
+		s.flush()
+	}
+
+}
+
+func (s *StatsdPool) flush() {
+	if len(s.buf) == 0 {
+		return
+	}
+	s.conn.Write(s.buf)
+	s.buf = s.buf[:0]
+}
@@ -0,0 +1,91 @@
+package statpool
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsdPool(t *testing.T) {
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	stats, err := NewStatsdPool(conn.LocalAddr().String(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stats.Close()
+	stats.SetPacketSize(40)
+
+	stats.Count("darts", 2)
+	stats.Gauge("players", 4)
+	stats.DurationWithTags("throw", 1500*time.Microsecond, T("hand", "left"))
+	stats.Flush()
+
+	// the third line doesn't fit in the first packet
+	expected := []string{
+		"darts:2|c\nplayers:4|g",
+		"throw:1.5|ms|#hand:left",
+	}
+	buf := make([]byte, 1024)
+	for _, packet := range expected {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != packet {
+			t.Errorf("Expected: %q, got: %q", packet, buf[:n])
+		}
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			if _, err := parseStatsd(line); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+
+}
+
+func TestStatsdNegativeGauge(t *testing.T) {
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	stats, err := NewStatsdPool(conn.LocalAddr().String(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stats.Close()
+	stats.SetPacketSize(20)
+
+	// the gauge is zeroed first, in the same packet even past its
+	// size, rather than decremented
+	stats.Gauge("depth", 3)
+	stats.GaugeWithTags("temp", -5, T("room", "a"))
+	stats.Flush()
+
+	expected := []string{
+		"depth:3|g",
+		"temp:0|g|#room:a\ntemp:-5|g|#room:a",
+	}
+	buf := make([]byte, 1024)
+	for _, packet := range expected {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != packet {
+			t.Errorf("Expected: %q, got: %q", packet, buf[:n])
+		}
+	}
+
+}
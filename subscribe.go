@@ -0,0 +1,84 @@
+package statpool
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// Stat is a single stat as accepted by the pool
+	Stat struct {
+		Key   string    `json:"key"`
+		Type  StatType  `json:"type"`
+		Value float64   `json:"value"`
+		Time  time.Time `json:"time"`
+		Tags  []Tag     `json:"tags,omitempty"`
+	}
+
+	StatType int
+
+	subscribers struct {
+		sync.RWMutex
+		n    int32
+		subs map[chan Stat]struct{}
+	}
+)
+
+const (
+	CountType StatType = iota
+	ValueType
+)
+
+// subscriber channel size, stats are dropped for
+// subscribers that fall further behind
+const subscriberBuffer = 256
+
+func (t StatType) String() string {
+	if t == CountType {
+		return "count"
+	}
+	return "value"
+}
+
+// Subscribe mirrors every stat accepted by the pool to the returned
+// channel until the cancel func is called.  Stats are dropped rather
+// than blocking the pool when the subscriber falls behind.
+func (p *Pool) Subscribe() (<-chan Stat, func()) {
+
+	ch := make(chan Stat, subscriberBuffer)
+
+	p.subs.Lock()
+	if p.subs.subs == nil {
+		p.subs.subs = map[chan Stat]struct{}{}
+	}
+	p.subs.subs[ch] = struct{}{}
+	atomic.StoreInt32(&p.subs.n, int32(len(p.subs.subs)))
+	p.subs.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			p.subs.Lock()
+			delete(p.subs.subs, ch)
+			atomic.StoreInt32(&p.subs.n, int32(len(p.subs.subs)))
+			p.subs.Unlock()
+			close(ch)
+		})
+	}
+
+}
+
+func (p *Pool) publish(stat Stat) {
+	if atomic.LoadInt32(&p.subs.n) == 0 {
+		return
+	}
+	p.subs.RLock()
+	for ch := range p.subs.subs {
+		select {
+		case ch <- stat:
+		default:
+		}
+	}
+	p.subs.RUnlock()
+}
@@ -0,0 +1,180 @@
+package statpool
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// local syslog sockets, tried in order
+var syslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// the documentation enterprise number, for the structured data id
+const syslogSDID = "stat@32473"
+
+// SyslogPool writes each stat as an RFC 5424 syslog message, with the
+// stat in structured data, e.g.
+//
+//	<14>1 2006-01-02T15:04:05.000000Z host app 123 stat [stat@32473 key="requests" type="count" value="1"] requests:1
+//
+// Messages are sent over UDP, or over TCP with octet counting framing.
+type SyslogPool struct {
+	network  string
+	addr     string
+	hostname string
+	appName  string
+	pid      string
+
+	conn   net.Conn
+	closed bool
+	mu     sync.Mutex
+}
+
+// NewSyslogPool connects to the syslog server at addr over network,
+// "udp" or "tcp", or to the local syslog daemon when network is empty
+func NewSyslogPool(network, addr, appName string) (*SyslogPool, error) {
+	hostname, _ := os.Hostname()
+	s := &SyslogPool{
+		network:  network,
+		addr:     addr,
+		hostname: syslogField(hostname),
+		appName:  syslogField(appName),
+		pid:      strconv.Itoa(os.Getpid()),
+	}
+	if err := s.dial(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SyslogPool) Count(key string, val float64) {
+	s.send(key, "count", strconv.FormatFloat(val, 'g', -1, 64))
+}
+
+func (s *SyslogPool) Value(key string, val float64, _ time.Time) {
+	s.send(key, "value", strconv.FormatFloat(val, 'g', -1, 64))
+}
+
+func (s *SyslogPool) Duration(key string, val time.Duration) {
+	s.send(key, "duration", strconv.FormatFloat(float64(val)/float64(time.Millisecond), 'g', -1, 64))
+}
+
+func (s *SyslogPool) Gauge(key string, val float64) {
+	s.send(key, "gauge", strconv.FormatFloat(val, 'g', -1, 64))
+}
+
+func (s *SyslogPool) DurationSince(key string, start time.Time) {
+	s.Duration(key, time.Since(start))
+}
+
+func (s *SyslogPool) TimeCaller(start time.Time) {
+	s.Duration(callerKey(1), time.Since(start))
+}
+
+func (s *SyslogPool) SampledCount(key string, val float64, rate float64) {
+	if r, ok := sample(key, rate); ok {
+		s.Count(key, scale(val, r))
+	}
+}
+
+func (s *SyslogPool) SampledDuration(key string, val time.Duration, rate float64) {
+	if _, ok := sample(key, rate); ok {
+		s.Duration(key, val)
+	}
+}
+
+func (s *SyslogPool) Timer(key string) *Timer {
+	return NewTimer(s, key)
+}
+
+func (s *SyslogPool) Time(key string) func() {
+	t := s.Timer(key)
+	return func() { t.Stop() }
+}
+
+func (s *SyslogPool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+func (s *SyslogPool) dial() error {
+	if s.network != "" {
+		conn, err := net.Dial(s.network, s.addr)
+		s.conn = conn
+		return err
+	}
+	for _, path := range syslogSockets {
+		for _, network := range []string{"unixgram", "unix"} {
+			if conn, err := net.Dial(network, path); err == nil {
+				s.conn = conn
+				return nil
+			}
+		}
+	}
+	return errors.New("statpool: no local syslog socket found")
+}
+
+// send writes the message, redialing once if the write fails
+func (s *SyslogPool) send(key, typ, val string) {
+
+	buf := &bytes.Buffer{}
+	buf.WriteString("<14>1 ")
+	buf.WriteString(time.Now().Format("2006-01-02T15:04:05.000000Z07:00"))
+	buf.WriteString(" " + s.hostname + " " + s.appName + " " + s.pid + " stat ")
+	buf.WriteString("[" + syslogSDID + ` key="` + syslogParam(key) + `" type="` + typ + `" value="` + val + `"] `)
+	buf.WriteString(key + ":" + val)
+
+	msg := buf.Bytes()
+	if s.network == "tcp" {
+		msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	if s.conn != nil {
+		if _, err := s.conn.Write(msg); err == nil {
+			return
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	if s.dial() == nil {
+		s.conn.Write(msg)
+	}
+
+}
+
+// syslogField replaces what isn't allowed in a header field
+func syslogField(v string) string {
+	v = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return '_'
+		}
+		return r
+	}, v)
+	if v == "" {
+		return "-"
+	}
+	return v
+}
+
+var syslogEscape = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+func syslogParam(v string) string {
+	return syslogEscape.Replace(v)
+}
@@ -0,0 +1,81 @@
+package statpool
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+var _ StaterV2 = (*SyslogPool)(nil)
+
+func TestSyslogPool(t *testing.T) {
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	stats, err := NewSyslogPool("udp", conn.LocalAddr().String(), "darts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stats.Close()
+	stats.Count(`best "throw"`, 3)
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := string(buf[:n])
+	if !strings.HasPrefix(msg, "<14>1 ") {
+		t.Errorf("Expected an RFC 5424 header, got: %q", msg)
+	}
+	for _, part := range []string{` darts `, ` stat [stat@32473 key="best \"throw\"" type="count" value="3"] `} {
+		if !strings.Contains(msg, part) {
+			t.Errorf("Expected message to contain %q, got: %q", part, msg)
+		}
+	}
+
+}
+
+func TestSyslogPoolTCP(t *testing.T) {
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	lines := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		length, _ := bufio.NewReader(conn).ReadString(' ')
+		lines <- length
+	}()
+
+	stats, err := NewSyslogPool("tcp", ln.Addr().String(), "darts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stats.Close()
+	stats.Gauge("players", 2)
+
+	select {
+	case length := <-lines:
+		if strings.TrimSpace(length) == "" || strings.Trim(length, "0123456789 ") != "" {
+			t.Errorf("Expected an octet count, got: %q", length)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the message")
+	}
+
+}
@@ -0,0 +1,105 @@
+package statpool
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Tag is a key/value dimension attached to a stat
+type Tag struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// default separator between a key and its flattened tags
+const DefaultTagSeparator = "."
+
+// T is shorthand for Tag{Key: key, Value: value}
+func T(key, value string) Tag {
+	return Tag{Key: key, Value: value}
+}
+
+// flattenTags appends tags to key sorted by tag key, e.g.
+// requests.host=web1.region=us
+func flattenTags(key string, tags []Tag, sep string) string {
+	if len(tags) == 0 {
+		return key
+	}
+	sorted := append([]Tag(nil), tags...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Key < sorted[j].Key })
+
+	var b strings.Builder
+	b.WriteString(key)
+	for _, t := range sorted {
+		b.WriteString(sep)
+		b.WriteString(t.Key)
+		b.WriteString("=")
+		b.WriteString(t.Value)
+	}
+	return b.String()
+}
+
+// formatTags renders tags for logging as {k=v,...}
+func formatTags(tags []Tag) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(tags))
+	for i, t := range tags {
+		pairs[i] = t.Key + "=" + t.Value
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// SetTagSeparator sets the separator used when flattening tags
+// into keys for StatHat.  Counts are aggregated per key and tag set.
+func (p *Pool) SetTagSeparator(sep string) {
+	p.tagsep = sep
+}
+
+func (p *Pool) tagged(key string, tags []Tag) string {
+	return flattenTags(p.prefix+key, tags, p.tagsep)
+}
+
+func (p *Pool) CountWithTags(key string, val float64, tags ...Tag) {
+	if !p.Enabled() {
+		return
+	}
+	if p.devlogger != nil {
+		p.devlogger.Printf("%s:%g", p.tagged(key, tags), val)
+	}
+	stat := newCountStat(p.tagged(key, tags), val)
+	stat.Tags = tags
+	p.SendCount(stat)
+}
+
+func (p *Pool) ValueWithTags(key string, val float64, timestamp time.Time, tags ...Tag) {
+	if !p.Enabled() {
+		return
+	}
+	if p.devlogger != nil {
+		p.devlogger.Printf("%s:%g", p.tagged(key, tags), val)
+	}
+	p.SendValue(newValueStat(ValueStat{Key: p.tagged(key, tags), Value: val, Timestamp: timestamp.Unix(), Tags: tags}))
+}
+
+func (p *Pool) DurationWithTags(key string, val time.Duration, tags ...Tag) {
+	if !p.Enabled() {
+		return
+	}
+	if p.devlogger != nil {
+		p.devlogger.Printf("%s:%s", p.tagged(key, tags), val)
+	}
+	p.SendValue(newValueStat(ValueStat{Key: p.tagged(key, tags), Value: float64(val) / float64(time.Millisecond), Tags: tags}))
+}
+
+func (p *Pool) GaugeWithTags(key string, val float64, tags ...Tag) {
+	if !p.Enabled() {
+		return
+	}
+	if p.devlogger != nil {
+		p.devlogger.Printf("%s:%g", p.tagged(key, tags), val)
+	}
+	p.SendValue(newValueStat(ValueStat{Key: p.tagged(key, tags), Value: val, Timestamp: p.now().Unix(), Tags: tags, gauge: true}))
+}
@@ -0,0 +1,93 @@
+package statpool
+
+import (
+	"log"
+	"time"
+)
+
+// TeePool mirrors every stat sent to a primary Stater to a secondary,
+// e.g. a new backend being evaluated.  The primary is called directly
+// as if it were used alone; the secondary is fed from its own queue,
+// dropping stats when it falls behind and recovering its panics, so
+// it can't slow down or break the primary path.
+type TeePool struct {
+	primary   Stater
+	secondary *MultiPool
+}
+
+func NewTeePool(primary, secondary Stater) *TeePool {
+	return &TeePool{
+		primary:   primary,
+		secondary: NewMultiPool(secondary),
+	}
+}
+
+// SetLogger sets where the secondary's drops and failures are logged
+func (t *TeePool) SetLogger(l *log.Logger) {
+	t.secondary.SetLogger(l)
+}
+
+func (t *TeePool) Count(key string, val float64) {
+	t.primary.Count(key, val)
+	t.secondary.Count(key, val)
+}
+
+func (t *TeePool) Value(key string, val float64, timestamp time.Time) {
+	t.primary.Value(key, val, timestamp)
+	t.secondary.Value(key, val, timestamp)
+}
+
+func (t *TeePool) Duration(key string, val time.Duration) {
+	t.primary.Duration(key, val)
+	t.secondary.Duration(key, val)
+}
+
+// Gauge is sent as a value to Staters without gauges
+func (t *TeePool) Gauge(key string, val float64) {
+	if g, ok := t.primary.(gauger); ok {
+		g.Gauge(key, val)
+	} else {
+		t.primary.Value(key, val, time.Now())
+	}
+	t.secondary.Gauge(key, val)
+}
+
+// Histogram is sent as a value to Staters without histograms
+func (t *TeePool) Histogram(key string, val float64) {
+	if h, ok := t.primary.(histogrammer); ok {
+		h.Histogram(key, val)
+	} else {
+		t.primary.Value(key, val, time.Now())
+	}
+	t.secondary.Histogram(key, val)
+}
+
+func (t *TeePool) DurationSince(key string, start time.Time) {
+	t.Duration(key, time.Since(start))
+}
+
+func (t *TeePool) TimeCaller(start time.Time) {
+	t.Duration(callerKey(1), time.Since(start))
+}
+
+// SampledDuration samples once so both Staters get the same calls
+func (t *TeePool) SampledDuration(key string, val time.Duration, rate float64) {
+	if _, ok := sample(key, rate); ok {
+		t.Duration(key, val)
+	}
+}
+
+func (t *TeePool) Timer(key string) *Timer {
+	return NewTimer(t, key)
+}
+
+func (t *TeePool) Time(key string) func() {
+	tm := t.Timer(key)
+	return func() { tm.Stop() }
+}
+
+// Close waits for queued stats to be handed to the secondary.  It
+// doesn't stop or close either Stater.
+func (t *TeePool) Close() {
+	t.secondary.Close()
+}
@@ -0,0 +1,186 @@
+package statpool
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// pause used when a 429 response has no usable Retry-After
+	defaultRetryAfter = time.Minute
+
+	// most stats held to resend by default, oldest batches
+	// are dropped beyond this
+	DefaultHoldLimit = 100000
+)
+
+var errThrottled = errors.New("Throttled by endpoint")
+
+// batch is a chunk of stats sent in one request.  The id is
+// kept across resends of the same batch.
+type batch struct {
+	id      string
+	ezKey   string
+	stats   []interface{}
+	created time.Time
+}
+
+// parseRetryAfter reads a Retry-After header given in
+// either delay seconds or as an http date
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	if secs, err := strconv.Atoi(header); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(header); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d
+		}
+		return 0
+	}
+	return defaultRetryAfter
+}
+
+// throttle pauses sending for d
+func (p *Pool) throttle(d time.Duration) {
+	until := p.now().Add(d).UnixNano()
+	for {
+		current := atomic.LoadInt64(&p.resumeAt)
+		if current >= until || atomic.CompareAndSwapInt64(&p.resumeAt, current, until) {
+			return
+		}
+	}
+}
+
+// SetHoldLimit sets the most stats held to resend while the endpoint
+// is throttling or failing.  The oldest batches are spooled if there
+// is a spool, and otherwise dropped, when more are held.
+func (p *Pool) SetHoldLimit(n int) {
+	atomic.StoreInt64(&p.holdLimit, int64(n))
+}
+
+// throttled returns how much longer sending is paused for
+func (p *Pool) throttled() time.Duration {
+	return time.Unix(0, atomic.LoadInt64(&p.resumeAt)).Sub(p.now())
+}
+
+func (p *Pool) hold(batches ...*batch) {
+	p.heldMu.Lock()
+	p.held = append(p.held, batches...)
+
+	// spill what's past the memory limit, writing and reporting
+	// outside the lock
+	if o := p.getOverflow(); o != nil {
+		var spill []*batch
+		p.held, spill = o.overBudget(p.held)
+		if len(spill) > 0 {
+			p.heldMu.Unlock()
+			failed := p.spill(o, spill)
+			p.heldMu.Lock()
+			p.held = append(failed, p.held...)
+		}
+	}
+
+	var dropped []*batch
+	total, limit := 0, int(atomic.LoadInt64(&p.holdLimit))
+	for _, b := range p.held {
+		total += len(b.stats)
+	}
+	for total > limit && len(p.held) > 0 {
+		dropped = append(dropped, p.held[0])
+		total -= len(p.held[0].stats)
+		p.held = p.held[1:]
+	}
+	p.heldMu.Unlock()
+
+	// spooled and reported outside the lock so error handlers can
+	// call Stats
+	for _, b := range dropped {
+		if p.spoolBatch(b) {
+			continue
+		}
+		p.report(fmt.Errorf("too many stats held to resend, dropping %d stats", len(b.stats)), len(b.stats))
+	}
+}
+
+// dropHeld gives up on held batches when the pool stops, saving them
+// with any spilled over the memory limit to the recovery file if there
+// is one, and otherwise leaving them in the overflow files or spool.
+// It returns an error for each batch not sent.
+func (p *Pool) dropHeld() []*ChunkError {
+
+	var (
+		held = p.takeHeld()
+		o    = p.getOverflow()
+	)
+	if p.recoveryFile != "" && o != nil {
+		spilled, err := o.take(0)
+		if err != nil {
+			p.report(fmt.Errorf("overflow read failed: %w", err), 0)
+		}
+		held = append(spilled, held...)
+	}
+
+	var (
+		unsent []*ChunkError
+		left   = func(batches []*batch, err error) {
+			for _, b := range batches {
+				unsent = append(unsent, &ChunkError{ID: b.id, Stats: len(b.stats), Err: err})
+			}
+		}
+	)
+
+	if len(held) == 0 && p.recoveryFile != "" {
+		p.clearRecovered()
+	}
+	if len(held) > 0 && p.recoveryFile != "" {
+		err := p.saveRecovery(held)
+		if err == nil {
+			if p.devlogger != nil {
+				p.devlogger.Printf("saved %d batches to %s", len(held), p.recoveryFile)
+			}
+			left(held, fmt.Errorf("%w, saved to %s", ErrStoppedUnsent, p.recoveryFile))
+			return unsent
+		}
+		p.report(fmt.Errorf("recovery file not written: %w", err), 0)
+	}
+	if o != nil && len(held) > 0 {
+		failed := p.spill(o, held)
+		left(held[:len(held)-len(failed)], fmt.Errorf("%w, spilled to %s", ErrStoppedUnsent, o.dir))
+		held = failed
+	}
+
+	for _, b := range held {
+		if p.spoolBatch(b) {
+			left([]*batch{b}, fmt.Errorf("%w, spooled", ErrStoppedUnsent))
+			continue
+		}
+		left([]*batch{b}, ErrStoppedUnsent)
+		p.report(fmt.Errorf("stopped with %d stats unsent", len(b.stats)), len(b.stats))
+		p.logUnprocessed(b)
+	}
+	return unsent
+
+}
+
+// heldStats counts the stats held to resend
+func (p *Pool) heldStats() int64 {
+	p.heldMu.Lock()
+	defer p.heldMu.Unlock()
+	var n int64
+	for _, b := range p.held {
+		n += int64(len(b.stats))
+	}
+	return n
+}
+
+func (p *Pool) takeHeld() []*batch {
+	p.heldMu.Lock()
+	held := p.held
+	p.held = nil
+	p.heldMu.Unlock()
+	return held
+}
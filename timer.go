@@ -0,0 +1,43 @@
+package statpool
+
+import "time"
+
+// Timer records the time from its start to Stop as a duration
+//
+//	t := pool.Timer("db.query")
+//	rows, err := db.Query(q)
+//	t.Stop()
+type Timer struct {
+	s       Stater
+	key     string
+	start   time.Time
+	stopped bool
+}
+
+// NewTimer starts a timer recording to any Stater
+func NewTimer(s Stater, key string) *Timer {
+	return &Timer{s: s, key: key, start: time.Now()}
+}
+
+// Stop records and returns the elapsed time.  Only the first call
+// records anything.
+func (t *Timer) Stop() time.Duration {
+	d := time.Since(t.start)
+	if !t.stopped {
+		t.stopped = true
+		t.s.Duration(t.key, d)
+	}
+	return d
+}
+
+func (p *Pool) Timer(key string) *Timer {
+	return NewTimer(p, key)
+}
+
+// Time starts a timer and returns its stop function
+//
+//	defer pool.Time("handler")()
+func (p *Pool) Time(key string) func() {
+	t := p.Timer(key)
+	return func() { t.Stop() }
+}
@@ -0,0 +1,143 @@
+package statpool
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"text/template"
+	"time"
+)
+
+type (
+	// WebhookSender posts each flush as JSON to a URL, by default
+	//
+	//	{"time": "...", "stats": [{"key": "...", "type": "count", "value": 1, "time": "..."}, ...]}
+	//
+	// or as rendered by a template given a WebhookPayload.  Responses
+	// of 5xx are retried by the pool, other failures aren't.
+	WebhookSender struct {
+		url    string
+		client *http.Client
+		header http.Header
+		tmpl   *template.Template
+	}
+
+	// WebhookPayload is the flush rendered by a webhook's template
+	WebhookPayload struct {
+		Time  time.Time     `json:"time"`
+		Stats []WebhookStat `json:"stats"`
+	}
+
+	WebhookStat struct {
+		Key   string    `json:"key"`
+		Type  string    `json:"type"`
+		Value float64   `json:"value"`
+		Time  time.Time `json:"time"`
+		Tags  []Tag     `json:"tags,omitempty"`
+	}
+)
+
+// WebhookFuncs are available to webhook templates, json rendering
+// its argument as JSON:
+//
+//	{"source": "api", "metrics": {{json .Stats}}}
+var WebhookFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// NewWebhookPool returns a pool that posts each flush to url with
+// header set on each request.  A nil tmpl posts the default JSON.
+func NewWebhookPool(url string, flushInterval time.Duration, header http.Header, tmpl *template.Template) *Pool {
+	w := NewWebhookSender(url)
+	for key, vals := range header {
+		w.header[http.CanonicalHeaderKey(key)] = vals
+	}
+	w.tmpl = tmpl
+	return NewPoolWithOptions(
+		WithFlushInterval(flushInterval),
+		WithSender(w),
+	)
+}
+
+func NewWebhookSender(url string) *WebhookSender {
+	return &WebhookSender{
+		url:    url,
+		client: &http.Client{Timeout: DefaultRequestTimeout},
+		header: http.Header{"Content-Type": {ContentTypeJSON}},
+	}
+}
+
+// SetHeader sets a header sent with each request
+func (w *WebhookSender) SetHeader(key, value string) {
+	w.header.Set(key, value)
+}
+
+// SetTemplate renders the body of each request with tmpl, executed
+// with a WebhookPayload.  Parse it with WebhookFuncs for json.
+func (w *WebhookSender) SetTemplate(tmpl *template.Template) {
+	w.tmpl = tmpl
+}
+
+func (w *WebhookSender) Send(ctx context.Context, stats []interface{}) error {
+
+	body := &bytes.Buffer{}
+	payload := webhookPayload(stats, time.Now())
+	if w.tmpl != nil {
+		if err := w.tmpl.Execute(body, payload); err != nil {
+			return permanentError{&EncodeError{err}}
+		}
+	} else if err := json.NewEncoder(body).Encode(payload); err != nil {
+		return permanentError{&EncodeError{err}}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", w.url, body)
+	if err != nil {
+		return permanentError{err}
+	}
+	for key, vals := range w.header {
+		req.Header[key] = vals
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return &TransportError{err}
+	}
+	defer resp.Body.Close()
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+
+	switch {
+	case resp.StatusCode/100 == 2:
+		return nil
+	case resp.StatusCode >= 500:
+		return fmt.Errorf("webhook: %w: %s", &HTTPStatusError{resp.StatusCode}, msg)
+	}
+	return permanentError{fmt.Errorf("webhook: %w: %s", &HTTPStatusError{resp.StatusCode}, msg)}
+
+}
+
+func webhookPayload(stats []interface{}, now time.Time) WebhookPayload {
+	payload := WebhookPayload{Time: now, Stats: make([]WebhookStat, 0, len(stats))}
+	for _, stat := range stats {
+		var s Stat
+		switch st := stat.(type) {
+		case *CountStat:
+			s = countStat(st)
+			if st.Timestamp != 0 {
+				s.Time = time.Unix(st.Timestamp, 0)
+			}
+		case *ValueStat:
+			s = valueStat(st)
+		default:
+			continue
+		}
+		payload.Stats = append(payload.Stats, WebhookStat{Key: s.Key, Type: s.Type.String(), Value: s.Value, Time: s.Time, Tags: s.Tags})
+	}
+	return payload
+}
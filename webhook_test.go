@@ -0,0 +1,62 @@
+package statpool
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestWebhookPool(t *testing.T) {
+
+	type request struct {
+		header http.Header
+		body   []byte
+	}
+	requests := make(chan request, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- request{r.Header, body}
+	}))
+	defer server.Close()
+
+	stats := NewWebhookPool(server.URL, time.Hour, http.Header{"X-Token": {"darts"}}, nil)
+	stats.Count("throws", 3)
+	if err := stats.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	req := <-requests
+	if req.header.Get("X-Token") != "darts" || req.header.Get("Content-Type") != ContentTypeJSON {
+		t.Errorf("Unexpected headers: %v", req.header)
+	}
+	var payload WebhookPayload
+	if err := json.Unmarshal(req.body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if len(payload.Stats) != 1 || payload.Stats[0].Key != "throws" || payload.Stats[0].Type != "count" || payload.Stats[0].Value != 3 {
+		t.Errorf("Unexpected payload: %s", req.body)
+	}
+	stats.Stop()
+
+	tmpl := template.Must(template.New("").Funcs(WebhookFuncs).Parse(`{"source":"test","metrics":{{json .Stats}}}`))
+	stats = NewWebhookPool(server.URL, time.Hour, nil, tmpl)
+	stats.Value("score", 180, time.Now())
+	if err := stats.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	var custom struct {
+		Source  string
+		Metrics []WebhookStat
+	}
+	if err := json.Unmarshal((<-requests).body, &custom); err != nil {
+		t.Fatal(err)
+	}
+	if custom.Source != "test" || len(custom.Metrics) != 1 || custom.Metrics[0].Value != 180 {
+		t.Errorf("Unexpected payload: %+v", custom)
+	}
+	stats.Stop()
+
+}
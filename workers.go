@@ -0,0 +1,51 @@
+package statpool
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// DefaultMaxSends is how many requests a pool sends at once unless
+// set otherwise
+const DefaultMaxSends = 8
+
+// SetMaxSends limits how many chunks of a flush are sent at once,
+// the rest queue for a free worker.  It must be called before the
+// pool is used.
+func (p *Pool) SetMaxSends(n int) {
+	if n < 1 {
+		n = 1
+	}
+	p.sendSlots = make(chan struct{}, n)
+}
+
+// acquireSend waits for a free send worker, or until ctx is done
+func (p *Pool) acquireSend(ctx context.Context) error {
+	select {
+	case p.sendSlots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	// track the queue depth and its peak since it was last reported
+	c := &p.counters
+	queued := atomic.AddInt64(&c.sendQueue, 1)
+	defer atomic.AddInt64(&c.sendQueue, -1)
+	for {
+		peak := atomic.LoadInt64(&c.peakSendQueue)
+		if queued <= peak || atomic.CompareAndSwapInt64(&c.peakSendQueue, peak, queued) {
+			break
+		}
+	}
+
+	select {
+	case p.sendSlots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Pool) releaseSend() {
+	<-p.sendSlots
+}